@@ -0,0 +1,119 @@
+package amqp
+
+import (
+	"fmt"
+	"math/rand"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Ack policies supported by ListenOptions.AckPolicy.
+const (
+	ackPolicyAuto   = "auto"
+	ackPolicyManual = "manual"
+	ackPolicyBatch  = "batch"
+)
+
+// Requeue behaviors of the deliveries whose listener failed, see ListenOptions.ErrorRequeue.
+const (
+	errorRequeueOnce   = "once"
+	errorRequeueAlways = "always"
+	errorRequeueNever  = "never"
+)
+
+// acker settles deliveries of a single consumer according to its ack policy.
+// It is not safe for concurrent use.
+type acker struct {
+	policy       string
+	batchSize    int
+	nackRate     float64
+	requeue      bool
+	errorRequeue string
+	pending      int
+	last         amqpDriver.Delivery // last delivery of the pending batch
+	inflight     *inflightTracker
+	profile      *brokerProfile
+}
+
+// newAcker validates the listen options and returns the acker settling its deliveries.
+func newAcker(options ListenOptions, profile *brokerProfile) (*acker, error) {
+	a := &acker{
+		profile:   profile,
+		policy:    options.AckPolicy,
+		batchSize: options.AckBatchSize,
+		nackRate:  options.NackRate,
+		requeue:   options.NackRequeue,
+
+		errorRequeue: options.ErrorRequeue,
+	}
+
+	switch a.policy {
+	case "", ackPolicyAuto, ackPolicyManual:
+	case ackPolicyBatch:
+		if a.batchSize <= 0 {
+			return nil, fmt.Errorf("ack policy %q requires a positive ack_batch_size", ackPolicyBatch)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ack policy %q, expected one of auto, manual, batch", a.policy)
+	}
+	switch a.errorRequeue {
+	case "":
+		a.errorRequeue = errorRequeueOnce
+	case errorRequeueOnce, errorRequeueAlways, errorRequeueNever:
+	default:
+		return nil, fmt.Errorf("unsupported error requeue %q, expected one of once, always, never", a.errorRequeue)
+	}
+	if a.nackRate < 0 || a.nackRate > 1 {
+		return nil, fmt.Errorf("nack rate must be between 0 and 1, got %v", a.nackRate)
+	}
+	return a, nil
+}
+
+// autoAck reports whether the broker should consider deliveries acknowledged once sent.
+func (a *acker) autoAck(legacy bool) bool {
+	if a.policy == "" {
+		return legacy
+	}
+	return a.policy == ackPolicyAuto
+}
+
+// settle acknowledges or rejects a processed delivery and reports whether it was rejected. A delivery whose
+// processing failed is requeued as configured, by default unless it is already a redelivery so that a message
+// failing every time is dead lettered instead of looping.
+func (a *acker) settle(d amqpDriver.Delivery, processErr error) (bool, error) {
+	if a.policy != ackPolicyManual && a.policy != ackPolicyBatch {
+		return false, nil
+	}
+
+	if processErr != nil {
+		requeue := a.errorRequeue == errorRequeueAlways || (a.errorRequeue == errorRequeueOnce && !d.Redelivered)
+		a.inflight.settle(d.DeliveryTag, false)
+		return true, a.profile.reject(d, requeue)
+	}
+	if a.nackRate > 0 && rand.Float64() < a.nackRate { //nolint:gosec
+		a.inflight.settle(d.DeliveryTag, false)
+		return true, a.profile.reject(d, a.requeue)
+	}
+
+	if a.policy == ackPolicyManual {
+		a.inflight.settle(d.DeliveryTag, false)
+		return false, d.Ack(false)
+	}
+	a.pending++
+	a.last = d
+	if a.pending < a.batchSize {
+		return false, nil
+	}
+	return false, a.flush()
+}
+
+// flush acknowledges the deliveries of the pending batch, once full or when the consumer stops, so that the
+// trailing deliveries are not left to the broker to redeliver.
+func (a *acker) flush() error {
+	if a.pending == 0 {
+		return nil
+	}
+	a.pending = 0
+	a.inflight.settle(a.last.DeliveryTag, true)
+	return a.last.Ack(true)
+}
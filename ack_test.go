@@ -0,0 +1,91 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingAcknowledger records how the deliveries are settled.
+type recordingAcknowledger struct {
+	settled []string
+}
+
+func (r *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	r.settled = append(r.settled, fmt.Sprintf("ack %d multiple=%v", tag, multiple))
+	return nil
+}
+
+func (r *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	r.settled = append(r.settled, fmt.Sprintf("nack %d requeue=%v", tag, requeue))
+	return nil
+}
+
+func (r *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return r.Nack(tag, false, requeue)
+}
+
+func TestAckerFlushesTrailingBatch(t *testing.T) {
+	t.Parallel()
+
+	a, err := newAcker(ListenOptions{AckPolicy: ackPolicyBatch, AckBatchSize: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingAcknowledger{}
+	for tag := uint64(1); tag <= 5; tag++ {
+		if _, err = a.settle(amqpDriver.Delivery{Acknowledger: recorder, DeliveryTag: tag}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = a.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ack 3 multiple=true", "ack 5 multiple=true"}
+	if !reflect.DeepEqual(recorder.settled, expected) {
+		t.Errorf("settled %v, expected %v", recorder.settled, expected)
+	}
+}
+
+func TestAckerErrorRequeue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		errorRequeue string
+		expected     []string
+	}{
+		{"", []string{"nack 1 requeue=true", "nack 2 requeue=false"}},
+		{errorRequeueOnce, []string{"nack 1 requeue=true", "nack 2 requeue=false"}},
+		{errorRequeueAlways, []string{"nack 1 requeue=true", "nack 2 requeue=true"}},
+		{errorRequeueNever, []string{"nack 1 requeue=false", "nack 2 requeue=false"}},
+	}
+	for _, tt := range tests {
+		a, err := newAcker(ListenOptions{AckPolicy: ackPolicyManual, ErrorRequeue: tt.errorRequeue}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := &recordingAcknowledger{}
+		failure := errors.New("listener failed")
+		for _, d := range []amqpDriver.Delivery{
+			{Acknowledger: recorder, DeliveryTag: 1},
+			{Acknowledger: recorder, DeliveryTag: 2, Redelivered: true},
+		} {
+			if nacked, err := a.settle(d, failure); !nacked || err != nil {
+				t.Fatalf("%q: nacked %v, %v", tt.errorRequeue, nacked, err)
+			}
+		}
+		if !reflect.DeepEqual(recorder.settled, tt.expected) {
+			t.Errorf("%q: settled %v, expected %v", tt.errorRequeue, recorder.settled, tt.expected)
+		}
+	}
+
+	if _, err := newAcker(ListenOptions{ErrorRequeue: "sometimes"}, nil); err == nil {
+		t.Error("accepted an unknown error requeue")
+	}
+}
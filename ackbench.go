@@ -0,0 +1,159 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Ack modes compared by CompareAckModes.
+const (
+	ackModeAuto    = "auto"
+	ackModeSingle  = "single"
+	ackModeBatched = "batched"
+)
+
+// AckComparisonOptions defines the workload consumed once per ack mode.
+type AckComparisonOptions struct {
+	QueueName  string // queue purged and filled before each run
+	Messages   int    // messages published and consumed per ack mode, 10000 by default
+	BodySize   int    // size of every message body, 1024 by default
+	BatchSize  int    // deliveries acknowledged at once in batched mode, 100 by default
+	Prefetch   int    // unacknowledged deliveries allowed, 0 (unlimited) by default
+	TimeoutSec int    // how long a single run may take, 60 by default
+}
+
+// AckModeResult holds the outcome of consuming the workload with a single ack mode.
+type AckModeResult struct {
+	Mode       string
+	Messages   int
+	Duration   float64 // milliseconds
+	Throughput float64 // messages per second
+	Latency    float64 // average milliseconds per message
+}
+
+// CompareAckModes consumes an identical workload with auto ack, per-message ack and batched ack,
+// emitting amqp_ack_mode_throughput and amqp_ack_mode_duration tagged by ack_mode.
+func (amqp *AMQP) CompareAckModes(options AckComparisonOptions) (results []AckModeResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if options.Messages <= 0 {
+		options.Messages = 10000
+	}
+	if options.BodySize <= 0 {
+		options.BodySize = 1024
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.TimeoutSec <= 0 {
+		options.TimeoutSec = 60
+	}
+
+	results = make([]AckModeResult, 0, 3)
+	for _, mode := range []string{ackModeAuto, ackModeSingle, ackModeBatched} {
+		if err := amqp.fillQueue(options.QueueName, options.Messages, options.BodySize); err != nil {
+			return results, err
+		}
+		result, err := amqp.consumeWithAckMode(options, mode)
+		if err != nil {
+			return results, err
+		}
+
+		tags := map[string]string{"queue": options.QueueName, "ack_mode": mode}
+		amqp.metrics.push(amqp.metrics.AckModeThroughput, result.Throughput, tags)
+		amqp.metrics.push(amqp.metrics.AckModeDuration, result.Duration, tags)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// fillQueue purges the queue and publishes count messages of the given size to it.
+func (amqp *AMQP) fillQueue(queueName string, count int, size int) error {
+	ch, err := amqp.channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	if _, err = ch.QueuePurge(queueName, false); err != nil {
+		return err
+	}
+	if err = ch.Confirm(false); err != nil {
+		return err
+	}
+
+	publishing := amqpDriver.Publishing{Body: amqp.random.text(size)}
+	var last *amqpDriver.DeferredConfirmation
+	for i := 0; i < count; i++ {
+		if last, err = ch.PublishWithDeferredConfirmWithContext(amqp.vu.Context(), "", queueName, false, false, publishing); err != nil {
+			return err
+		}
+	}
+	// Confirms are sent in order, so the whole workload is enqueued once the last one arrives.
+	if last != nil && !last.Wait() {
+		return fmt.Errorf("broker rejected the workload published to %q", queueName)
+	}
+	return nil
+}
+
+// consumeWithAckMode consumes the whole workload acknowledging deliveries with the given mode.
+func (amqp *AMQP) consumeWithAckMode(options AckComparisonOptions, mode string) (AckModeResult, error) {
+	result := AckModeResult{Mode: mode}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	if err = ch.Qos(options.Prefetch, 0, false); err != nil {
+		return result, err
+	}
+
+	msgs, err := ch.Consume(options.QueueName, "", mode == ackModeAuto, false, false, false, nil)
+	if err != nil {
+		return result, err
+	}
+
+	timeout := time.NewTimer(time.Duration(options.TimeoutSec) * time.Second)
+	defer timeout.Stop()
+
+	start := time.Now()
+	for result.Messages < options.Messages {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return result, amqpDriver.ErrClosed
+			}
+			result.Messages++
+			switch {
+			case mode == ackModeSingle:
+				err = d.Ack(false)
+			case mode == ackModeBatched && (result.Messages%options.BatchSize == 0 || result.Messages == options.Messages):
+				err = d.Ack(true)
+			}
+			if err != nil {
+				return result, err
+			}
+		case <-amqp.vu.Context().Done():
+			return result, amqp.vu.Context().Err()
+		case <-timeout.C:
+			return result, fmt.Errorf("%s ack run consumed %d of %d messages within %ds",
+				mode, result.Messages, options.Messages, options.TimeoutSec)
+		}
+	}
+
+	result.Duration = sinceMs(start)
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Messages) / result.Duration * 1000
+		result.Latency = result.Duration / float64(result.Messages)
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+)
+
+// Broker resources an alarm can be raised for.
+const (
+	alarmMemory = "memory"
+	alarmDisk   = "disk"
+)
+
+const alarmPollInterval = 500 * time.Millisecond
+
+// BrokerAlarm is a resource alarm raised on a cluster node.
+type BrokerAlarm struct {
+	Node     string
+	Resource string // memory or disk
+}
+
+// Alarms returns the resource alarms currently raised in the cluster.
+func (management *Management) Alarms() ([]BrokerAlarm, error) {
+	nodes, err := management.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	alarms := []BrokerAlarm{}
+	for _, node := range nodes {
+		name, _ := node["name"].(string)
+		if raised, _ := node["mem_alarm"].(bool); raised {
+			alarms = append(alarms, BrokerAlarm{Node: name, Resource: alarmMemory})
+		}
+		if raised, _ := node["disk_free_alarm"].(bool); raised {
+			alarms = append(alarms, BrokerAlarm{Node: name, Resource: alarmDisk})
+		}
+	}
+	return alarms, nil
+}
+
+// WaitForAlarm polls the cluster until the resource alarm is raised, or cleared, within the timeout.
+func (management *Management) WaitForAlarm(resource string, raised bool, timeoutSec int) error {
+	deadline := time.Now().Add(time.Duration(timeoutSec) * time.Second)
+	for {
+		alarms, err := management.Alarms()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, alarm := range alarms {
+			found = found || alarm.Resource == resource
+		}
+		if found == raised {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s alarm not in the expected state within %ds", resource, timeoutSec)
+		}
+		if err = sleepContext(management.vu.Context(), alarmPollInterval); err != nil {
+			return err
+		}
+	}
+}
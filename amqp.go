@@ -2,48 +2,214 @@
 package amqp
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	amqpDriver "github.com/rabbitmq/amqp091-go"
 	"github.com/vmihailenco/msgpack/v5"
+	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	"golang.org/x/time/rate"
 )
 
 const version = "v0.3.0"
 
+// RootModule is the global module registered with k6. It keeps one AMQP
+// instance per VU so the amqp, queue and exchange imports share a connection.
+type RootModule struct {
+	instances sync.Map // modules.VU -> *AMQP
+	budget    errorBudget
+	events    eventFiles
+	pools     connectionPools
+	retries   retryTracker
+	tracked   correlationTracker
+	loopback  loopbackBroker
+	protos    protoTypes
+	registry  schemaRegistry
+	watches   queueWatches
+	ordering  orderingVerifier
+	nodes     nodeBalancer
+	fixtures  fixtureFiles
+	chunks    chunkAssembler
+	dedups    duplicateDetector
+}
+
+// ModuleInstance exposes one of the AMQP objects to a single VU.
+type ModuleInstance struct {
+	exports interface{}
+}
+
+// submodule registers a single import path backed by the shared RootModule.
+type submodule struct {
+	root    *RootModule
+	exports func(*AMQP) interface{}
+}
+
 // AMQP type holds connection to a remote AMQP server.
 type AMQP struct {
 	Version    string
-	Connection *amqpDriver.Connection
 	Queue      *Queue
 	Exchange   *Exchange
+	Management *Management
+	Stomp      *WebStomp
+	Mqtt       *Mqtt
+
+	vu             modules.VU
+	connection     *sessionConnection
+	metrics        *amqpMetrics
+	options        Options
+	token          *oauth2Token
+	payloadSeq     int64
+	random         *payloadRand // source of the generated payloads
+	tracer         *tracer
+	budget         *errorBudget
+	eventFiles     *eventFiles
+	pools          *connectionPools
+	retries        *retryTracker
+	tracked        *correlationTracker
+	loopback       *loopbackBroker
+	protos         *protoTypes
+	registry       *schemaRegistry
+	watches        *queueWatches
+	ordering       *orderingVerifier
+	sequences      publishSequences
+	channelErrors  channelErrors
+	nodes          *nodeBalancer
+	nodeOffset     int
+	fixtures       *fixtureFiles
+	scriptDir      string // directory of the script, see scriptPath
+	chunks         *chunkAssembler
+	duplicates     *duplicateDetector
+	profile        *brokerProfile
+	events         *eventLog
+	inflight       sync.Map // *inflightTracker -> struct{}
+	publishLimiter *rate.Limiter
 }
 
 // Options defines configuration options for an AMQP session.
 type Options struct {
-	ConnectionURL string
+	ConnectionURL    string
+	Broker           string  // broker profile: rabbitmq, lavinmq, qpid or loopback, detected from the server properties if empty
+	PublishRate      float64 // messages per second allowed for this VU, 0 (unlimited) by default
+	PublishBurst     int     // messages allowed to be published at once, 1 by default
+	AuthMechanism    string  // SASL mechanism: PLAIN (default), AMQPLAIN or EXTERNAL
+	Username         string  // overrides the user of the connection URL
+	Password         string  // overrides the password of the connection URL
+	TLS              TLSOptions
+	Locale           string           // locale requested in the handshake, en_US by default
+	ClientProperties amqpDriver.Table // handshake properties (product, version, platform, connection_name...) merged over the defaults
+	DisableMetrics   []string         // metric families not to emit: publish, consume, depth, workflow, topology, connection, stomp, mqtt, e2e, sizes
+	PublishDefaults  PublishDefaults
+	OAuth2           OAuth2Options // obtains the password as a JWT from an OAuth2 token endpoint
+	Reconnect        ReconnectOptions
+	Tracing          TracingOptions     // exports OpenTelemetry spans of the AMQP operations over OTLP/HTTP
+	ErrorBudget      ErrorBudgetOptions // errors tolerated by the whole test run, shared by all the VUs
+	EventLog         string             // "logger" or a file receiving connect, disconnect, declare, error and alarm events as JSON
+	RunMetadata      bool               // stamp x-k6-* headers with the test run id, scenario, VU and iteration on every message
+	TestRunId        string             // test run id sent with RunMetadata, generated once per k6 process if empty
+	ShadowHeader     string             // header set to true on every message to flag synthetic traffic, e.g. x-shadow
+	Timeouts         TimeoutOptions     // bounds channel opens, consumer starts, publishes and confirm waits
+
+	// SchemaRegistry resolves the Avro schemas of AvroSubject and of consumed Avro bodies.
+	SchemaRegistry SchemaRegistryOptions
+
+	// Loopback connects to an in-process broker shared by the VUs instead of ConnectionURL, so that scripts can be
+	// tested without a real broker. See the capabilities of ServerProperties for what it does not simulate.
+	Loopback bool
+
+	// SharedConnections opens this many connections for all the VUs starting a session with the same URL and
+	// user instead of one per VU, each VU taking the next one in turn. 0 (one connection per VU) by default.
+	// They are recovered as Reconnect configures and closed once no running iteration uses them.
+	SharedConnections int
+
+	// ConnectionURLs lists the nodes of a cluster, tried after ConnectionURL, in order or in a random order with
+	// ConnectionOrder set to random, until one accepts the connection, on connect and on reconnect.
+	ConnectionURLs  []string
+	ConnectionOrder string
+
+	// ResolveNodes resolves the host of the connection URLs to all its addresses, e.g. the pods of a Kubernetes
+	// headless service, on every connect. The sessions are spread across the addresses round-robin, the other
+	// addresses being tried on failure.
+	ResolveNodes bool
+
+	// Tags are added to every sample of the session, on top of the tags of the VU and of the scenario.
+	Tags map[string]string
+	// RoutingKeyTag adds the routing key of the messages as the routing_key tag of the publish metrics. Off by
+	// default, keys carrying ids would make a time series of every message.
+	RoutingKeyTag bool
+
+	// Queues lists the queues of interest. Their depth is sampled as amqp_queue_depth every QueueDepthIntervalMs
+	// (1000 by default), by a single VU at a time.
+	Queues               []string
+	QueueDepthIntervalMs int
+}
+
+// PublishDefaults defines message properties merged into every message published in the session.
+type PublishDefaults struct {
+	AppId      string
+	UserId     string
+	TypePrefix string           // prepended to the message type
+	Headers    amqpDriver.Table // overridden by headers of the same name set on a message
 }
 
 // PublishOptions defines a message payload with delivery options.
 type PublishOptions struct {
-	QueueName     string
-	Body          string
-	Headers       amqpDriver.Table
-	Exchange      string
-	ContentType   string
-	Mandatory     bool
-	Immediate     bool
-	Persistent    bool
-	CorrelationId string
-	ReplyTo       string
-	Expiration    string
-	MessageId     string
-	Timestamp     int64 // unix epoch timestamp in seconds
-	Type          string
-	UserId        string
-	AppId         string
+	QueueName        string // routing key used when RoutingKey is empty, e.g. with the default exchange
+	RoutingKey       string
+	DelayMs          int64  // delivery delay, sent as the x-delay header to x-delayed-message exchanges
+	Compression      string // compresses the body and sets the content encoding: gzip, deflate or zstd
+	Body             string
+	Headers          amqpDriver.Table
+	Exchange         string
+	ContentType      string
+	Mandatory        bool
+	Immediate        bool
+	Persistent       bool
+	CorrelationId    string
+	ReplyTo          string
+	Expiration       string // per-message TTL in milliseconds as a string, see TTLMillis
+	TTLMillis        int64  // per-message TTL, sets Expiration
+	MessageId        string
+	Timestamp        int64 // unix epoch timestamp in seconds
+	Type             string
+	UserId           string
+	AppId            string
+	Payload          PayloadOptions // generates the body natively instead of using Body
+	Confirm          bool           // wait for a publisher confirm and classify failures
+	ConfirmTimeoutMs int            // how long to wait for the confirm, timeouts.confirm_ms or 5000 by default
+	Retry            PublishRetry   // republish after a confirm timeout, a closed channel or the failures listed, see amqp_publish_retries
+	Track            bool           // register the message with the correlation tracker, see VerifyTracked
+	Sequence         bool           // stamp a sequence number per routing key, checked on consume, see amqp_sequence_gaps
+	ChunkSize        int            // split the body over messages of at most this many bytes, see Reassemble
+
+	// DeduplicationId sets the x-deduplication-header header, the identity of the message for the message
+	// deduplication plugin, and DeduplicationTTLMs sets x-cache-ttl, how long an x-message-deduplication
+	// exchange remembers it instead of the TTL of its cache.
+	DeduplicationId    string
+	DeduplicationTTLMs int64
+
+	AutoCorrelationId bool     // generate a uuid correlation id when CorrelationId is empty, returned by Publish
+	InReplyTo         *Message // request answered, its correlation id and reply-to queue are used unless set
+
+	// ProtoMessage encodes Body, JSON in the protobuf JSON mapping, as this message of a descriptor set loaded
+	// with LoadProtoDescriptors. The content type and the type default to application/x-protobuf and the message.
+	ProtoMessage string
+
+	// AvroSubject encodes Body, JSON, with the Avro schema registered under this subject of the schema registry,
+	// framed with the magic byte and the schema id. The content type defaults to avro/binary.
+	AvroSubject string
+	AvroVersion int // version of the subject schema, the latest by default
+
+	// Tags are added to the publish metrics of the message along with its queue and exchange, and its routing
+	// key with Options.RoutingKeyTag.
+	Tags map[string]string
+
+	body []byte // content of a file published with PublishFromFile, used instead of Body
 }
 
 // ConsumeOptions defines options for use when consuming a message.
@@ -54,6 +220,23 @@ type ConsumeOptions struct {
 	NoLocal   bool
 	NoWait    bool
 	Args      amqpDriver.Table
+	Priority  int // consumer priority, sent as the x-priority consumer argument
+}
+
+// Message describes a message received from a queue along with its delivery metadata.
+type Message struct {
+	Body         string
+	Redelivered  bool
+	DeliveryTag  uint64
+	Exchange     string
+	RoutingKey   string
+	Headers      amqpDriver.Table
+	StreamOffset *int64 // offset of the message in a stream queue, null for other queues
+
+	CorrelationId string
+	ReplyTo       string
+	MessageId     string
+	ContentType   string
 }
 
 // ListenerType is the message handler implemented within JavaScript.
@@ -63,55 +246,404 @@ type ListenerType func(string) error
 type ListenOptions struct {
 	Listener  ListenerType
 	QueueName string
+	Partition string // partition label or index of a super stream / sharded queue, added as a metric tag
 	Consumer  string
 	AutoAck   bool
 	Exclusive bool
 	NoLocal   bool
 	NoWait    bool
 	Args      amqpDriver.Table
+
+	ConsumerArgs ConsumerArgs // typed stream offset and filter or priority arguments, merged into Args
+	Priority     int          // consumer priority: while it has prefetch capacity, the consumer is served before lower ones
+
+	DedupWindowMs int    // deliveries with an identity seen within this window are not passed to the listener
+	DedupHeader   string // header holding the message identity, the message id is used if empty
+
+	AckPolicy    string  // auto, manual (ack once the listener succeeds) or batch, AutoAck decides if empty
+	AckBatchSize int     // deliveries acknowledged at once with the batch policy
+	NackRate     float64 // fraction (0-1) of deliveries rejected with the manual and batch policies
+	NackRequeue  bool    // requeue deliveries rejected because of NackRate
+	ErrorRequeue string  // requeue of the deliveries whose listener failed: once (default, redeliveries are not requeued), always or never
+
+	// PoisonThreshold is the number of deliveries of a message after which it is caught in a redelivery loop
+	// and counted as amqp_poison_messages, see the message id or the x-delivery-count header of quorum queues.
+	// PoisonReject rejects such messages without requeue, dead lettering them if the queue has a dead letter
+	// exchange, instead of passing them to the listener again.
+	PoisonThreshold int
+	PoisonReject    bool
+
+	Schema map[string]interface{} // JSON Schema the bodies are checked against, see amqp_validation_failures
+	Filter ListenFilter           // deliveries not matching are acknowledged without calling the listener
+	Tags   map[string]string      // extra tags of the consume metrics
+
+	OnCancel ListenerType // called with the reason (cancel, timeout or channel_close) when the broker kicks the consumer
+
+	// Reassemble passes the bodies published with a chunk size to the listener once all their chunks are
+	// received, the chunks being acknowledged as they arrive.
+	Reassemble bool
+
+	// BufferSize bounds the deliveries waiting for a slow listener, which the driver would otherwise buffer
+	// without limit. OverflowPolicy applies once it is full: block (default), which also sets the prefetch of
+	// acknowledged consumers to the buffer size, drop-oldest or drop-new, see amqp_deliveries_dropped.
+	BufferSize     int
+	OverflowPolicy string
+
+	StallThresholdMs int     // silence after which the consumer is reported as stalled, see amqp_consumer_stalled
+	MaxRatePerSecond float64 // deliveries passed to the listener per second, see amqp_consume_rate
+	ProtoMessage     string  // protobuf message the bodies are decoded from to JSON, if empty the type of protobuf messages when loaded
+	Avro             bool    // decode schema registry framed Avro bodies to JSON, also done for the avro/binary content type with a schema registry
+
+	ProcessingDelay ProcessingDelay // simulated service time spent after the listener returns, before the delivery is settled
+	MaxMessages     int             // deliveries passed to the listener before the consumer is cancelled, unlimited by default
 }
 
 // GetOptions defines options for getting first message from an AMQP queue or wait some time for one if the queue is empty
 type GetOptions struct {
 	QueueName         string
+	Partition         string // partition label or index of a super stream / sharded queue, added as a metric tag
 	Consumer          string
 	Exclusive         bool
 	NoLocal           bool
 	NoWait            bool
 	Args              amqpDriver.Table
-	WaitingTimeoutSec int // how long to wait for the message if the queue is empty, 0 (do not wait) by default
+	ConsumerArgs      ConsumerArgs // typed stream offset and filter or priority arguments, merged into Args
+	WaitingTimeoutSec int          // how long to wait for the message if the queue is empty, 0 (do not wait) by default
+	ProtoMessage      string       // protobuf message the body is decoded from to JSON, if empty the type of protobuf messages when loaded
+	Avro              bool         // decode a schema registry framed Avro body to JSON, also done for the avro/binary content type with a schema registry
+	Reassemble        bool         // get chunks until a body published with a chunk size is complete, within the waiting timeout
 }
 
 const messagepack = "application/x-msgpack"
 
 // Start establishes a session with an AMQP server given the provided options.
-func (amqp *AMQP) Start(options Options) error {
-	conn, err := amqpDriver.Dial(options.ConnectionURL)
-	amqp.Connection = conn
-	amqp.Queue.Connection = conn
-	amqp.Exchange.Connection = conn
+func (amqp *AMQP) Start(options Options) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if err := amqp.metrics.disable(options.DisableMetrics); err != nil {
+		return err
+	}
+	amqp.metrics.tags = options.Tags
+	if _, ok := brokerProfiles[strings.ToLower(options.Broker)]; options.Broker != "" && !ok {
+		return fmt.Errorf("unknown broker %q, expected one of rabbitmq, lavinmq, qpid, loopback", options.Broker)
+	}
+
+	if options.Loopback {
+		if options.ConnectionURL, err = amqp.loopback.start(); err != nil {
+			return err
+		}
+		options.ConnectionURLs = nil
+	}
+	if err := checkConnectionOrder(options.ConnectionOrder); err != nil {
+		return err
+	}
+
+	amqp.options = options
+	if options.ResolveNodes {
+		amqp.nodeOffset = amqp.nodes.take()
+	}
+	if options.ErrorBudget != (ErrorBudgetOptions{}) {
+		amqp.budget.configure(options.ErrorBudget)
+	}
+	amqp.token = nil
+	if options.OAuth2.TokenURL != "" {
+		amqp.token = newOAuth2Token(options.OAuth2)
+		if err := amqp.token.fetch(amqp.vu.Context()); err != nil {
+			return err
+		}
+	}
+
+	amqp.tracer = nil
+	if options.Tracing.Endpoint != "" {
+		amqp.tracer = newTracer(options.Tracing)
+		go amqp.tracer.run(amqp.vu.Context())
+	}
+	amqp.Queue.tracer = amqp.tracer
+	amqp.Exchange.tracer = amqp.tracer
+
+	if amqp.events, err = amqp.newEventLog(options.EventLog); err != nil {
+		return err
+	}
+	amqp.Queue.events = amqp.events
+	amqp.Exchange.events = amqp.events
+	amqp.Queue.timeouts = options.Timeouts
+	amqp.Exchange.timeouts = options.Timeouts
+
+	amqp.publishLimiter = nil
+	if options.PublishRate > 0 {
+		burst := options.PublishBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		amqp.publishLimiter = rate.NewLimiter(rate.Limit(options.PublishRate), burst)
+	}
+
+	conn, opened, err := amqp.connect()
+	if err == nil {
+		if amqp.profile, err = newBrokerProfile(options.Broker, conn.Properties); err != nil {
+			return err
+		}
+		amqp.Queue.profile = amqp.profile
+		if err = amqp.watchQueues(options.Queues, options.QueueDepthIntervalMs); err != nil {
+			return err
+		}
+	}
+	if !opened {
+		// Reused shared connections were checked and are refreshed by the session which opened them.
+		return err
+	}
+
+	if err == nil && options.TLS.MinValidityDays > 0 {
+		chain, chainErr := amqp.serverChain()
+		if chainErr != nil {
+			return chainErr
+		}
+		if err = checkCertificateValidity(chain, options.TLS.MinValidityDays); err != nil {
+			return err
+		}
+	}
+	if err == nil && amqp.token != nil {
+		go amqp.token.refresh(amqp.vu.Context(), conn)
+	}
+	if err == nil && options.Reconnect.Enabled && options.SharedConnections <= 0 {
+		ctx := amqp.vu.Context()
+		go amqp.recoverOnClose(conn, func(conn *amqpDriver.Connection) {
+			closeOnDone(ctx, conn)
+			amqp.connection.set(conn)
+		})
+	}
 	return err
 }
 
-// Publish delivers the payload using options provided.
-func (amqp *AMQP) Publish(options PublishOptions) error {
-	ch, err := amqp.Connection.Channel()
+// connect opens the connection of the session, or takes the next connection of the shared pool. The second
+// result tells whether the connection was opened by this call, false when an open shared connection is reused.
+// A shared connection is recovered by one of the sessions using it at a time, the others following it.
+func (amqp *AMQP) connect() (*amqpDriver.Connection, bool, error) {
+	open := func() (*amqpDriver.Connection, error) {
+		span := amqp.tracer.start("connect", spanClient, "", map[string]string{"messaging.system": "rabbitmq"})
+		conn, url, err := amqp.dialNodes()
+		span.end(err)
+		amqp.events.emit("connect", map[string]interface{}{"host": brokerAddress(url), "error": err})
+		if err == nil {
+			amqp.events.watchConnection(conn)
+		}
+		return conn, err
+	}
+
+	ctx := amqp.vu.Context()
+	if amqp.options.SharedConnections <= 0 {
+		conn, err := open()
+		if err == nil {
+			closeOnDone(ctx, conn)
+		}
+		amqp.connection.set(conn)
+		return conn, true, err
+	}
+
+	// Shared connections outlive the iteration, a lost one is reopened by the next session taking it if not
+	// recovered.
+	pool := amqp.pools.get(amqp.options)
+	slot, opened, err := pool.acquire(ctx, open)
 	if err != nil {
-		return err
+		amqp.connection.set(nil)
+		return nil, false, err
+	}
+	amqp.connection.share(&slot.sessionConnection)
+	conn := slot.get()
+	if amqp.options.Reconnect.Enabled && pool.claimRecovery(slot) {
+		go func() {
+			defer pool.endRecovery(slot)
+			amqp.recoverOnClose(conn, func(conn *amqpDriver.Connection) {
+				pool.replace(slot, conn)
+			})
+		}()
+	}
+	return conn, opened, nil
+}
+
+// dial opens a new connection to the broker with the session options.
+func (amqp *AMQP) dial() (*amqpDriver.Connection, error) {
+	conn, _, err := amqp.dialNodes()
+	return conn, err
+}
+
+// dialNodes opens a new connection to the first node of the connection URLs accepting it, failing over to
+// the next ones, and returns the URL of the node. The error is the one of the last node tried.
+func (amqp *AMQP) dialNodes() (*amqpDriver.Connection, string, error) {
+	options := amqp.options
+	if amqp.token != nil {
+		options.Password = amqp.token.current()
+	}
+
+	urls := options.connectionURLs()
+	var err error
+	for i, url := range urls {
+		options.ConnectionURL = url
+		nodes := amqp.resolveNodes(url)
+		for j, node := range nodes {
+			var conn *amqpDriver.Connection
+			if conn, err = dial(amqp.vu.Context(), options, node); err == nil {
+				return conn, url, nil
+			}
+			if i < len(urls)-1 || j < len(nodes)-1 {
+				amqp.events.emit("failover", map[string]interface{}{"host": brokerAddress(url), "node": node, "error": err})
+			}
+		}
+	}
+	return nil, options.ConnectionURL, err
+}
+
+// ServerProperties returns the properties the broker advertised in the handshake, such as its product,
+// version and capabilities, to compare brokers or check a capability before relying on it.
+func (amqp *AMQP) ServerProperties() (amqpDriver.Table, error) {
+	conn := amqp.connection.get()
+	if conn == nil {
+		return nil, errors.New("no connection, call start first")
+	}
+	return conn.Properties, nil
+}
+
+// Publish delivers the payload using options provided and returns the correlation id of the message.
+func (amqp *AMQP) Publish(options PublishOptions) (correlationID string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	defer func() {
+		if err != nil {
+			amqp.events.emit("error", map[string]interface{}{"operation": "publish", "error": err})
+		}
+		failed := err != nil
+		amqp.spendBudget(func(status *ErrorBudgetStatus) {
+			status.Publishes++
+			if failed {
+				status.PublishFailures++
+			}
+		})
+	}()
+
+	if options.ChunkSize > 0 {
+		return amqp.publishChunked(options)
+	}
+	if options, err = options.correlate(); err != nil {
+		return "", err
+	}
+	if err = options.Retry.check(); err != nil {
+		return "", err
+	}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return "", err
 	}
 	defer func() {
 		_ = ch.Close()
 	}()
+	// Copies republished by the retry policy share the track id.
+	options = amqp.stampTracked(options)
+	options = amqp.stampSequence(options, "")
+	defer func() {
+		amqp.forgetTracked(options, err)
+	}()
+	if options.Retry.MaxAttempts > 1 {
+		ch, err = amqp.publishRetrying(ch, options)
+		return options.CorrelationId, err
+	}
+	return options.CorrelationId, amqp.publish(ch, options)
+}
+
+// publish delivers the payload on the given channel.
+func (amqp *AMQP) publish(ch *amqpDriver.Channel, options PublishOptions) error {
+	return amqp.publishConfirming(ch, nil, options)
+}
+
+// publishConfirming delivers the payload on the given channel, waiting for the confirm on the listeners
+// registered by a previous publish on the channel if any, see confirmListeners.
+func (amqp *AMQP) publishConfirming(ch *amqpDriver.Channel, confirms *confirmListeners, options PublishOptions) (err error) {
+	span := amqp.tracer.start(options.Exchange+" publish", spanProducer, "",
+		messagingAttributes("publish", options.Exchange, options.routingKey()))
+	defer func() {
+		span.end(err)
+	}()
+
+	if amqp.publishLimiter != nil {
+		if err = amqp.publishLimiter.Wait(amqp.vu.Context()); err != nil {
+			return err
+		}
+	}
+
+	protoType := options.ProtoMessage != "" && options.Type == ""
+	options = amqp.options.PublishDefaults.apply(options)
+	if protoType {
+		// Consumers decode the body given the type, which must not be prefixed.
+		options.Type = options.ProtoMessage
+	}
+	if options.ProtoMessage != "" && options.ContentType == "" {
+		options.ContentType = protobufContentType
+	}
+	if options.AvroSubject != "" && options.ContentType == "" {
+		options.ContentType = avroContentType
+	}
+	if options.DelayMs > 0 {
+		options.Headers = withField(options.Headers, "x-delay", options.DelayMs)
+	}
+	if options, err = options.stampDeduplication(); err != nil {
+		return err
+	}
+	if amqp.options.ShadowHeader != "" {
+		options.Headers = withField(options.Headers, amqp.options.ShadowHeader, true)
+	}
+	if amqp.options.RunMetadata {
+		options.Headers = amqp.withRunMetadata(options.Headers)
+	}
+	if span != nil {
+		// Consumers of the message continue the trace of the publish.
+		options.Headers = withField(options.Headers, traceparentHeader, span.traceparent())
+	}
+
+	headers, err := coerceTable(options.Headers)
+	if err != nil {
+		return err
+	}
 
 	publishing := amqpDriver.Publishing{
-		Headers:     options.Headers,
+		Headers:     headers,
 		ContentType: options.ContentType,
 	}
 
-	if options.ContentType == messagepack {
+	body := []byte(options.Body)
+	if options.body != nil {
+		body = options.body
+	}
+	var sizeBucket string
+	if options.Payload.enabled() {
+		if body, sizeBucket, err = amqp.generatePayload(options.Payload); err != nil {
+			return err
+		}
+	}
+	tags := options.tags(amqp.options.RoutingKeyTag)
+	if sizeBucket != "" {
+		publishing.Headers = withField(publishing.Headers, headerSizeBucket, sizeBucket)
+		tags["size_bucket"] = sizeBucket
+	}
+
+	switch {
+	case options.ProtoMessage != "":
+		publishing.Body, err = amqp.protos.encode(options.ProtoMessage, body)
+		if err != nil {
+			return err
+		}
+	case options.AvroSubject != "":
+		publishing.Body, err = amqp.encodeAvro(options.AvroSubject, options.AvroVersion, body)
+		if err != nil {
+			return err
+		}
+	case options.ContentType == messagepack:
 		var jsonParsedBody interface{}
 
-		if err = json.Unmarshal([]byte(options.Body), &jsonParsedBody); err != nil {
+		if err = json.Unmarshal(body, &jsonParsedBody); err != nil {
 			return err
 		}
 
@@ -119,8 +651,15 @@ func (amqp *AMQP) Publish(options PublishOptions) error {
 		if err != nil {
 			return err
 		}
-	} else {
-		publishing.Body = []byte(options.Body)
+	default:
+		publishing.Body = body
+	}
+
+	if options.Compression != "" {
+		if publishing.Body, err = compress(publishing.Body, options.Compression); err != nil {
+			return err
+		}
+		publishing.ContentEncoding = options.Compression
 	}
 
 	if options.Persistent {
@@ -134,106 +673,555 @@ func (amqp *AMQP) Publish(options PublishOptions) error {
 
 	publishing.CorrelationId = options.CorrelationId
 	publishing.ReplyTo = options.ReplyTo
-	publishing.Expiration = options.Expiration
+	if publishing.Expiration, err = options.expiration(); err != nil {
+		return err
+	}
 	publishing.MessageId = options.MessageId
 	publishing.Type = options.Type
 	publishing.UserId = options.UserId
 	publishing.AppId = options.AppId
 
-	return ch.PublishWithContext(
-		context.Background(), // TODO: use vu context
-		options.Exchange,
-		options.QueueName,
-		options.Mandatory,
-		options.Immediate,
-		publishing,
-	)
-}
+	if options.Confirm && confirms == nil {
+		if err = amqp.profile.checkConfirms(); err != nil {
+			return err
+		}
+		listeners, err := listenConfirms(ch)
+		if err != nil {
+			return err
+		}
+		confirms = &listeners
+	}
 
-// Listen binds to an AMQP queue in order to receive message(s) as they are received.
-func (amqp *AMQP) Listen(options ListenOptions) error {
-	ch, err := amqp.Connection.Channel()
-	if err != nil {
+	pending := pendingConfirm{
+		tag:           ch.GetNextPublishSeqNo(),
+		messageID:     publishing.MessageId,
+		correlationID: publishing.CorrelationId,
+	}
+	started := time.Now()
+	defer func() {
+		if err == nil {
+			amqp.metrics.push(amqp.metrics.PublishDuration, float64(time.Since(started))/float64(time.Millisecond), tags)
+		}
+	}()
+	err = amqp.metrics.withTimeout(operationPublish, amqp.options.Timeouts.PublishMs, func() error {
+		return ch.PublishWithContext(
+			amqp.vu.Context(),
+			options.Exchange,
+			options.routingKey(),
+			options.Mandatory,
+			options.Immediate,
+			publishing,
+		)
+	})
+	if err != nil || !options.Confirm {
 		return err
 	}
+	confirmTimeoutMs := options.ConfirmTimeoutMs
+	if confirmTimeoutMs <= 0 {
+		confirmTimeoutMs = amqp.options.Timeouts.ConfirmMs
+	}
+	return amqp.awaitConfirm(*confirms, pending, time.Duration(confirmTimeoutMs)*time.Millisecond, tags)
+}
+
+// tags returns the tags of the publish metrics of the message, the destination and the tags of the options.
+func (options PublishOptions) tags(routingKey bool) map[string]string {
+	tags := make(map[string]string, len(options.Tags)+3)
+	if options.QueueName != "" {
+		tags["queue"] = options.QueueName
+	}
+	if options.Exchange != "" {
+		tags["exchange"] = options.Exchange
+	}
+	if key := options.routingKey(); routingKey && key != "" {
+		tags["routing_key"] = key
+	}
+	for k, v := range options.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// expiration returns the validated per-message TTL.
+func (options PublishOptions) expiration() (string, error) {
+	if options.TTLMillis < 0 {
+		return "", errors.New("message TTL must not be negative")
+	}
+	if options.TTLMillis > 0 {
+		if options.Expiration != "" && options.Expiration != strconv.FormatInt(options.TTLMillis, 10) {
+			return "", errors.New("expiration and ttl_millis set to different values")
+		}
+		return strconv.FormatInt(options.TTLMillis, 10), nil
+	}
+	if options.Expiration != "" {
+		if _, err := strconv.ParseUint(options.Expiration, 10, 32); err != nil {
+			return "", fmt.Errorf("invalid expiration %q, expected a number of milliseconds", options.Expiration)
+		}
+	}
+	return options.Expiration, nil
+}
+
+// apply merges the defaults into the publish options, values set on the message take precedence.
+func (defaults PublishDefaults) apply(options PublishOptions) PublishOptions {
+	if options.AppId == "" {
+		options.AppId = defaults.AppId
+	}
+	if options.UserId == "" {
+		options.UserId = defaults.UserId
+	}
+	options.Type = defaults.TypePrefix + options.Type
+
+	if len(defaults.Headers) > 0 {
+		headers := make(amqpDriver.Table, len(defaults.Headers)+len(options.Headers))
+		for k, v := range defaults.Headers {
+			headers[k] = v
+		}
+		for k, v := range options.Headers {
+			headers[k] = v
+		}
+		options.Headers = headers
+	}
+	return options
+}
+
+// routingKey returns the routing key of the message, falling back to the queue name.
+func (options PublishOptions) routingKey() string {
+	if options.RoutingKey != "" {
+		return options.RoutingKey
+	}
+	return options.QueueName
+}
+
+// Listener is a handle to a consumer started with Amqp.listen.
+type Listener struct {
+	Consumer string
+
+	ch   *amqpDriver.Channel
+	done <-chan struct{}
+}
+
+// Listen binds to an AMQP queue in order to receive message(s) as they are received. The iteration lasts
+// until the consumer stops: once MaxMessages were received, it is cancelled with the returned handle, the
+// broker cancels it or the test ends.
+func (amqp *AMQP) Listen(options ListenOptions) (listener *Listener, err error) {
 	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, err
+	}
+	consumer, done, err := amqp.listen(ch, options)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	go func() {
+		<-done
 		_ = ch.Close()
 	}()
+	return &Listener{Consumer: consumer, ch: ch, done: done}, nil
+}
+
+// Cancel stops the consumer, which lets the iteration end. The deliveries not passed to the listener yet
+// are requeued once its channel is closed, unless they were acknowledged automatically.
+func (listener *Listener) Cancel() error {
+	select {
+	case <-listener.done:
+		return nil
+	default:
+	}
+	if err := listener.ch.Cancel(listener.Consumer, false); err != nil && !listener.ch.IsClosed() {
+		return structuredError(err)
+	}
+	return nil
+}
 
-	msgs, err := ch.Consume(
+// listen starts a consumer on the given channel passing the deliveries to the listener on the event loop,
+// and returns its tag along with a channel closed once it stops. The iteration lasts until then.
+func (amqp *AMQP) listen(ch *amqpDriver.Channel, options ListenOptions) (string, <-chan struct{}, error) {
+	acker, err := newAcker(options, amqp.profile)
+	if err != nil {
+		return "", nil, err
+	}
+	schema, err := compileOptionalSchema(options.Schema)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = options.checkBuffer(); err != nil {
+		return "", nil, err
+	}
+	processing, err := options.ProcessingDelay.generator()
+	if err != nil {
+		return "", nil, err
+	}
+	if options.PoisonReject && options.PoisonThreshold <= 0 {
+		return "", nil, errors.New("poison_reject needs a positive poison_threshold")
+	}
+
+	consumer := options.Consumer
+	if consumer == "" {
+		consumer = "k6-" + newUUID()
+	}
+	autoAck := acker.autoAck(options.AutoAck)
+	consumerArgs, err := options.ConsumerArgs.withPriority(options.Priority)
+	if err != nil {
+		return "", nil, err
+	}
+	args, err := amqp.consumerArgs(consumerArgs, options.Args, options.QueueName, autoAck)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = options.ConsumerArgs.prefetch(ch, defaultStreamPrefetch); err != nil {
+		return "", nil, err
+	}
+	blocking := options.OverflowPolicy == "" || options.OverflowPolicy == bufferBlock
+	if options.BufferSize > 0 && blocking && !autoAck && !options.ConsumerArgs.stream() {
+		// A blocked buffer stops reading the deliveries, the broker has to stop sending them as well.
+		if err = ch.Qos(options.BufferSize, 0, false); err != nil {
+			return "", nil, err
+		}
+	}
+	amqp.watchCancellations(ch, options.QueueName, nil)
+	var kicks consumerKicks
+	if options.OnCancel != nil {
+		kicks = notifyKicks(ch, consumer)
+	}
+	msgs, err := amqp.metrics.consume(
+		ch,
+		amqp.options.Timeouts,
 		options.QueueName,
-		options.Consumer,
-		options.AutoAck,
+		consumer,
+		autoAck,
 		options.Exclusive,
 		options.NoLocal,
 		options.NoWait,
-		options.Args,
+		args,
 	)
 	if err != nil {
-		return err
+		go kicks.drain()
+		return "", nil, err
+	}
+
+	var dedup *dedupWindow
+	if options.DedupWindowMs > 0 {
+		dedup = newDedupWindow(time.Duration(options.DedupWindowMs)*time.Millisecond, options.DedupHeader)
+	}
+
+	if !autoAck {
+		acker.inflight = amqp.trackInflight(consumer, options.QueueName)
+	}
+
+	filter := compileFilter(options.Filter)
+	poison := newPoisonDetector(options.PoisonThreshold)
+	tags := consumeTags(options.QueueName, options.Partition)
+	for k, v := range options.Tags {
+		tags[k] = v
+	}
+	watchdog := amqp.watchStalls(options.StallThresholdMs, options.QueueName, consumer)
+	pacer := amqp.paceConsumer(options.MaxRatePerSecond, tags)
+	done := make(chan struct{})
+	deliveries := msgs
+	if options.BufferSize > 0 {
+		deliveries = amqp.bufferDeliveries(msgs, done, options, autoAck, tags)
+	}
+	callbacks := newLoopCallbacks(amqp.vu)
+	received := 0
+	process := func(d amqpDriver.Delivery) {
+		watchdog.received()
+		acker.inflight.add(d.DeliveryTag)
+		amqp.countConsumed(d, tags)
+		if d.Redelivered {
+			amqp.metrics.push(amqp.metrics.MessagesRedelivered, 1, tags)
+		}
+		if dedup != nil && dedup.duplicate(d) {
+			amqp.metrics.push(amqp.metrics.DuplicatesSuppressed, 1, tags)
+			if !autoAck {
+				// An idempotent consumer acknowledges duplicates without processing them.
+				_ = d.Ack(false)
+				acker.inflight.settle(d.DeliveryTag, false)
+			}
+			return
+		}
+		if !filter.matches(d) {
+			amqp.metrics.push(amqp.metrics.MessagesFiltered, 1, tags)
+			if !autoAck {
+				_ = d.Ack(false)
+				acker.inflight.settle(d.DeliveryTag, false)
+			}
+			return
+		}
+		if amqp.checkPoison(poison, d, tags) && options.PoisonReject && !autoAck {
+			_ = amqp.profile.reject(d, false)
+			acker.inflight.settle(d.DeliveryTag, false)
+			poison.settled(d)
+			return
+		}
+		// Held back deliveries stay unacknowledged, the prefetch bounds how many pile up. Those held back
+		// until the iteration ends are left to the broker to requeue.
+		if !pacer.wait(amqp.vu.Context()) {
+			return
+		}
+		started := time.Now()
+		span := amqp.tracer.start(options.QueueName+" receive", spanConsumer, traceparentOf(d.Headers),
+			messagingAttributes("receive", d.Exchange, d.RoutingKey))
+		body, err := amqp.decodeBody(d, options.ProtoMessage, options.Avro)
+		complete := true
+		if err == nil && options.Reassemble {
+			body, complete, err = amqp.chunks.add(d, body)
+		}
+		if err == nil && complete {
+			amqp.checkSchema(schema, body, tags)
+			err = callbacks.call(func() error {
+				return options.Listener(string(body))
+			})
+			received++
+		}
+		if processing != nil {
+			_ = sleepContext(amqp.vu.Context(), processing())
+		}
+		if nacked, _ := acker.settle(d, err); nacked {
+			amqp.metrics.push(amqp.metrics.MessagesNacked, 1, tags)
+		} else {
+			poison.settled(d)
+		}
+		amqp.metrics.push(amqp.metrics.ConsumeDuration, sinceMs(started), tags)
+		span.end(err)
+	}
+	onCancel := func(reason string, kicked bool) {
+		if kicked {
+			_ = callbacks.call(func() error {
+				return options.OnCancel(reason)
+			})
+		}
 	}
 
 	go func() {
-		for d := range msgs {
-			err = options.Listener(string(d.Body))
+		defer close(done)
+		defer amqp.untrackInflight(acker.inflight)
+		defer watchdog.stop()
+		defer pacer.stop()
+		defer callbacks.release()
+		defer func() {
+			go kicks.drain()
+		}()
+		defer func() {
+			_ = acker.flush()
+		}()
+		for {
+			select {
+			case d, ok := <-deliveries:
+				if !ok {
+					// The broker notifies a kick before closing the deliveries.
+					onCancel(kicks.pending())
+					return
+				}
+				process(d)
+				if options.MaxMessages > 0 && received >= options.MaxMessages {
+					_ = ch.Cancel(consumer, false)
+					return
+				}
+			case tag, ok := <-kicks.cancels:
+				onCancel(kicks.cancelled(tag, ok))
+			case closeErr, ok := <-kicks.closes:
+				onCancel(kicks.closed(closeErr, ok))
+			case <-amqp.vu.Context().Done():
+				return
+			}
 		}
 	}()
-	return err
+	return consumer, done, nil
 }
 
 // Get first message from an AMQP queue or wait some time for one if the queue is empty. Empty string is returned if no message.
 func (amqp *AMQP) Get(options GetOptions) (string, error) {
-	msg := ""
+	msg, err := amqp.GetMessage(options)
+	if msg == nil {
+		return "", err
+	}
+	return msg.Body, err
+}
+
+// GetMessage gets first message from an AMQP queue along with its delivery metadata, see Get. Nil is returned if no message.
+func (amqp *AMQP) GetMessage(options GetOptions) (msg *Message, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
 
-	ch, err := amqp.Connection.Channel()
+	args, err := amqp.consumerArgs(options.ConsumerArgs, options.Args, options.QueueName, false)
 	if err != nil {
-		return msg, err
+		return nil, err
+	}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, err
 	}
 	defer func() {
 		_ = ch.Close()
 	}()
+	if err = options.ConsumerArgs.prefetch(ch, 1); err != nil {
+		return nil, err
+	}
 
-	msgs, err := ch.Consume(
+	msgs, err := amqp.metrics.consume(
+		ch,
+		amqp.options.Timeouts,
 		options.QueueName,
 		options.Consumer,
 		false,
 		options.Exclusive,
 		options.NoLocal,
 		options.NoWait,
-		options.Args,
+		args,
 	)
 	if err != nil {
-		return msg, err
+		return nil, err
 	}
-	
+
 	timeout := options.WaitingTimeoutSec
 
 	if timeout <= 0 {
 		timeout = 1
 	}
 
-	select {
-	case m := <-msgs:
-		// message received
-		msg = string(m.Body)
-		err := m.Ack(false)
-		return msg, err
-	case <-time.After(time.Duration(timeout) * time.Second):
-		// timeout
-		return msg, err
+	expired := time.After(time.Duration(timeout) * time.Second)
+	for {
+		select {
+		case m := <-msgs:
+			// message received
+			amqp.countConsumed(m, consumeTags(options.QueueName, options.Partition))
+			span := amqp.tracer.start(options.QueueName+" receive", spanConsumer, traceparentOf(m.Headers),
+				messagingAttributes("receive", m.Exchange, m.RoutingKey))
+			msg, err := amqp.newMessage(m, options.ProtoMessage, options.Avro)
+			complete := true
+			if err == nil && options.Reassemble {
+				var body []byte
+				if body, complete, err = amqp.chunks.add(m, []byte(msg.Body)); complete {
+					msg.Body = string(body)
+				}
+			}
+			if err != nil {
+				// A body that cannot be decoded would fail again on redelivery.
+				_ = m.Reject(false)
+				span.end(err)
+				return nil, err
+			}
+			err = m.Ack(false)
+			span.end(err)
+			if err != nil || complete {
+				return msg, err
+			}
+		case <-expired:
+			// timeout
+			return nil, err
+		case <-amqp.vu.Context().Done():
+			return nil, amqp.vu.Context().Err()
+		}
 	}
 }
 
-func init() {
-	queue := Queue{}
-	exchange := Exchange{}
-	generalAMQP := AMQP{
-		Version:  version,
-		Queue:    &queue,
-		Exchange: &exchange,
+// instance returns the AMQP object bound to the given VU, creating it on first use.
+func (root *RootModule) instance(vu modules.VU) *AMQP {
+	if amqp, ok := root.instances.Load(vu); ok {
+		return amqp.(*AMQP)
 	}
 
-	modules.Register("k6/x/amqp", &generalAMQP)
-	modules.Register("k6/x/amqp/queue", &queue)
-	modules.Register("k6/x/amqp/exchange", &exchange)
+	m, err := registerMetrics(vu)
+	if err != nil {
+		common.Throw(vu.Runtime(), err)
+	}
+
+	connection := &sessionConnection{}
+	amqp, _ := root.instances.LoadOrStore(vu, &AMQP{
+		Version:    version,
+		Queue:      &Queue{Version: version, vu: vu, connection: connection, metrics: m},
+		Exchange:   &Exchange{Version: version, Kinds: exchangeKinds, vu: vu, connection: connection, metrics: m},
+		Management: &Management{Version: version, vu: vu},
+		Stomp:      &WebStomp{Version: version, vu: vu, metrics: m},
+		Mqtt:       &Mqtt{Version: version, vu: vu, metrics: m},
+		vu:         vu,
+		connection: connection,
+		metrics:    m,
+		budget:     &root.budget,
+		eventFiles: &root.events,
+		pools:      &root.pools,
+		retries:    &root.retries,
+		tracked:    &root.tracked,
+		loopback:   &root.loopback,
+		protos:     &root.protos,
+		registry:   &root.registry,
+		watches:    &root.watches,
+		ordering:   &root.ordering,
+		nodes:      &root.nodes,
+		fixtures:   &root.fixtures,
+		scriptDir:  scriptDir(vu),
+		chunks:     &root.chunks,
+		duplicates: &root.dedups,
+		random:     newPayloadRand(),
+	})
+	return amqp.(*AMQP)
+}
+
+// NewModuleInstance implements the modules.Module interface for "k6/x/amqp".
+func (root *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	return &ModuleInstance{exports: root.instance(vu)}
+}
+
+// NewModuleInstance implements the modules.Module interface for a submodule.
+func (sub *submodule) NewModuleInstance(vu modules.VU) modules.Instance {
+	return &ModuleInstance{exports: sub.exports(sub.root.instance(vu))}
+}
+
+// Exports implements the modules.Instance interface.
+func (mi *ModuleInstance) Exports() modules.Exports {
+	return modules.Exports{Default: mi.exports}
+}
+
+// newMessage converts a delivery into a Message, decompressing its body if needed.
+func (amqp *AMQP) newMessage(d amqpDriver.Delivery, protoMessage string, avro bool) (*Message, error) {
+	body, err := amqp.decodeBody(d, protoMessage, avro)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Body:         string(body),
+		Redelivered:  d.Redelivered,
+		DeliveryTag:  d.DeliveryTag,
+		Exchange:     d.Exchange,
+		RoutingKey:   d.RoutingKey,
+		Headers:      d.Headers,
+		StreamOffset: streamOffsetOf(d.Headers),
+
+		CorrelationId: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		MessageId:     d.MessageId,
+		ContentType:   d.ContentType,
+	}, nil
+}
+
+func init() {
+	root := &RootModule{}
+
+	modules.Register("k6/x/amqp", root)
+	modules.Register("k6/x/amqp/queue", &submodule{
+		root:    root,
+		exports: func(amqp *AMQP) interface{} { return amqp.Queue },
+	})
+	modules.Register("k6/x/amqp/exchange", &submodule{
+		root:    root,
+		exports: func(amqp *AMQP) interface{} { return amqp.Exchange },
+	})
+	modules.Register("k6/x/amqp/management", &submodule{
+		root:    root,
+		exports: func(amqp *AMQP) interface{} { return amqp.Management },
+	})
+	modules.Register("k6/x/amqp/stomp", &submodule{
+		root:    root,
+		exports: func(amqp *AMQP) interface{} { return amqp.Stomp },
+	})
+	modules.Register("k6/x/amqp/mqtt", &submodule{
+		root:    root,
+		exports: func(amqp *AMQP) interface{} { return amqp.Mqtt },
+	})
 }
@@ -0,0 +1,19 @@
+package amqp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPublishTags(t *testing.T) {
+	t.Parallel()
+
+	options := PublishOptions{Exchange: "orders", RoutingKey: "order-1234", Tags: map[string]string{"team": "checkout"}}
+	if tags := options.tags(false); !reflect.DeepEqual(tags, map[string]string{"exchange": "orders", "team": "checkout"}) {
+		t.Errorf("tagged %v without routing_key_tag", tags)
+	}
+	expected := map[string]string{"exchange": "orders", "routing_key": "order-1234", "team": "checkout"}
+	if tags := options.tags(true); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("tagged %v with routing_key_tag, expected %v", tags, expected)
+	}
+}
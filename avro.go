@@ -0,0 +1,451 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// avroSchema is a parsed Avro schema, supporting the primitive types, records, enums, arrays, maps, unions
+// and fixed. Logical types are encoded as their underlying type.
+type avroSchema struct {
+	typ      string        // null, boolean, int, long, float, double, bytes, string or a complex type
+	name     string        // full name of records, enums and fixed
+	fields   []avroField   // record fields
+	symbols  []string      // enum symbols
+	items    *avroSchema   // array items
+	values   *avroSchema   // map values
+	branches []*avroSchema // union branches
+	size     int           // fixed size
+}
+
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+const avroUnion = "union"
+
+var avroPrimitives = map[string]bool{ //nolint:gochecknoglobals
+	"null": true, "boolean": true, "int": true, "long": true, "float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvroSchema parses the JSON text of an Avro schema.
+func parseAvroSchema(text string) (*avroSchema, error) {
+	var raw interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	return (&avroParser{named: make(map[string]*avroSchema)}).parse(raw, "")
+}
+
+// avroParser resolves the references to the named types defined so far.
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch definition := raw.(type) {
+	case string:
+		if avroPrimitives[definition] {
+			return &avroSchema{typ: definition}, nil
+		}
+		if s, ok := p.named[avroFullName(definition, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[definition]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", definition)
+	case []interface{}:
+		union := &avroSchema{typ: avroUnion}
+		for _, branch := range definition {
+			s, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, s)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(definition, namespace)
+	default:
+		return nil, fmt.Errorf("invalid avro type %v", raw)
+	}
+}
+
+func (p *avroParser) parseComplex(definition map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, _ := definition["type"].(string)
+	if ns, ok := definition["namespace"].(string); ok {
+		namespace = ns
+	}
+
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := definition["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("avro %s without a name", typ)
+		}
+		s := &avroSchema{typ: typ, name: avroFullName(name, namespace)}
+		if typ == "error" {
+			s.typ = "record"
+		}
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		}
+		// Registered first, records may refer to themselves.
+		p.named[s.name] = s
+		return s, p.parseNamed(s, definition, namespace)
+	case "array":
+		items, err := p.parse(definition["items"], namespace)
+		return &avroSchema{typ: typ, items: items}, err
+	case "map":
+		values, err := p.parse(definition["values"], namespace)
+		return &avroSchema{typ: typ, values: values}, err
+	default:
+		// A primitive type possibly annotated with a logical type, or a nested definition.
+		return p.parse(definition["type"], namespace)
+	}
+}
+
+func (p *avroParser) parseNamed(s *avroSchema, definition map[string]interface{}, namespace string) error {
+	switch s.typ {
+	case "record":
+		fields, _ := definition["fields"].([]interface{})
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if name == "" {
+				return fmt.Errorf("avro record %s has a field without a name", s.name)
+			}
+			schema, err := p.parse(field["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("avro field %s.%s: %w", s.name, name, err)
+			}
+			def, hasDefault := field["default"]
+			s.fields = append(s.fields, avroField{name: name, schema: schema, def: def, hasDefault: hasDefault})
+		}
+	case "enum":
+		symbols, _ := definition["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			name, _ := symbol.(string)
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, _ := definition["size"].(json.Number)
+		n, err := size.Int64()
+		if err != nil || n < 0 {
+			return fmt.Errorf("avro fixed %s has an invalid size", s.name)
+		}
+		s.size = int(n)
+	}
+	return nil
+}
+
+func avroFullName(name string, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// encode writes a value decoded from JSON with json.Number numbers in the Avro binary encoding.
+// Union values are given either plainly, the first matching branch being used, or wrapped as {"type": value}.
+func (s *avroSchema) encode(buf *bytes.Buffer, value interface{}) error {
+	switch s.typ {
+	case "null":
+		if value != nil {
+			return fmt.Errorf("expected null, got %v", value)
+		}
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, got %v", value)
+		}
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case "int", "long":
+		n, err := avroInteger(value)
+		if err != nil {
+			return err
+		}
+		if s.typ == "int" && (n < math.MinInt32 || n > math.MaxInt32) {
+			return fmt.Errorf("%d overflows an avro int", n)
+		}
+		avroWriteLong(buf, n)
+	case "float", "double":
+		f, err := avroNumber(value)
+		if err != nil {
+			return err
+		}
+		if s.typ == "float" {
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+			buf.Write(b[:])
+		} else {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+			buf.Write(b[:])
+		}
+	case "bytes", "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+		avroWriteLong(buf, int64(len(str)))
+		buf.WriteString(str)
+	case "fixed":
+		str, ok := value.(string)
+		if !ok || len(str) != s.size {
+			return fmt.Errorf("expected a string of %d bytes for %s, got %v", s.size, s.name, value)
+		}
+		buf.WriteString(str)
+	case "enum":
+		str, _ := value.(string)
+		for i, symbol := range s.symbols {
+			if symbol == str {
+				avroWriteLong(buf, int64(i))
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not a symbol of %s", value, s.name)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array, got %v", value)
+		}
+		if len(items) > 0 {
+			avroWriteLong(buf, int64(len(items)))
+			for i, item := range items {
+				if err := s.items.encode(buf, item); err != nil {
+					return fmt.Errorf("[%d]: %w", i, err)
+				}
+			}
+		}
+		avroWriteLong(buf, 0)
+	case "map":
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object, got %v", value)
+		}
+		if len(entries) > 0 {
+			avroWriteLong(buf, int64(len(entries)))
+			for key, entry := range entries {
+				avroWriteLong(buf, int64(len(key)))
+				buf.WriteString(key)
+				if err := s.values.encode(buf, entry); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+		avroWriteLong(buf, 0)
+	case "record":
+		return s.encodeRecord(buf, value)
+	case avroUnion:
+		return s.encodeUnion(buf, value)
+	}
+	return nil
+}
+
+func (s *avroSchema) encodeRecord(buf *bytes.Buffer, value interface{}) error {
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected an object for %s, got %v", s.name, value)
+	}
+	for _, field := range s.fields {
+		v, ok := record[field.name]
+		if !ok {
+			if !field.hasDefault {
+				return fmt.Errorf("%s: missing field %s", s.name, field.name)
+			}
+			v = field.def
+		}
+		if err := field.schema.encode(buf, v); err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *avroSchema) encodeUnion(buf *bytes.Buffer, value interface{}) error {
+	if wrapped, ok := value.(map[string]interface{}); ok && len(wrapped) == 1 {
+		for branchName, v := range wrapped {
+			for i, branch := range s.branches {
+				if branch.typeName() == branchName {
+					avroWriteLong(buf, int64(i))
+					return branch.encode(buf, v)
+				}
+			}
+		}
+	}
+	for i, branch := range s.branches {
+		var encoded bytes.Buffer
+		if branch.encode(&encoded, value) == nil {
+			avroWriteLong(buf, int64(i))
+			buf.Write(encoded.Bytes())
+			return nil
+		}
+	}
+	return fmt.Errorf("%v matches no branch of the union", value)
+}
+
+// typeName returns the name of the type used to wrap union values.
+func (s *avroSchema) typeName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.typ
+}
+
+func avroInteger(value interface{}) (int64, error) {
+	switch n := value.(type) {
+	case json.Number:
+		return n.Int64()
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("expected an integer, got %v", n)
+		}
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %v", value)
+	}
+}
+
+func avroNumber(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case json.Number:
+		return n.Float64()
+	case float64:
+		return n, nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %v", value)
+	}
+}
+
+// avroWriteLong writes a zig-zag encoded variable-length integer.
+func avroWriteLong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+var errAvroShort = errors.New("avro body too short")
+
+// avroReader decodes the Avro binary encoding. The first error is kept and stops decoding.
+type avroReader struct {
+	buf []byte
+	err error
+}
+
+func (r *avroReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = errAvroShort
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *avroReader) long() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(r.buf)
+	if size <= 0 {
+		r.err = errAvroShort
+		return 0
+	}
+	r.buf = r.buf[size:]
+	return n
+}
+
+func (r *avroReader) bytes() []byte {
+	return r.next(int(r.long()))
+}
+
+// decode reads a value, returning JSON friendly values: union values are not wrapped and bytes are strings.
+func (s *avroSchema) decode(r *avroReader) interface{} {
+	switch s.typ {
+	case "boolean":
+		b := r.next(1)
+		return b != nil && b[0] != 0
+	case "int", "long":
+		return r.long()
+	case "float":
+		if b := r.next(4); b != nil {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}
+	case "double":
+		if b := r.next(8); b != nil {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	case "bytes", "string":
+		return string(r.bytes())
+	case "fixed":
+		return string(r.next(s.size))
+	case "enum":
+		if i := r.long(); i >= 0 && i < int64(len(s.symbols)) {
+			return s.symbols[i]
+		}
+		r.fail(fmt.Errorf("invalid symbol index for %s", s.name))
+	case "array":
+		items := []interface{}{}
+		for count := r.blockCount(); count > 0 && r.err == nil; count = r.blockCount() {
+			for ; count > 0 && r.err == nil; count-- {
+				items = append(items, s.items.decode(r))
+			}
+		}
+		return items
+	case "map":
+		entries := map[string]interface{}{}
+		for count := r.blockCount(); count > 0 && r.err == nil; count = r.blockCount() {
+			for ; count > 0 && r.err == nil; count-- {
+				key := string(r.bytes())
+				entries[key] = s.values.decode(r)
+			}
+		}
+		return entries
+	case "record":
+		record := make(map[string]interface{}, len(s.fields))
+		for _, field := range s.fields {
+			record[field.name] = field.schema.decode(r)
+		}
+		return record
+	case avroUnion:
+		if i := r.long(); i >= 0 && i < int64(len(s.branches)) {
+			return s.branches[i].decode(r)
+		}
+		r.fail(errors.New("invalid union branch index"))
+	}
+	return nil
+}
+
+// blockCount reads the item count of the next array or map block, skipping the byte size of negative counts.
+func (r *avroReader) blockCount() int64 {
+	count := r.long()
+	if count < 0 {
+		count = -count
+		r.long()
+	}
+	return count
+}
+
+func (r *avroReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
@@ -0,0 +1,189 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modulestest"
+)
+
+const orderSchema = `{
+	"type": "record", "name": "Order", "namespace": "acme",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "paid", "type": "boolean"},
+		{"name": "total", "type": "double"},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "SHIPPED"]}},
+		{"name": "items", "type": {"type": "array", "items": "string"}},
+		{"name": "attributes", "type": {"type": "map", "values": "int"}},
+		{"name": "note", "type": ["null", "string"], "default": null},
+		{"name": "ref", "type": {"type": "fixed", "name": "Ref", "size": 4}}
+	]
+}`
+
+// decodeJSON decodes a JSON value with json.Number numbers, as the bodies given to encode are.
+func decodeJSON(t *testing.T, text string) interface{} {
+	t.Helper()
+
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestAvroWriteLong(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n        int64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{-1, []byte{0x01}},
+		{1, []byte{0x02}},
+		{-64, []byte{0x7f}},
+		{64, []byte{0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		avroWriteLong(&buf, tt.n)
+		if !bytes.Equal(buf.Bytes(), tt.expected) {
+			t.Errorf("avroWriteLong(%d) = %x, expected %x", tt.n, buf.Bytes(), tt.expected)
+		}
+		r := &avroReader{buf: buf.Bytes()}
+		if n := r.long(); n != tt.n || r.err != nil {
+			t.Errorf("long() of %x = %d, %v", tt.expected, n, r.err)
+		}
+	}
+}
+
+func TestAvroRecordRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	schema, err := parseAvroSchema(orderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			"plain union",
+			`{"id": 42, "paid": true, "total": 9.5, "status": "SHIPPED", "items": ["a", "b"], "attributes": {"x": 1}, "note": "fragile", "ref": "abcd"}`,
+			`{"attributes":{"x":1},"id":42,"items":["a","b"],"note":"fragile","paid":true,"ref":"abcd","status":"SHIPPED","total":9.5}`,
+		},
+		{
+			"wrapped union",
+			`{"id": -3, "paid": false, "total": 0, "status": "NEW", "items": [], "attributes": {}, "note": {"string": "x"}, "ref": "wxyz"}`,
+			`{"attributes":{},"id":-3,"items":[],"note":"x","paid":false,"ref":"wxyz","status":"NEW","total":0}`,
+		},
+		{
+			"default",
+			`{"id": 1, "paid": false, "total": 1, "status": "NEW", "items": [], "attributes": {}, "ref": "0000"}`,
+			`{"attributes":{},"id":1,"items":[],"note":null,"paid":false,"ref":"0000","status":"NEW","total":1}`,
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := schema.encode(&buf, decodeJSON(t, tt.value)); err != nil {
+			t.Errorf("%s: encode: %v", tt.name, err)
+			continue
+		}
+		r := &avroReader{buf: buf.Bytes()}
+		decoded := schema.decode(r)
+		if r.err != nil {
+			t.Errorf("%s: decode: %v", tt.name, r.err)
+			continue
+		}
+		if len(r.buf) != 0 {
+			t.Errorf("%s: %d bytes left after decoding", tt.name, len(r.buf))
+		}
+		text, _ := json.Marshal(decoded)
+		if !reflect.DeepEqual(decodeJSON(t, string(text)), decodeJSON(t, tt.expected)) {
+			t.Errorf("%s: decoded %s, expected %s", tt.name, text, tt.expected)
+		}
+	}
+}
+
+func TestAvroEncodeErrors(t *testing.T) {
+	t.Parallel()
+
+	schema, err := parseAvroSchema(orderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{
+		`{"id": 1}`,
+		`{"id": "1", "paid": false, "total": 1, "status": "NEW", "items": [], "attributes": {}, "ref": "0000"}`,
+		`{"id": 1, "paid": false, "total": 1, "status": "LOST", "items": [], "attributes": {}, "ref": "0000"}`,
+		`{"id": 1, "paid": false, "total": 1, "status": "NEW", "items": [], "attributes": {}, "ref": "0"}`,
+		`{"id": 1, "paid": false, "total": 1, "status": "NEW", "items": [], "attributes": {"x": 4294967296}, "ref": "0000"}`,
+	} {
+		if err := schema.encode(&bytes.Buffer{}, decodeJSON(t, value)); err == nil {
+			t.Errorf("encode(%s) succeeded", value)
+		}
+	}
+}
+
+func TestAvroDecodeTruncated(t *testing.T) {
+	t.Parallel()
+
+	schema, err := parseAvroSchema(orderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	value := `{"id": 42, "paid": true, "total": 9.5, "status": "NEW", "items": ["a"], "attributes": {}, "ref": "abcd"}`
+	if err = schema.encode(&buf, decodeJSON(t, value)); err != nil {
+		t.Fatal(err)
+	}
+	r := &avroReader{buf: buf.Bytes()[:buf.Len()-2]}
+	schema.decode(r)
+	if r.err == nil {
+		t.Error("expected an error decoding a truncated body")
+	}
+}
+
+func TestDecodeBodyAvro(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	amqp := (&RootModule{}).instance(rt.VU)
+
+	schema, err := parseAvroSchema(`"string"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	framed := []byte{avroMagicByte, 0, 0, 0, 7}
+	var buf bytes.Buffer
+	if err = schema.encode(&buf, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	framed = append(framed, buf.Bytes()...)
+	d := amqpDriver.Delivery{ContentType: avroContentType, Body: framed}
+
+	// Without a schema registry, Avro bodies are consumed raw unless asked for.
+	body, err := amqp.decodeBody(d, "", false)
+	if err != nil || !bytes.Equal(body, framed) {
+		t.Errorf("decodeBody() without registry = %x, %v, expected the raw body", body, err)
+	}
+	if _, err = amqp.decodeBody(d, "", true); err == nil {
+		t.Error("expected an error decoding with avro: true without registry")
+	}
+
+	amqp.options.SchemaRegistry.Url = "http://registry.invalid"
+	amqp.registry.ids = map[string]*avroSchema{"http://registry.invalid|7": schema}
+	body, err = amqp.decodeBody(d, "", false)
+	if err != nil || string(body) != `"hello"` {
+		t.Errorf("decodeBody() with registry = %s, %v, expected \"hello\"", body, err)
+	}
+}
@@ -0,0 +1,100 @@
+package amqp
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.k6.io/k6/errext"
+)
+
+// ErrorBudgetOptions defines how many AMQP errors the whole test run tolerates.
+type ErrorBudgetOptions struct {
+	MaxPublishFailureRate    float64 // fraction (0-1) of failed publishes
+	MinPublishes             int     // publishes needed before the failure rate is enforced, 100 by default
+	MaxReconnects            int     // connections lost and recovered, see Options.Reconnect
+	MaxConsumerCancellations int     // consumers kicked by the broker
+	Abort                    bool    // abort the test once the budget is blown instead of only reporting it
+}
+
+// ErrorBudgetStatus reports the errors counted against the budget by all the VUs.
+type ErrorBudgetStatus struct {
+	Publishes             int64
+	PublishFailures       int64
+	Reconnects            int64
+	ConsumerCancellations int64
+	Blown                 bool
+	Reason                string // the limit exceeded first
+}
+
+const defaultMinPublishes = 100
+
+// errorBudget is shared by the VUs of the test run.
+type errorBudget struct {
+	configured int32 // set once a budget is configured, read without the lock on every operation
+	mu         sync.Mutex
+	options    ErrorBudgetOptions
+	status     ErrorBudgetStatus
+}
+
+func (b *errorBudget) configure(options ErrorBudgetOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if options.MinPublishes <= 0 {
+		options.MinPublishes = defaultMinPublishes
+	}
+	b.options = options
+	atomic.StoreInt32(&b.configured, 1)
+}
+
+// spend counts an error, or an operation, and returns the reason if it blew the budget. Nothing is counted
+// until a budget is configured, sparing the VUs the lock.
+func (b *errorBudget) spend(count func(status *ErrorBudgetStatus)) string {
+	if atomic.LoadInt32(&b.configured) == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count(&b.status)
+	if b.status.Blown {
+		return ""
+	}
+
+	options, status := b.options, b.status
+	switch {
+	case options.MaxPublishFailureRate > 0 && status.Publishes >= int64(options.MinPublishes) &&
+		float64(status.PublishFailures)/float64(status.Publishes) > options.MaxPublishFailureRate:
+		b.status.Reason = fmt.Sprintf("publish failure rate above %g", options.MaxPublishFailureRate)
+	case options.MaxReconnects > 0 && status.Reconnects > int64(options.MaxReconnects):
+		b.status.Reason = fmt.Sprintf("more than %d reconnects", options.MaxReconnects)
+	case options.MaxConsumerCancellations > 0 && status.ConsumerCancellations > int64(options.MaxConsumerCancellations):
+		b.status.Reason = fmt.Sprintf("more than %d consumer cancellations", options.MaxConsumerCancellations)
+	default:
+		return ""
+	}
+	b.status.Blown = true
+	return b.status.Reason
+}
+
+// ErrorBudget returns the errors counted against the error budget of the test run, none if no VU
+// configured one.
+func (amqp *AMQP) ErrorBudget() ErrorBudgetStatus {
+	amqp.budget.mu.Lock()
+	defer amqp.budget.mu.Unlock()
+	return amqp.budget.status
+}
+
+// spendBudget counts against the error budget, aborting the test run if it is blown and configured to.
+func (amqp *AMQP) spendBudget(count func(status *ErrorBudgetStatus)) {
+	reason := amqp.budget.spend(count)
+	if reason == "" {
+		return
+	}
+	amqp.budget.mu.Lock()
+	abort := amqp.budget.options.Abort
+	amqp.budget.mu.Unlock()
+	if abort {
+		amqp.vu.Runtime().Interrupt(&errext.InterruptError{Reason: errext.AbortTest + ": AMQP error budget blown, " + reason})
+	}
+}
@@ -0,0 +1,27 @@
+package amqp
+
+import "testing"
+
+func TestErrorBudgetSpend(t *testing.T) {
+	t.Parallel()
+
+	var budget errorBudget
+	failure := func(status *ErrorBudgetStatus) {
+		status.Publishes++
+		status.PublishFailures++
+	}
+	if reason := budget.spend(failure); reason != "" || budget.status.Publishes != 0 {
+		t.Fatalf("counted %+v and blew %q without a budget", budget.status, reason)
+	}
+
+	budget.configure(ErrorBudgetOptions{MaxPublishFailureRate: 0.5, MinPublishes: 2})
+	if reason := budget.spend(failure); reason != "" {
+		t.Fatalf("blew %q before the minimum publishes", reason)
+	}
+	if reason := budget.spend(failure); reason != "publish failure rate above 0.5" {
+		t.Errorf("blew %q", reason)
+	}
+	if reason := budget.spend(failure); reason != "" || budget.status.Publishes != 3 {
+		t.Errorf("counted %+v and blew %q again", budget.status, reason)
+	}
+}
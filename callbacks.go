@@ -0,0 +1,146 @@
+package amqp
+
+import (
+	"errors"
+	"sync"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modules"
+)
+
+var errCallbacksReleased = errors.New("the consumer is stopped, its callbacks can no longer run")
+
+// loopCallbacks runs the JS callbacks of a consumer on the event loop of the VU, the only place where JS
+// code can safely run, instead of on the goroutine receiving the deliveries. The event loop waiting for
+// the registered callbacks, the iteration lasts until the consumer stops and releases them.
+type loopCallbacks struct {
+	vu      modules.VU
+	calls   sync.Mutex // a registered callback runs a single function, the calls take turns
+	mu      sync.Mutex
+	enqueue func(func() error)
+	stopped bool
+}
+
+// newLoopCallbacks registers the callbacks of a consumer, it must be called from the event loop.
+func newLoopCallbacks(vu modules.VU) *loopCallbacks {
+	return &loopCallbacks{vu: vu, enqueue: vu.RegisterCallback()}
+}
+
+// call runs the function on the event loop and returns its error, or the error of the context if the VU is
+// done first. Errors are returned to the caller instead of interrupting the iteration.
+func (c *loopCallbacks) call(f func() error) error {
+	c.calls.Lock()
+	defer c.calls.Unlock()
+
+	c.mu.Lock()
+	enqueue := c.enqueue
+	c.enqueue = nil
+	c.mu.Unlock()
+	if enqueue == nil {
+		return errCallbacksReleased
+	}
+
+	result := make(chan error, 1)
+	enqueue(func() error {
+		err := f()
+		c.mu.Lock()
+		if !c.stopped {
+			// Registered from the event loop, ready for the next call.
+			c.enqueue = c.vu.RegisterCallback()
+		}
+		c.mu.Unlock()
+		result <- err
+		return nil
+	})
+	select {
+	case err := <-result:
+		return err
+	case <-c.vu.Context().Done():
+		c.release()
+		return c.vu.Context().Err()
+	}
+}
+
+// release lets the iteration end, the callbacks no longer running.
+func (c *loopCallbacks) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enqueue != nil {
+		c.enqueue(func() error { return nil })
+		c.enqueue = nil
+	}
+	c.stopped = true
+}
+
+// consumerKicks receives the notifications of the broker kicking a consumer, for its OnCancel callback.
+// The broker notifies before closing the deliveries, so a kick is never missed once they are closed.
+type consumerKicks struct {
+	consumer string
+	cancels  <-chan string
+	closes   <-chan *amqpDriver.Error
+}
+
+// notifyKicks registers the notifications of a consumer about to start on the channel.
+func notifyKicks(ch *amqpDriver.Channel, consumer string) consumerKicks {
+	return consumerKicks{
+		consumer: consumer,
+		cancels:  ch.NotifyCancel(make(chan string, 1)),
+		closes:   ch.NotifyClose(make(chan *amqpDriver.Error, 1)),
+	}
+}
+
+// cancelled returns the reason of a cancel notification concerning the consumer, if it does.
+func (k *consumerKicks) cancelled(consumer string, ok bool) (string, bool) {
+	if !ok {
+		k.cancels = nil
+		return "", false
+	}
+	return cancelByServer, consumer == k.consumer
+}
+
+// closed returns the reason of a channel closure by the broker, if it is one.
+func (k *consumerKicks) closed(closeErr *amqpDriver.Error, ok bool) (string, bool) {
+	if !ok {
+		k.closes = nil
+		return "", false
+	}
+	if closeErr == nil || !closeErr.Server {
+		return "", false
+	}
+	return closeReason(closeErr), true
+}
+
+// pending returns the reason of a kick already notified, if any.
+func (k *consumerKicks) pending() (string, bool) {
+	for {
+		var reason string
+		var kicked bool
+		select {
+		case consumer, ok := <-k.cancels:
+			reason, kicked = k.cancelled(consumer, ok)
+		case closeErr, ok := <-k.closes:
+			reason, kicked = k.closed(closeErr, ok)
+		default:
+			return "", false
+		}
+		if kicked {
+			return reason, true
+		}
+	}
+}
+
+// drain keeps reading the notifications until the channel is closed, the driver blocking on them otherwise.
+func (k consumerKicks) drain() {
+	for k.cancels != nil || k.closes != nil {
+		select {
+		case _, ok := <-k.cancels:
+			if !ok {
+				k.cancels = nil
+			}
+		case _, ok := <-k.closes:
+			if !ok {
+				k.closes = nil
+			}
+		}
+	}
+}
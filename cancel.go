@@ -0,0 +1,60 @@
+package amqp
+
+import (
+	"strings"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Reasons a consumer is kicked by the broker.
+const (
+	cancelByServer       = "cancel"        // basic.cancel, e.g. the queue was deleted or its leader moved
+	cancelByTimeout      = "timeout"       // deliveries not acknowledged within the consumer timeout
+	cancelByChannelClose = "channel_close" // any other channel closure by the broker
+)
+
+// watchCancellations reports the consumers of a channel cancelled by the broker, or lost with the channel
+// closed by the broker, with the amqp_consumer_cancellations metric. It must be called before consuming.
+// The callback, if any, receives the consumer tag (empty when the channel was closed) and the reason.
+func (amqp *AMQP) watchCancellations(ch *amqpDriver.Channel, queueName string, callback func(consumer string, reason string)) {
+	cancels := ch.NotifyCancel(make(chan string, 1))
+	closes := ch.NotifyClose(make(chan *amqpDriver.Error, 1))
+
+	report := func(consumer string, reason string) {
+		amqp.metrics.push(amqp.metrics.ConsumerCancellations, 1, map[string]string{"queue": queueName, "reason": reason})
+		amqp.spendBudget(func(status *ErrorBudgetStatus) {
+			status.ConsumerCancellations++
+		})
+		if callback != nil {
+			callback(consumer, reason)
+		}
+	}
+	go func() {
+		for cancels != nil || closes != nil {
+			select {
+			case consumer, ok := <-cancels:
+				if !ok {
+					cancels = nil
+					continue
+				}
+				report(consumer, cancelByServer)
+			case closeErr, ok := <-closes:
+				if !ok {
+					closes = nil
+					continue
+				}
+				if closeErr != nil && closeErr.Server {
+					report("", closeReason(closeErr))
+				}
+			}
+		}
+	}()
+}
+
+// closeReason tells a consumer timeout from other channel closures.
+func closeReason(closeErr *amqpDriver.Error) string {
+	if closeErr.Code == amqpDriver.PreconditionFailed && strings.Contains(closeErr.Reason, "timeout") {
+		return cancelByTimeout
+	}
+	return cancelByChannelClose
+}
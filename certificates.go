@@ -0,0 +1,66 @@
+package amqp
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CertificateInfo describes a certificate presented by the broker.
+type CertificateInfo struct {
+	Subject         string
+	Issuer          string
+	NotBefore       string // RFC 3339
+	NotAfter        string // RFC 3339
+	DaysUntilExpiry int
+}
+
+// ServerCertificates returns the certificate chain presented by the broker, leaf first.
+func (amqp *AMQP) ServerCertificates() ([]CertificateInfo, error) {
+	chain, err := amqp.serverChain()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]CertificateInfo, 0, len(chain))
+	for _, cert := range chain {
+		infos = append(infos, CertificateInfo{
+			Subject:         cert.Subject.String(),
+			Issuer:          cert.Issuer.String(),
+			NotBefore:       cert.NotBefore.UTC().Format(time.RFC3339),
+			NotAfter:        cert.NotAfter.UTC().Format(time.RFC3339),
+			DaysUntilExpiry: daysUntil(cert.NotAfter),
+		})
+	}
+	return infos, nil
+}
+
+// serverChain returns the peer certificates of the TLS connection.
+func (amqp *AMQP) serverChain() ([]*x509.Certificate, error) {
+	conn := amqp.connection.get()
+	if conn == nil {
+		return nil, errors.New("no connection, call start first")
+	}
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("the connection does not use TLS")
+	}
+	return chain, nil
+}
+
+// checkCertificateValidity fails if any certificate of the chain expires within the given number of days.
+func checkCertificateValidity(chain []*x509.Certificate, minDays int) error {
+	for _, cert := range chain {
+		if days := daysUntil(cert.NotAfter); days < minDays {
+			return fmt.Errorf("certificate %q expires in %d days (%s), at least %d days required",
+				cert.Subject.String(), days, cert.NotAfter.UTC().Format(time.RFC3339), minDays)
+		}
+	}
+	return nil
+}
+
+// daysUntil returns the whole number of days left until t.
+func daysUntil(t time.Time) int {
+	return int(time.Until(t).Hours() / 24)
+}
@@ -0,0 +1,217 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+const maxChannelErrors = 100
+
+// ChannelError describes a channel of the session closed by the broker, e.g. with PRECONDITION_FAILED (406)
+// after acknowledging an unknown delivery tag.
+type ChannelError struct {
+	Code   int    // AMQP reply code
+	Name   string // AMQP reply name
+	Reason string
+	Time   int64 // unix milliseconds
+}
+
+// channelErrors keeps the last channel errors of a session.
+type channelErrors struct {
+	mu     sync.Mutex
+	errors []ChannelError
+}
+
+func (c *channelErrors) add(channelErr ChannelError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errors) == maxChannelErrors {
+		c.errors = c.errors[1:]
+	}
+	c.errors = append(c.errors, channelErr)
+}
+
+// watchChannel records the closure of a channel by the broker as amqp_channel_errors and in the event log,
+// where the flow control the broker applies to the channel is logged as well. The long lived channels of
+// consumers and publisher pools would otherwise die silently, failing the next operations.
+func (amqp *AMQP) watchChannel(ch *amqpDriver.Channel) {
+	closes := ch.NotifyClose(make(chan *amqpDriver.Error, 1))
+	flows := ch.NotifyFlow(make(chan bool, 1))
+	go func() {
+		for {
+			select {
+			case active, ok := <-flows:
+				if !ok {
+					flows = nil
+					continue
+				}
+				amqp.events.emit("channel_flow", map[string]interface{}{"active": active})
+			case closeErr, ok := <-closes:
+				if ok && closeErr != nil && closeErr.Server {
+					amqp.channelClosed(closeErr)
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (amqp *AMQP) channelClosed(closeErr *amqpDriver.Error) {
+	name, ok := replyNames[closeErr.Code]
+	if !ok {
+		name = "UNKNOWN"
+	}
+	amqp.channelErrors.add(ChannelError{
+		Code:   closeErr.Code,
+		Name:   name,
+		Reason: closeErr.Reason,
+		Time:   time.Now().UnixNano() / int64(time.Millisecond),
+	})
+	amqp.metrics.push(amqp.metrics.ChannelErrors, 1, map[string]string{"code": name})
+	amqp.events.emit("channel_close", map[string]interface{}{"code": closeErr.Code, "reason": closeErr.Reason})
+}
+
+// ChannelErrors returns the last channels of the session closed by the broker, oldest first, so a script can
+// tell that the channel of a consumer or of a publisher died instead of guessing from the failed operations.
+// Errors of the queue and exchange operations are thrown by the operations and not repeated here.
+func (amqp *AMQP) ChannelErrors() []ChannelError {
+	amqp.channelErrors.mu.Lock()
+	defer amqp.channelErrors.mu.Unlock()
+	return append([]ChannelError{}, amqp.channelErrors.errors...)
+}
+
+// Channel is a handle to a channel opened with Amqp.channel, kept open until the script closes it, so that
+// applications multiplexing long lived channels over a connection can be modeled.
+type Channel struct {
+	amqp     *AMQP
+	ch       *amqpDriver.Channel
+	confirms *confirmListeners // registered by the first confirmed publish, the channel then staying in confirm mode
+}
+
+// QosOptions limits the unacknowledged deliveries of the consumers of a channel.
+type QosOptions struct {
+	PrefetchCount int  // deliveries, 0 (unlimited) by default
+	PrefetchSize  int  // body bytes, 0 (unlimited) by default, not supported by RabbitMQ
+	Global        bool // shared by the consumers of the channel instead of applying to each of them
+}
+
+// Channel opens a channel whose publishes and consumers share it until it is closed, unlike Publish and
+// Listen opening a channel per call.
+func (amqp *AMQP) Channel() (*Channel, error) {
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, structuredError(err)
+	}
+	return &Channel{amqp: amqp, ch: ch}, nil
+}
+
+// Publish publishes a message on the channel and returns its correlation id, see Amqp.publish. Once a
+// message is published with confirm, every message of the channel waits for its confirm.
+func (c *Channel) Publish(options PublishOptions) (correlationID string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	defer func() {
+		if err != nil {
+			c.amqp.events.emit("error", map[string]interface{}{"operation": "publish", "error": err})
+		}
+		failed := err != nil
+		c.amqp.spendBudget(func(status *ErrorBudgetStatus) {
+			status.Publishes++
+			if failed {
+				status.PublishFailures++
+			}
+		})
+	}()
+
+	if options.ChunkSize > 0 || options.Retry.MaxAttempts > 1 {
+		return "", errors.New("chunks and retries are published on channels of their own, use Amqp.publish")
+	}
+	if options, err = options.correlate(); err != nil {
+		return "", err
+	}
+	if options.Confirm && c.confirms == nil {
+		if err = c.amqp.profile.checkConfirms(); err != nil {
+			return "", err
+		}
+		listeners, err := listenConfirms(c.ch)
+		if err != nil {
+			return "", err
+		}
+		c.confirms = &listeners
+	}
+	// The broker confirms every message of a channel in confirm mode.
+	options.Confirm = c.confirms != nil
+
+	options = c.amqp.stampTracked(options)
+	options = c.amqp.stampSequence(options, fmt.Sprintf("/%p", c))
+	defer func() {
+		c.amqp.forgetTracked(options, err)
+	}()
+	return options.CorrelationId, c.amqp.publishConfirming(c.ch, c.confirms, options)
+}
+
+// Consume starts a consumer on the channel and returns its tag, see Amqp.listen. It runs until it is
+// cancelled or the channel is closed, the iteration lasting until then.
+func (c *Channel) Consume(options ListenOptions) (string, error) {
+	consumer, _, err := c.amqp.listen(c.ch, options)
+	return consumer, structuredError(err)
+}
+
+// Cancel stops a consumer of the channel with basic.cancel.
+func (c *Channel) Cancel(consumer string) error {
+	return structuredError(c.ch.Cancel(consumer, false))
+}
+
+// Get fetches a message of the queue with basic.get, which does not wait: nil is returned if the queue
+// is empty. Only the queue name, the partition and the decoding options apply.
+func (c *Channel) Get(options GetOptions) (*Message, error) {
+	d, ok, err := c.ch.Get(options.QueueName, false)
+	if err != nil || !ok {
+		return nil, structuredError(err)
+	}
+	c.amqp.countConsumed(d, consumeTags(options.QueueName, options.Partition))
+	msg, err := c.amqp.newMessage(d, options.ProtoMessage, options.Avro)
+	if err != nil {
+		// A body that cannot be decoded would fail again on redelivery.
+		_ = d.Reject(false)
+		return nil, structuredError(err)
+	}
+	return msg, structuredError(d.Ack(false))
+}
+
+// Qos sets the prefetch of the consumers started on the channel afterwards, or of all of them if global.
+func (c *Channel) Qos(options QosOptions) error {
+	return structuredError(c.ch.Qos(options.PrefetchCount, options.PrefetchSize, options.Global))
+}
+
+// TxSelect puts the channel in transactional mode, the messages published and acknowledged on it then
+// taking effect on TxCommit only. A channel cannot be both transactional and in confirm mode.
+func (c *Channel) TxSelect() error {
+	return structuredError(c.ch.Tx())
+}
+
+// TxCommit commits the current transaction of the channel.
+func (c *Channel) TxCommit() error {
+	return structuredError(c.ch.TxCommit())
+}
+
+// TxRollback discards the messages published and acknowledged in the current transaction of the channel.
+func (c *Channel) TxRollback() error {
+	return structuredError(c.ch.TxRollback())
+}
+
+// IsClosed tells whether the channel was closed by the script or the broker.
+func (c *Channel) IsClosed() bool {
+	return c.ch.IsClosed()
+}
+
+// Close closes the channel, cancelling its consumers.
+func (c *Channel) Close() error {
+	return structuredError(c.ch.Close())
+}
@@ -0,0 +1,107 @@
+package amqp
+
+import (
+	"errors"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modulestest"
+)
+
+// newChannel returns a channel handle of a session started on the loopback broker, with a queue declared.
+func newChannel(t *testing.T, queueName string) *Channel {
+	t.Helper()
+
+	rt := modulestest.NewRuntime(t)
+	amqp := (&RootModule{}).instance(rt.VU)
+	if err := amqp.Start(Options{Loopback: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := amqp.Queue.Declare(DeclareOptions{Name: queueName}); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := amqp.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ch.Close()
+	})
+	return ch
+}
+
+func TestChannelPublishConfirmsEachMessage(t *testing.T) {
+	t.Parallel()
+
+	ch := newChannel(t, "channel-confirms")
+
+	_, err := ch.Publish(PublishOptions{RoutingKey: "channel-confirms-unroutable", Body: "lost", Mandatory: true, Confirm: true})
+	var publishErr *PublishError
+	if !errors.As(err, &publishErr) || publishErr.Class != publishFailureReturn {
+		t.Fatalf("unroutable mandatory message: got %v, want a return", err)
+	}
+
+	// The ack of the returned message must not be taken for the confirm of the next one, nor its return.
+	for _, body := range []string{"first", "second"} {
+		if _, err = ch.Publish(PublishOptions{QueueName: "channel-confirms", Body: body, Confirm: true}); err != nil {
+			t.Fatalf("routable message %s: %v", body, err)
+		}
+	}
+	for _, body := range []string{"first", "second"} {
+		msg, err := ch.Get(GetOptions{QueueName: "channel-confirms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || msg.Body != body {
+			t.Fatalf("got %+v, want %s", msg, body)
+		}
+	}
+}
+
+func TestChannelPublishWithoutConfirmThenConfirmed(t *testing.T) {
+	t.Parallel()
+
+	ch := newChannel(t, "channel-confirm-later")
+
+	if _, err := ch.Publish(PublishOptions{QueueName: "channel-confirm-later", Body: "unconfirmed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.Publish(PublishOptions{QueueName: "channel-confirm-later", Body: "confirmed", Confirm: true}); err != nil {
+		t.Fatal(err)
+	}
+	// Once in confirm mode every message of the channel waits for its confirm.
+	if _, err := ch.Publish(PublishOptions{RoutingKey: "channel-confirm-later-unroutable", Mandatory: true}); err == nil {
+		t.Fatal("unroutable mandatory message published on a channel in confirm mode")
+	}
+	if _, err := ch.Publish(PublishOptions{QueueName: "channel-confirm-later", Body: "after"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAwaitConfirmSkipsEarlierMessages(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	amqp := (&RootModule{}).instance(rt.VU)
+
+	confirms := make(chan amqpDriver.Confirmation, 3)
+	returns := make(chan amqpDriver.Return, 2)
+	listeners := confirmListeners{confirms: confirms, returns: returns, closes: make(chan *amqpDriver.Error)}
+
+	// Left over by a message whose confirm timed out.
+	returns <- amqpDriver.Return{ReplyCode: amqpDriver.NoRoute, ReplyText: "NO_ROUTE", CorrelationId: "earlier"}
+	confirms <- amqpDriver.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqpDriver.Confirmation{DeliveryTag: 2, Ack: false}
+	confirms <- amqpDriver.Confirmation{DeliveryTag: 3, Ack: true}
+	if err := amqp.awaitConfirm(listeners, pendingConfirm{tag: 3, correlationID: "current"}, 0, nil); err != nil {
+		t.Fatalf("confirm of an earlier message taken: %v", err)
+	}
+
+	returns <- amqpDriver.Return{ReplyCode: amqpDriver.NoRoute, ReplyText: "NO_ROUTE", CorrelationId: "next"}
+	confirms <- amqpDriver.Confirmation{DeliveryTag: 4, Ack: true}
+	err := amqp.awaitConfirm(listeners, pendingConfirm{tag: 4, correlationID: "next"}, 0, nil)
+	var publishErr *PublishError
+	if !errors.As(err, &publishErr) || publishErr.Class != publishFailureReturn {
+		t.Fatalf("got %v, want a return", err)
+	}
+}
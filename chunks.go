@@ -0,0 +1,175 @@
+package amqp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers of the messages carrying the chunks of a body published with a chunk size.
+const (
+	headerChunkId    = "x-k6-chunk-id"
+	headerChunkIndex = "x-k6-chunk-index"
+	headerChunkCount = "x-k6-chunk-count"
+	headerFileName   = "x-k6-file-name"
+)
+
+// chunkTransferTimeout is how long the chunks of an incomplete body are kept waiting for the others.
+const chunkTransferTimeout = 5 * time.Minute
+
+// checkChunks rejects the publish options whose body cannot be split, an encoded body being readable only whole.
+func (options PublishOptions) checkChunks() error {
+	if options.ProtoMessage != "" || options.AvroSubject != "" || options.ContentType == messagepack {
+		return errors.New("chunked bodies are sent as is, protobuf, Avro and MessagePack encodings are not supported")
+	}
+	if options.Retry.MaxAttempts > 1 {
+		return errors.New("chunked bodies cannot be republished, retries are not supported")
+	}
+	return nil
+}
+
+// publishChunked splits the body of the message over messages of at most ChunkSize bytes.
+func (amqp *AMQP) publishChunked(options PublishOptions) (correlationID string, err error) {
+	body := options.body
+	if body == nil {
+		body = []byte(options.Body)
+	}
+	if options.Payload.enabled() {
+		if body, _, err = amqp.generatePayload(options.Payload); err != nil {
+			return "", structuredError(err)
+		}
+		options.Payload = PayloadOptions{}
+	}
+	return amqp.publishChunks(bytes.NewReader(body), int64(len(body)), "", options)
+}
+
+// publishChunks streams the content of the reader as consecutive messages on a single channel, all of them
+// with the same correlation id and chunk id, stopping at the first failed publish.
+func (amqp *AMQP) publishChunks(content io.Reader, size int64, name string, options PublishOptions) (correlationID string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	defer func() {
+		if err != nil {
+			amqp.events.emit("error", map[string]interface{}{"operation": "publish", "error": err})
+		}
+	}()
+
+	if err = options.checkChunks(); err != nil {
+		return "", err
+	}
+	if options, err = options.correlate(); err != nil {
+		return "", err
+	}
+	chunkSize := int64(options.ChunkSize)
+	count := (size + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1 // an empty body is sent as an empty chunk
+	}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	id := newUUID()
+	buf := make([]byte, chunkSize)
+	for index := int64(0); index < count; index++ {
+		n, err := io.ReadFull(content, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		chunk := options
+		chunk.Headers = withField(chunk.Headers, headerChunkId, id)
+		chunk.Headers = withField(chunk.Headers, headerChunkIndex, index)
+		chunk.Headers = withField(chunk.Headers, headerChunkCount, count)
+		if name != "" {
+			chunk.Headers = withField(chunk.Headers, headerFileName, name)
+		}
+		chunk.body = buf[:n]
+		chunk = amqp.stampSequence(chunk, "")
+
+		err = amqp.publish(ch, chunk)
+		failed := err != nil
+		amqp.spendBudget(func(status *ErrorBudgetStatus) {
+			status.Publishes++
+			if failed {
+				status.PublishFailures++
+			}
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return options.CorrelationId, nil
+}
+
+// chunkAssembler is shared by the VUs of the test run: it collects the chunks of a body whichever VU
+// consumes them, the body being complete for the VU getting the last one.
+type chunkAssembler struct {
+	mu        sync.Mutex
+	transfers map[string]*chunkTransfer
+}
+
+type chunkTransfer struct {
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+// add records the decoded body of a delivery and returns the whole body once all the chunks of the
+// delivery are received. A delivery without chunk headers is whole. Chunks received again, e.g. after
+// a requeue, are counted once.
+func (a *chunkAssembler) add(d amqpDriver.Delivery, body []byte) (whole []byte, complete bool, err error) {
+	id, ok := d.Headers[headerChunkId].(string)
+	if !ok {
+		return body, true, nil
+	}
+	index, okIndex := d.Headers[headerChunkIndex].(int64)
+	count, okCount := d.Headers[headerChunkCount].(int64)
+	if !okIndex || !okCount || count <= 0 || index < 0 || index >= count {
+		return nil, false, fmt.Errorf("invalid chunk %v of %v of %s", d.Headers[headerChunkIndex], d.Headers[headerChunkCount], id)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.transfers == nil {
+		a.transfers = make(map[string]*chunkTransfer)
+	}
+	now := time.Now()
+	for key, transfer := range a.transfers {
+		if now.Sub(transfer.started) > chunkTransferTimeout {
+			delete(a.transfers, key)
+		}
+	}
+
+	transfer, ok := a.transfers[id]
+	if !ok {
+		transfer = &chunkTransfer{parts: make([][]byte, count), started: now}
+		a.transfers[id] = transfer
+	}
+	if int64(len(transfer.parts)) != count {
+		return nil, false, fmt.Errorf("chunk %d of %s announces %d chunks instead of %d", index, id, count, len(transfer.parts))
+	}
+	if transfer.parts[index] == nil {
+		transfer.parts[index] = body
+		if transfer.parts[index] == nil {
+			transfer.parts[index] = []byte{}
+		}
+		transfer.received++
+	}
+	if int64(transfer.received) < count {
+		return nil, false, nil
+	}
+	delete(a.transfers, id)
+	return bytes.Join(transfer.parts, nil), true, nil
+}
@@ -0,0 +1,119 @@
+package amqp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// BindingChurnOptions defines a run binding and unbinding a queue while publishing through the binding.
+type BindingChurnOptions struct {
+	QueueName    string
+	ExchangeName string
+	RoutingKey   string  // key bound, unbound and published with
+	ChurnRate    float64 // bind/unbind cycles per second, 10 by default
+	PublishRate  float64 // mandatory messages published per second, 100 by default
+	DurationSec  int     // how long the churn lasts, 10 by default
+}
+
+// BindingChurnResult summarises a binding churn run.
+type BindingChurnResult struct {
+	Cycles        int64   // bind/unbind cycles completed
+	ChurnErrors   int64   // failed bind or unbind operations
+	Published     int64   // messages published
+	PublishErrors int64   // failed publishes
+	Returned      int64   // messages returned as unroutable
+	ReturnRate    float64 // fraction of published messages returned
+}
+
+// BindingChurn repeatedly binds and unbinds a queue while publishing mandatory messages through
+// the binding, reporting routing failures (amqp_messages_returned) and churn failures
+// (amqp_binding_churn_errors) observed during the churn.
+func (amqp *AMQP) BindingChurn(options BindingChurnOptions) (result BindingChurnResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if options.ChurnRate <= 0 {
+		options.ChurnRate = 10
+	}
+	if options.PublishRate <= 0 {
+		options.PublishRate = 100
+	}
+	if options.DurationSec <= 0 {
+		options.DurationSec = 10
+	}
+
+	churnCh, err := amqp.channel()
+	if err != nil {
+		return BindingChurnResult{}, err
+	}
+	defer func() {
+		_ = churnCh.Close()
+	}()
+	publishCh, err := amqp.channel()
+	if err != nil {
+		return BindingChurnResult{}, err
+	}
+	defer func() {
+		_ = publishCh.Close()
+	}()
+
+	var returned int64
+	tags := map[string]string{"queue": options.QueueName, "exchange": options.ExchangeName}
+	returns := publishCh.NotifyReturn(make(chan amqpDriver.Return, 128))
+	go func() {
+		for range returns {
+			atomic.AddInt64(&returned, 1)
+			amqp.metrics.push(amqp.metrics.MessagesReturned, 1, tags)
+		}
+	}()
+
+	deadline := time.Now().Add(time.Duration(options.DurationSec) * time.Second)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		limiter := scaleLimiter(options.ChurnRate)
+		for time.Now().Before(deadline) && limiter.Wait(amqp.vu.Context()) == nil {
+			bindErr := churnCh.QueueBind(options.QueueName, options.RoutingKey, options.ExchangeName, false, nil)
+			if bindErr == nil {
+				bindErr = churnCh.QueueUnbind(options.QueueName, options.RoutingKey, options.ExchangeName, nil)
+			}
+			if bindErr == nil {
+				atomic.AddInt64(&result.Cycles, 1)
+				continue
+			}
+
+			atomic.AddInt64(&result.ChurnErrors, 1)
+			amqp.metrics.push(amqp.metrics.BindingChurnErrors, 1, tags)
+			if churnCh.IsClosed() {
+				if churnCh, bindErr = amqp.channel(); bindErr != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	limiter := scaleLimiter(options.PublishRate)
+	publishing := amqpDriver.Publishing{Body: []byte("k6 binding churn")}
+	for time.Now().Before(deadline) && limiter.Wait(amqp.vu.Context()) == nil {
+		publishErr := publishCh.PublishWithContext(amqp.vu.Context(), options.ExchangeName, options.RoutingKey, true, false, publishing)
+		if publishErr != nil {
+			result.PublishErrors++
+			continue
+		}
+		result.Published++
+	}
+	wg.Wait()
+
+	// Give the broker a moment to return the last unroutable messages.
+	_ = sleepContext(amqp.vu.Context(), 100*time.Millisecond)
+	result.Returned = atomic.LoadInt64(&returned)
+	if result.Published > 0 {
+		result.ReturnRate = float64(result.Returned) / float64(result.Published)
+	}
+	return result, nil
+}
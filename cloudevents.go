@@ -0,0 +1,271 @@
+package amqp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CloudEvents content modes of the AMQP protocol binding.
+const (
+	cloudEventBinary     = "binary"
+	cloudEventStructured = "structured"
+)
+
+const (
+	cloudEventSpecVersion = "1.0"
+	cloudEventContentType = "application/cloudevents+json"
+)
+
+// Prefixes of the application properties carrying the attributes in binary mode. The underscore
+// form is accepted on consume for brokers and clients not allowing a colon in header names.
+var cloudEventPrefixes = []string{"cloudEvents:", "cloudEvents_"} //nolint:gochecknoglobals
+
+var cloudEventExtensionName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// CloudEvent holds the context attributes and the data of a CloudEvents 1.0 event.
+// The fields are named after the attributes of the specification.
+type CloudEvent struct {
+	Specversion     string
+	Id              string // a uuid by default
+	Source          string
+	Type            string
+	Subject         string
+	Time            string // RFC 3339 timestamp, the current time by default
+	Datacontenttype string
+	Dataschema      string
+	Data            string // event payload, JSON data is kept as JSON text
+	Extensions      map[string]interface{}
+}
+
+// CloudEventOptions publishes the body as the data of a CloudEvents event.
+// The content type of the publish options is the datacontenttype of the event.
+type CloudEventOptions struct {
+	PublishOptions
+	Mode  string     // binary (default): attributes as cloudEvents: headers, or structured: JSON envelope
+	Event CloudEvent // context attributes, source and type are required
+}
+
+// PublishCloudEvent publishes a CloudEvents 1.0 event and returns its id.
+func (amqp *AMQP) PublishCloudEvent(options CloudEventOptions) (id string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	event := options.Event
+	if event.Source == "" || event.Type == "" {
+		return "", errors.New("cloud events need a source and a type")
+	}
+	event.Specversion = cloudEventSpecVersion
+	if event.Id == "" {
+		event.Id = newUUID()
+	}
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	event.Datacontenttype = options.ContentType
+	for name := range event.Extensions {
+		if !cloudEventExtensionName.MatchString(name) || isCloudEventAttribute(name) {
+			return "", fmt.Errorf("invalid cloud event extension name %q", name)
+		}
+	}
+
+	publish := options.PublishOptions
+	switch options.Mode {
+	case "", cloudEventBinary:
+		for name, value := range event.attributes() {
+			publish.Headers = withField(publish.Headers, cloudEventPrefixes[0]+name, value)
+		}
+	case cloudEventStructured:
+		if publish.ProtoMessage != "" || publish.AvroSubject != "" {
+			return "", errors.New("structured cloud events carry text or JSON data, use the binary mode")
+		}
+		if publish.Payload.enabled() {
+			body, _, err := amqp.generatePayload(publish.Payload)
+			if err != nil {
+				return "", err
+			}
+			publish.Body, publish.Payload = string(body), PayloadOptions{}
+		}
+		envelope, err := event.envelope(publish.Body)
+		if err != nil {
+			return "", err
+		}
+		publish.Body, publish.ContentType = string(envelope), cloudEventContentType
+	default:
+		return "", fmt.Errorf("unknown cloud event mode %q, expected binary or structured", options.Mode)
+	}
+
+	if _, err = amqp.Publish(publish); err != nil {
+		return "", err
+	}
+	return event.Id, nil
+}
+
+// ParseCloudEvent reads the CloudEvents event of a consumed message, in binary or structured mode.
+func (amqp *AMQP) ParseCloudEvent(message *Message) (event *CloudEvent, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if message == nil {
+		return nil, errors.New("no message to parse")
+	}
+	if strings.HasPrefix(message.ContentType, "application/cloudevents") {
+		event, err = parseStructuredCloudEvent([]byte(message.Body))
+	} else {
+		event = parseBinaryCloudEvent(message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Specversion == "" {
+		return nil, errors.New("the message is not a cloud event, no specversion")
+	}
+	if !strings.HasPrefix(event.Specversion, "1.") {
+		return nil, fmt.Errorf("unsupported cloud event specversion %q", event.Specversion)
+	}
+	return event, nil
+}
+
+// attributes returns the attributes set, the extensions included, without the data and its content type.
+func (event CloudEvent) attributes() map[string]interface{} {
+	attributes := make(map[string]interface{}, len(event.Extensions)+7)
+	for name, value := range event.Extensions {
+		attributes[name] = value
+	}
+	for name, value := range map[string]string{
+		"specversion": event.Specversion,
+		"id":          event.Id,
+		"source":      event.Source,
+		"type":        event.Type,
+		"subject":     event.Subject,
+		"time":        event.Time,
+		"dataschema":  event.Dataschema,
+	} {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+	return attributes
+}
+
+// envelope renders the event in the JSON format, JSON data being embedded as is.
+func (event CloudEvent) envelope(data string) ([]byte, error) {
+	doc := event.attributes()
+	if event.Datacontenttype != "" {
+		doc["datacontenttype"] = event.Datacontenttype
+	}
+	if isJSONContentType(event.Datacontenttype) && json.Valid([]byte(data)) {
+		doc["data"] = json.RawMessage(data)
+	} else {
+		doc["data"] = data
+	}
+	return json.Marshal(doc)
+}
+
+// isJSONContentType tells whether data of the content type is JSON, as assumed without a content type.
+func isJSONContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == "" || mediaType == "application/json" || mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+func isCloudEventAttribute(name string) bool {
+	switch name {
+	case "specversion", "id", "source", "type", "subject", "time", "datacontenttype", "dataschema", "data", "data_base64":
+		return true
+	}
+	return false
+}
+
+// setAttribute sets a context attribute or an extension of the event.
+func (event *CloudEvent) setAttribute(name string, value interface{}) {
+	text := func() string {
+		switch v := value.(type) {
+		case string:
+			return v
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	switch name {
+	case "specversion":
+		event.Specversion = text()
+	case "id":
+		event.Id = text()
+	case "source":
+		event.Source = text()
+	case "type":
+		event.Type = text()
+	case "subject":
+		event.Subject = text()
+	case "time":
+		event.Time = text()
+	case "datacontenttype":
+		event.Datacontenttype = text()
+	case "dataschema":
+		event.Dataschema = text()
+	default:
+		if event.Extensions == nil {
+			event.Extensions = make(map[string]interface{})
+		}
+		event.Extensions[name] = value
+	}
+}
+
+func parseBinaryCloudEvent(message *Message) *CloudEvent {
+	event := &CloudEvent{Datacontenttype: message.ContentType, Data: message.Body}
+	for header, value := range message.Headers {
+		for _, prefix := range cloudEventPrefixes {
+			if strings.HasPrefix(header, prefix) {
+				event.setAttribute(strings.TrimPrefix(header, prefix), value)
+				break
+			}
+		}
+	}
+	return event
+}
+
+func parseStructuredCloudEvent(body []byte) (*CloudEvent, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid structured cloud event: %w", err)
+	}
+
+	event := &CloudEvent{}
+	for name, raw := range doc {
+		switch name {
+		case "data":
+			var text string
+			if json.Unmarshal(raw, &text) == nil {
+				event.Data = text
+			} else {
+				event.Data = string(raw)
+			}
+		case "data_base64":
+			var encoded string
+			if err := json.Unmarshal(raw, &encoded); err != nil {
+				return nil, fmt.Errorf("invalid data_base64 of the cloud event: %w", err)
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid data_base64 of the cloud event: %w", err)
+			}
+			event.Data = string(data)
+		default:
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			event.setAttribute(name, value)
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,80 @@
+package amqp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported for message bodies.
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingZstd    = "zstd"
+)
+
+// compress encodes the body with the given content encoding.
+func compress(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingDeflate:
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case encodingZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unsupported compression %q, expected one of gzip, deflate, zstd", encoding)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decodes a body according to its content encoding.
+// Bodies with an unknown or empty content encoding are returned unchanged.
+func decompress(body []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+
+	switch encoding {
+	case encodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	case encodingDeflate:
+		r = flate.NewReader(bytes.NewReader(body))
+	case encodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = zr.IOReadCloser()
+	default:
+		return body, nil
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return io.ReadAll(r)
+}
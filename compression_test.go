@@ -0,0 +1,62 @@
+package amqp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("k6 amqp payload "), 256)
+	for _, encoding := range []string{encodingGzip, encodingDeflate, encodingZstd} {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			t.Parallel()
+
+			compressed, err := compress(body, encoding)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			if len(compressed) >= len(body) {
+				t.Errorf("compressed body of %d bytes, expected fewer than %d", len(compressed), len(body))
+			}
+			decompressed, err := decompress(compressed, encoding)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(decompressed, body) {
+				t.Errorf("round trip changed the body")
+			}
+		})
+	}
+}
+
+func TestCompressUnknownEncoding(t *testing.T) {
+	t.Parallel()
+
+	if _, err := compress([]byte("body"), "br"); err == nil {
+		t.Fatal("expected an error for an unsupported encoding")
+	}
+}
+
+func TestDecompressPassesThroughUnknownEncoding(t *testing.T) {
+	t.Parallel()
+
+	for _, encoding := range []string{"", "identity", "br"} {
+		decompressed, err := decompress([]byte("plain"), encoding)
+		if err != nil || string(decompressed) != "plain" {
+			t.Errorf("decompress(%q) = %q, %v, expected the body unchanged", encoding, decompressed, err)
+		}
+	}
+}
+
+func TestDecompressCorruptBody(t *testing.T) {
+	t.Parallel()
+
+	for _, encoding := range []string{encodingGzip, encodingZstd} {
+		if _, err := decompress([]byte("not compressed"), encoding); err == nil {
+			t.Errorf("decompress(%q) of a corrupt body succeeded", encoding)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Failure classes of a confirmed publish.
+const (
+	publishFailureNack            = "nack"
+	publishFailureReturn          = "return"
+	publishFailureChannelClose    = "channel_close"
+	publishFailureConnectionClose = "connection_close"
+	publishFailureTimeout         = "timeout"
+)
+
+const defaultConfirmTimeout = 5 * time.Second
+
+// PublishError describes why a confirmed publish was not accepted by the broker.
+type PublishError struct {
+	Class   string // nack, return, channel_close, connection_close or timeout
+	Message string
+}
+
+// Error implements the error interface.
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("publish failed (%s): %s", e.Class, e.Message)
+}
+
+// pendingConfirm identifies the message awaitConfirm waits for: the delivery tag the channel gave it, read
+// with GetNextPublishSeqNo before publishing, and the ids telling its basic.return from those of others.
+type pendingConfirm struct {
+	tag           uint64
+	messageID     string
+	correlationID string
+}
+
+func (p pendingConfirm) returned(r amqpDriver.Return) bool {
+	return r.MessageId == p.messageID && r.CorrelationId == p.correlationID
+}
+
+// awaitConfirm waits for the broker to confirm a message published on a channel in confirm mode.
+// Listeners must be registered before publishing, see confirmListeners. They outlive the publish on a
+// long lived channel, so the confirms and returns left over by earlier messages of the channel, e.g.
+// after a timeout, are skipped. Failures are emitted as amqp_publish_failures with the tags given and
+// the failure class.
+func (amqp *AMQP) awaitConfirm(
+	listeners confirmListeners, pending pendingConfirm, timeout time.Duration, tags map[string]string,
+) error {
+	if timeout <= 0 {
+		timeout = defaultConfirmTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err, returned *PublishError
+	for err == nil {
+		select {
+		case r := <-listeners.returns:
+			// The ack of a returned message follows its basic.return.
+			if pending.returned(r) {
+				returned = &PublishError{Class: publishFailureReturn, Message: fmt.Sprintf("%d %s", r.ReplyCode, r.ReplyText)}
+			}
+			continue
+		case c, ok := <-listeners.confirms:
+			switch {
+			case !ok:
+				err = amqp.closeFailure(nil)
+			case c.DeliveryTag < pending.tag:
+				continue
+			case !c.Ack:
+				err = &PublishError{Class: publishFailureNack, Message: "message rejected by the broker"}
+			case returned != nil:
+				err = returned
+			default:
+				// basic.return always precedes the ack of an unroutable mandatory message.
+				select {
+				case r := <-listeners.returns:
+					if pending.returned(r) {
+						err = &PublishError{Class: publishFailureReturn, Message: fmt.Sprintf("%d %s", r.ReplyCode, r.ReplyText)}
+					}
+				default:
+				}
+				if err == nil {
+					return nil
+				}
+			}
+		case closeErr := <-listeners.closes:
+			err = amqp.closeFailure(closeErr)
+		case <-amqp.vu.Context().Done():
+			return amqp.vu.Context().Err()
+		case <-timer.C:
+			err = &PublishError{Class: publishFailureTimeout, Message: fmt.Sprintf("no confirmation within %s", timeout)}
+		}
+	}
+
+	failureTags := map[string]string{"class": err.Class}
+	for k, v := range tags {
+		failureTags[k] = v
+	}
+	amqp.metrics.push(amqp.metrics.PublishFailures, 1, failureTags)
+	return err
+}
+
+// closeFailure tells a channel closed by the broker from a lost connection.
+func (amqp *AMQP) closeFailure(closeErr *amqpDriver.Error) *PublishError {
+	message := "channel closed"
+	if closeErr != nil {
+		message = closeErr.Error()
+	}
+	if amqp.connection.get().IsClosed() {
+		return &PublishError{Class: publishFailureConnectionClose, Message: message}
+	}
+	return &PublishError{Class: publishFailureChannelClose, Message: message}
+}
+
+// confirmListeners holds the channel notifications needed to classify a confirmed publish.
+type confirmListeners struct {
+	confirms <-chan amqpDriver.Confirmation
+	returns  <-chan amqpDriver.Return
+	closes   <-chan *amqpDriver.Error
+}
+
+// listenConfirms puts the channel in confirm mode and registers the notifications for awaitConfirm.
+func listenConfirms(ch *amqpDriver.Channel) (confirmListeners, error) {
+	if err := ch.Confirm(false); err != nil {
+		return confirmListeners{}, err
+	}
+	return confirmListeners{
+		confirms: ch.NotifyPublish(make(chan amqpDriver.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqpDriver.Return, 1)),
+		closes:   ch.NotifyClose(make(chan *amqpDriver.Error, 1)),
+	}, nil
+}
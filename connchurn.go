@@ -0,0 +1,131 @@
+package amqp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ConnectionChurnOptions defines a run opening and closing connections and channels, see Churn.
+type ConnectionChurnOptions struct {
+	Rate        float64 // connections opened per second, 10 by default
+	Channels    int     // channels opened and closed on every connection, 1 by default, -1 for none
+	HoldMs      int     // how long every connection stays open before it is closed
+	DurationSec int     // how long the churn lasts, 10 by default
+}
+
+// ConnectionChurnResult summarises a connection churn run.
+type ConnectionChurnResult struct {
+	Connections   int64   // connections opened and closed
+	ConnectErrors int64   // failed connection attempts
+	Channels      int64   // channels opened and closed
+	ChannelErrors int64   // failed channel openings
+	AvgConnectMs  float64 // average time to connect, TLS and AMQP handshakes included
+	MaxConnectMs  float64
+	AvgChannelMs  float64 // average time to open a channel
+}
+
+// connectionChurn accumulates the latencies of a churn run from concurrent cycles.
+type connectionChurn struct {
+	mu        sync.Mutex
+	result    ConnectionChurnResult
+	connectMs float64
+	channelMs float64
+}
+
+// Churn opens and closes connections at a fixed rate for the duration of the run, each cycle opening and
+// closing channels on its connection, since clients reconnecting in a loop are a classic way to overload a
+// broker. The connections are dialled as configured by start but not shared with the session. The time to
+// connect is reported as amqp_connect_duration, the time to open a channel as amqp_channel_open_duration,
+// and the failures as amqp_connection_churn_errors. Cycles are started on schedule even if the previous
+// ones are still connecting, the call returning once all of them are done.
+func (amqp *AMQP) Churn(options ConnectionChurnOptions) (result ConnectionChurnResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if amqp.connection.get() == nil {
+		return result, errors.New("no connection, call start first")
+	}
+	if options.Rate <= 0 {
+		options.Rate = 10
+	}
+	if options.Channels == 0 {
+		options.Channels = 1
+	}
+	if options.DurationSec <= 0 {
+		options.DurationSec = 10
+	}
+
+	churn := &connectionChurn{}
+	deadline := time.Now().Add(time.Duration(options.DurationSec) * time.Second)
+	limiter := scaleLimiter(options.Rate)
+	var wg sync.WaitGroup
+	for time.Now().Before(deadline) && limiter.Wait(amqp.vu.Context()) == nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			amqp.churnConnection(churn, options)
+		}()
+	}
+	wg.Wait()
+
+	result = churn.result
+	if result.Connections > 0 {
+		result.AvgConnectMs = churn.connectMs / float64(result.Connections)
+	}
+	if result.Channels > 0 {
+		result.AvgChannelMs = churn.channelMs / float64(result.Channels)
+	}
+	return result, nil
+}
+
+// churnConnection runs a single cycle: connect, open and close the channels, hold and close the connection.
+func (amqp *AMQP) churnConnection(churn *connectionChurn, options ConnectionChurnOptions) {
+	started := time.Now()
+	conn, _, err := amqp.dialNodes()
+	if err != nil {
+		amqp.metrics.push(amqp.metrics.ConnectionChurnErrors, 1, nil)
+		churn.mu.Lock()
+		churn.result.ConnectErrors++
+		churn.mu.Unlock()
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	connectMs := sinceMs(started)
+	amqp.metrics.push(amqp.metrics.ConnectDuration, connectMs, nil)
+
+	var channels, channelErrors int64
+	var channelMs float64
+	for i := 0; i < options.Channels; i++ {
+		started = time.Now()
+		ch, err := conn.Channel()
+		if err != nil {
+			amqp.metrics.push(amqp.metrics.ConnectionChurnErrors, 1, nil)
+			channelErrors++
+			// A channel refused by the broker, e.g. beyond the channel limit, closes the connection.
+			break
+		}
+		elapsed := sinceMs(started)
+		amqp.metrics.push(amqp.metrics.ChannelOpenDuration, elapsed, nil)
+		channelMs += elapsed
+		channels++
+		_ = ch.Close()
+	}
+	if options.HoldMs > 0 {
+		_ = sleepContext(amqp.vu.Context(), time.Duration(options.HoldMs)*time.Millisecond)
+	}
+
+	churn.mu.Lock()
+	defer churn.mu.Unlock()
+	churn.result.Connections++
+	churn.connectMs += connectMs
+	if connectMs > churn.result.MaxConnectMs {
+		churn.result.MaxConnectMs = connectMs
+	}
+	churn.result.Channels += channels
+	churn.result.ChannelErrors += channelErrors
+	churn.channelMs += channelMs
+}
@@ -0,0 +1,69 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// hashProperties lists the message properties an x-consistent-hash exchange can hash.
+var hashProperties = map[string]bool{ //nolint:gochecknoglobals
+	"message_id":     true,
+	"correlation_id": true,
+	"timestamp":      true,
+}
+
+// consistentHashArgs returns the arguments of an x-consistent-hash exchange hashing a header or a property
+// of the messages rather than their routing key.
+func consistentHashArgs(args amqpDriver.Table, kind string, header string, property string) (amqpDriver.Table, error) {
+	if header == "" && property == "" {
+		return args, nil
+	}
+	if kind != consistentHashKind {
+		return nil, fmt.Errorf("hash_header and hash_property only apply to %s exchanges", consistentHashKind)
+	}
+	if header != "" && property != "" {
+		return nil, errors.New("hash_header and hash_property are mutually exclusive")
+	}
+	if header != "" {
+		return withField(args, "hash-header", header), nil
+	}
+	if !hashProperties[property] {
+		return nil, fmt.Errorf("unsupported hash property %q, expected one of message_id, correlation_id, timestamp", property)
+	}
+	return withField(args, "hash-property", property), nil
+}
+
+// BindWeighted binds queues to an x-consistent-hash exchange, each with its weight as the binding key:
+// a queue bound with weight 2 gets about twice the messages of a queue bound with weight 1.
+func (queue *Queue) BindWeighted(exchangeName string, weights map[string]int) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	names := make([]string, 0, len(weights))
+	for name, weight := range weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of queue %q must be positive, got %d", name, weight)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ch, err := queue.channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	for _, name := range names {
+		if err = ch.QueueBind(name, strconv.Itoa(weights[name]), exchangeName, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
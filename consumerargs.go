@@ -0,0 +1,239 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultStreamPrefetch is the prefetch of stream consumers started by Listen.
+const defaultStreamPrefetch = 100
+
+// Queue types accepted by the x-queue-type argument.
+const (
+	queueTypeClassic = "classic"
+	queueTypeQuorum  = "quorum"
+	queueTypeStream  = "stream"
+)
+
+// Overflow behaviors accepted by the x-overflow argument.
+const (
+	overflowDropHead         = "drop-head"
+	overflowRejectPublish    = "reject-publish"
+	overflowRejectPublishDLX = "reject-publish-dlx"
+)
+
+// ConsumerArgs are the consumer arguments commonly combined, checked against the type of the queue
+// when it was declared by the VU, as brokers silently ignore arguments not applying to a queue type.
+type ConsumerArgs struct {
+	StreamOffset          interface{} // first, last, next, an offset number or a Date, streams only
+	StreamFilter          []string    // filter values of the messages to receive, streams only
+	StreamMatchUnfiltered bool        // also receive messages published without a filter value, streams only
+	Priority              int         // consumer priority, classic and quorum queues only
+	TimeoutMs             int         // consumer timeout overriding the one of the queue, classic and quorum queues only
+}
+
+// table merges the consumer arguments into the raw arguments of a consume call.
+func (args ConsumerArgs) table(base amqpDriver.Table, queueType string, autoAck bool) (amqpDriver.Table, error) {
+	table := base
+	if args.stream() {
+		if queueType != "" && queueType != queueTypeStream {
+			return nil, fmt.Errorf("stream consumer arguments do not apply to a %s queue", queueType)
+		}
+		if autoAck {
+			return nil, errors.New("stream consumers must acknowledge deliveries, auto ack is not allowed")
+		}
+	}
+
+	if args.StreamOffset != nil {
+		offset, err := streamOffset(args.StreamOffset)
+		if err != nil {
+			return nil, err
+		}
+		table = withField(table, "x-stream-offset", offset)
+	}
+	if len(args.StreamFilter) > 0 {
+		filters := make([]interface{}, len(args.StreamFilter))
+		for i, filter := range args.StreamFilter {
+			filters[i] = filter
+		}
+		table = withField(table, "x-stream-filter", filters)
+	}
+	if args.StreamMatchUnfiltered {
+		table = withField(table, "x-stream-match-unfiltered", true)
+	}
+
+	if args.Priority != 0 {
+		if queueType == queueTypeStream {
+			return nil, errors.New("consumer priority does not apply to a stream queue")
+		}
+		table = withField(table, "x-priority", int32(args.Priority))
+	}
+	if args.TimeoutMs > 0 {
+		if queueType == queueTypeStream {
+			return nil, errors.New("consumer timeout does not apply to a stream queue")
+		}
+		table = withField(table, "x-consumer-timeout", int64(args.TimeoutMs))
+	}
+	return table, nil
+}
+
+// withPriority merges the typed priority of the consume options, which must agree with Priority when both are set.
+func (args ConsumerArgs) withPriority(priority int) (ConsumerArgs, error) {
+	if priority == 0 {
+		return args, nil
+	}
+	if args.Priority != 0 && args.Priority != priority {
+		return args, fmt.Errorf("consumer priority set to both %d and %d in consumer_args", priority, args.Priority)
+	}
+	args.Priority = priority
+	return args, nil
+}
+
+// stream tells whether stream consumer arguments are set.
+func (args ConsumerArgs) stream() bool {
+	return args.StreamOffset != nil || len(args.StreamFilter) > 0 || args.StreamMatchUnfiltered
+}
+
+// prefetch applies the prefetch stream consumers require to a channel without one.
+func (args ConsumerArgs) prefetch(ch *amqpDriver.Channel, count int) error {
+	if !args.stream() {
+		return nil
+	}
+	return ch.Qos(count, 0, false)
+}
+
+// streamOffsetOf returns the offset of a message delivered from a stream queue, nil for other queues.
+func streamOffsetOf(headers amqpDriver.Table) *int64 {
+	if offset, ok := headers["x-stream-offset"].(int64); ok {
+		return &offset
+	}
+	return nil
+}
+
+// streamOffset converts the offset specification of a stream consumer to its argument value.
+func streamOffset(offset interface{}) (interface{}, error) {
+	switch v := offset.(type) {
+	case string:
+		switch v {
+		case "first", "last", "next":
+			return v, nil
+		}
+		// Relative intervals such as 1h or 30m are supported by the broker.
+		if _, err := time.ParseDuration(v); err == nil {
+			return v, nil
+		}
+	case int64:
+		if v >= 0 {
+			return v, nil
+		}
+	case float64:
+		if v >= 0 && v <= maxSafeInteger && v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case time.Time:
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid stream offset %v, expected first, last, next, an offset, an interval or a Date", offset)
+}
+
+// queueTypeArgs validates the typed queue options against the queue type and merges them into the declare arguments.
+func (options DeclareOptions) queueTypeArgs() (amqpDriver.Table, string, error) {
+	args := options.Args
+	queueType := options.Type
+	if declared, ok := args["x-queue-type"].(string); ok && queueType == "" {
+		queueType = declared
+	}
+
+	switch queueType {
+	case "", queueTypeClassic:
+	case queueTypeQuorum, queueTypeStream:
+		if !options.Durable || options.Exclusive || options.DeleteWhenUnused {
+			return nil, "", fmt.Errorf("%s queues must be durable, non exclusive and not deleted when unused", queueType)
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown queue type %q, expected classic, quorum or stream", queueType)
+	}
+	if options.Type != "" {
+		args = withField(args, "x-queue-type", options.Type)
+	}
+
+	if options.SingleActiveConsumer {
+		if queueType == queueTypeStream {
+			return nil, "", errors.New("single active consumer is only available to stream protocol clients on stream queues")
+		}
+		args = withField(args, "x-single-active-consumer", true)
+	}
+	if options.ConsumerTimeoutMs > 0 {
+		if queueType == queueTypeStream {
+			return nil, "", errors.New("consumer timeout does not apply to a stream queue")
+		}
+		args = withField(args, "x-consumer-timeout", int64(options.ConsumerTimeoutMs))
+	}
+	if options.MessageTTL < 0 || options.QueueTTL < 0 {
+		return nil, "", errors.New("message and queue TTLs must not be negative")
+	}
+	if options.MessageTTL > 0 || options.QueueTTL > 0 {
+		if queueType == queueTypeStream {
+			return nil, "", errors.New("stream queues are truncated by retention, message and queue TTLs do not apply")
+		}
+		if options.MessageTTL > 0 {
+			args = withField(args, "x-message-ttl", int64(options.MessageTTL))
+		}
+		if options.QueueTTL > 0 {
+			args = withField(args, "x-expires", int64(options.QueueTTL))
+		}
+	}
+	args, err := options.lengthArgs(args, queueType)
+	if err != nil {
+		return nil, "", err
+	}
+	return args, queueType, nil
+}
+
+// lengthArgs validates the typed length limit, overflow, deduplication and lazy mode options against the queue
+// type and merges them into the declare arguments.
+func (options DeclareOptions) lengthArgs(args amqpDriver.Table, queueType string) (amqpDriver.Table, error) {
+	if options.MaxLength < 0 || options.MaxLengthBytes < 0 {
+		return nil, errors.New("queue length limits must not be negative")
+	}
+	if options.MaxLength > 0 {
+		if queueType == queueTypeStream {
+			return nil, errors.New("stream queues are truncated by retention, use max_length_bytes instead of max_length")
+		}
+		args = withField(args, "x-max-length", int64(options.MaxLength))
+	}
+	if options.MaxLengthBytes > 0 {
+		args = withField(args, "x-max-length-bytes", options.MaxLengthBytes)
+	}
+
+	switch options.Overflow {
+	case "":
+	case overflowDropHead, overflowRejectPublish, overflowRejectPublishDLX:
+		if queueType == queueTypeStream {
+			return nil, errors.New("stream queues do not overflow, the overflow behavior does not apply")
+		}
+		if options.Overflow == overflowRejectPublishDLX && queueType == queueTypeQuorum {
+			return nil, errors.New("quorum queues do not support the reject-publish-dlx overflow behavior")
+		}
+		args = withField(args, "x-overflow", options.Overflow)
+	default:
+		return nil, fmt.Errorf("unknown overflow behavior %q, expected drop-head, reject-publish or reject-publish-dlx", options.Overflow)
+	}
+
+	if options.Deduplication {
+		if queueType != "" && queueType != queueTypeClassic {
+			return nil, fmt.Errorf("deduplication only applies to classic queues, not to %s queues", queueType)
+		}
+		args = withField(args, deduplicationKind, true)
+	}
+	if options.Lazy {
+		if queueType != "" && queueType != queueTypeClassic {
+			return nil, fmt.Errorf("lazy mode only applies to classic queues, not to %s queues", queueType)
+		}
+		args = withField(args, "x-queue-mode", "lazy")
+	}
+	return args, nil
+}
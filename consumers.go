@@ -0,0 +1,380 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// ConsumersOptions defines a fleet of consumers simulated within a single VU.
+type ConsumersOptions struct {
+	QueueName         string
+	Count             int    // number of consumers to start, 1 by default
+	Channels          int    // number of pooled channels the consumers are spread across, Count by default
+	Prefetch          int    // unacknowledged deliveries allowed per consumer, 0 (unlimited) by default
+	AckStrategy       string // auto, ack (default), nack (requeue) or reject (discard)
+	ProcessingDelayMs int    // simulated processing time for every delivery
+	ConsumerPrefix    string // consumer tags are generated as <prefix>-<index>
+	Exclusive         bool
+	Args              amqpDriver.Table
+	ConsumerArgs      ConsumerArgs           // typed stream offset and filter or priority arguments, merged into Args
+	Schema            map[string]interface{} // JSON Schema the bodies are checked against, see amqp_validation_failures
+	StallThresholdMs  int                    // silence after which a consumer is reported as stalled, see amqp_consumer_stalled
+	MaxRatePerSecond  float64                // deliveries processed per second by the whole group, see amqp_consume_rate
+	Tags              map[string]string      // extra tags of the consume metrics, e.g. the phase of RunPhases
+	Priority          int                    // consumer priority of every consumer of the group, see ListenOptions
+
+	// NackRate is the fraction (0-1) of the deliveries rejected with requeue by the ack strategy, to simulate
+	// a NACK storm. PoisonThreshold and PoisonReject detect and dead letter the messages caught in a
+	// redelivery loop, see ListenOptions.
+	NackRate        float64
+	PoisonThreshold int
+	PoisonReject    bool
+}
+
+// ConsumerGroup is a handle to consumers started with StartConsumers.
+type ConsumerGroup struct {
+	channels  []*amqpDriver.Channel
+	consumers []string
+	consumed  []int64
+	wg        sync.WaitGroup
+	stopOnce  sync.Once
+	stopped   chan struct{} // closed by Stop
+	pacer     *consumerPacer
+
+	cancelled   []string // consumers kicked by the broker
+	cancelMu    sync.Mutex
+	expired     chan struct{} // closed once a drain times out
+	expireOnce  sync.Once
+	unprocessed int64
+
+	active             string          // consumer which got the latest delivery, see Active
+	lostAt             time.Time       // when the active consumer was cancelled, zero unless a failover is pending
+	cancelledConsumers map[string]bool // consumers cancelled by the script or the broker
+	activeMu           sync.Mutex
+}
+
+var ackStrategies = map[string]bool{
+	"":       true,
+	"auto":   true,
+	"ack":    true,
+	"nack":   true,
+	"reject": true,
+}
+
+// StartConsumers spins up a group of concurrent consumers processing deliveries in the background.
+func (amqp *AMQP) StartConsumers(options ConsumersOptions) (group *ConsumerGroup, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if !ackStrategies[options.AckStrategy] {
+		return nil, fmt.Errorf("unsupported ack strategy %q, expected one of auto, ack, nack, reject", options.AckStrategy)
+	}
+	if options.NackRate < 0 || options.NackRate > 1 {
+		return nil, fmt.Errorf("nack rate must be between 0 and 1, got %v", options.NackRate)
+	}
+	if options.NackRate > 0 && options.AckStrategy != "" && options.AckStrategy != "ack" {
+		return nil, errors.New("nack_rate only applies to the ack strategy")
+	}
+	if options.PoisonReject && (options.PoisonThreshold <= 0 || options.AckStrategy == "auto") {
+		return nil, errors.New("poison_reject needs a positive poison_threshold and acknowledged deliveries")
+	}
+	schema, err := compileOptionalSchema(options.Schema)
+	if err != nil {
+		return nil, err
+	}
+	consumerArgs, err := options.ConsumerArgs.withPriority(options.Priority)
+	if err != nil {
+		return nil, err
+	}
+	args, err := amqp.consumerArgs(consumerArgs, options.Args, options.QueueName, options.AckStrategy == "auto")
+	if err != nil {
+		return nil, err
+	}
+
+	count := options.Count
+	if count <= 0 {
+		count = 1
+	}
+	channels := options.Channels
+	if channels <= 0 || channels > count {
+		channels = count
+	}
+	prefix := options.ConsumerPrefix
+	if prefix == "" {
+		prefix = "k6-consumer"
+	}
+
+	group = &ConsumerGroup{
+		consumers: make([]string, count),
+		consumed:  make([]int64, count),
+		expired:   make(chan struct{}),
+		stopped:   make(chan struct{}),
+		pacer:     amqp.paceConsumer(options.MaxRatePerSecond, options.tags("")),
+	}
+	group.stopOnDone(amqp.vu.Context())
+	for i := 0; i < channels; i++ {
+		ch, err := amqp.channel()
+		if err != nil {
+			_ = group.Stop()
+			return nil, err
+		}
+		group.channels = append(group.channels, ch)
+		index := i
+		amqp.watchCancellations(ch, options.QueueName, func(consumer string, _ string) {
+			if consumer != "" {
+				group.kicked(consumer)
+				return
+			}
+			// Every consumer of the channel is lost with it.
+			for j := index; j < count; j += channels {
+				group.kicked(fmt.Sprintf("%s-%d", prefix, j))
+			}
+		})
+	}
+
+	autoAck := options.AckStrategy == "auto"
+	prefetch := options.Prefetch
+	if prefetch <= 0 && options.ConsumerArgs.stream() {
+		// Stream consumers are refused without a prefetch.
+		prefetch = defaultStreamPrefetch
+	}
+	delay := time.Duration(options.ProcessingDelayMs) * time.Millisecond
+	poison := newPoisonDetector(options.PoisonThreshold)
+	for i := 0; i < count; i++ {
+		ch := group.channels[i%channels]
+		consumer := fmt.Sprintf("%s-%d", prefix, i)
+
+		// Qos with global=false only applies to consumers started afterwards on the channel.
+		if err := ch.Qos(prefetch, 0, false); err != nil {
+			_ = group.Stop()
+			return nil, err
+		}
+		msgs, err := amqp.metrics.consume(ch, amqp.options.Timeouts, options.QueueName, consumer, autoAck, options.Exclusive, false, false, args)
+		if err != nil {
+			_ = group.Stop()
+			return nil, err
+		}
+		group.consumers[i] = consumer
+
+		tags := options.tags(consumer)
+
+		var inflight *inflightTracker
+		if !autoAck {
+			inflight = amqp.trackInflight(consumer, options.QueueName)
+		}
+
+		group.wg.Add(1)
+		watchdog := amqp.watchStalls(options.StallThresholdMs, options.QueueName, consumer)
+		go func(index int, msgs <-chan amqpDriver.Delivery, inflight *inflightTracker) {
+			defer group.wg.Done()
+			defer amqp.untrackInflight(inflight)
+			defer watchdog.stop()
+			for {
+				var d amqpDriver.Delivery
+				select {
+				case delivery, ok := <-msgs:
+					if !ok {
+						return
+					}
+					d = delivery
+				case <-amqp.vu.Context().Done():
+					return
+				}
+				watchdog.received()
+				if failoverMs, ok := group.activated(consumer); ok {
+					amqp.metrics.push(amqp.metrics.SACFailoverDuration, failoverMs, tags)
+				}
+				if group.drainExpired() {
+					// Left unsettled, the broker requeues the delivery once the channel is closed.
+					atomic.AddInt64(&group.unprocessed, 1)
+					continue
+				}
+				inflight.add(d.DeliveryTag)
+				if amqp.checkPoison(poison, d, tags) && options.PoisonReject {
+					_ = amqp.profile.reject(d, false)
+					inflight.settle(d.DeliveryTag, false)
+					poison.settled(d)
+					continue
+				}
+				if !group.pacer.wait(amqp.vu.Context()) {
+					return
+				}
+				started := time.Now()
+				if schema != nil {
+					if body, err := amqp.decodeBody(d, "", false); err == nil {
+						amqp.checkSchema(schema, body, tags)
+					}
+				}
+				if delay > 0 && !group.pause(amqp.vu.Context(), delay) {
+					if amqp.vu.Context().Err() != nil {
+						return
+					}
+					atomic.AddInt64(&group.unprocessed, 1)
+					continue
+				}
+				switch {
+				case options.AckStrategy == "auto":
+				case options.AckStrategy == "nack":
+					_ = amqp.profile.reject(d, true)
+				case options.AckStrategy == "reject":
+					_ = d.Reject(false)
+					poison.settled(d)
+				case options.NackRate > 0 && rand.Float64() < options.NackRate: //nolint:gosec
+					_ = amqp.profile.reject(d, true)
+					amqp.metrics.push(amqp.metrics.MessagesNacked, 1, tags)
+				default:
+					_ = d.Ack(false)
+					poison.settled(d)
+				}
+				inflight.settle(d.DeliveryTag, false)
+				amqp.metrics.push(amqp.metrics.ConsumeDuration, sinceMs(started), tags)
+				atomic.AddInt64(&group.consumed[index], 1)
+				amqp.countConsumed(d, tags)
+			}
+		}(i, msgs, inflight)
+	}
+	return group, nil
+}
+
+// tags returns the tags of the consume metrics of the group, scoped to a consumer unless empty.
+func (options ConsumersOptions) tags(consumer string) map[string]string {
+	tags := consumeTags(options.QueueName, "")
+	for k, v := range options.Tags {
+		tags[k] = v
+	}
+	if consumer != "" {
+		tags["consumer"] = consumer
+	}
+	return tags
+}
+
+// Consumers returns the consumer tags of the group.
+func (group *ConsumerGroup) Consumers() []string {
+	return group.consumers
+}
+
+// Consumed returns the number of deliveries processed by each consumer of the group.
+func (group *ConsumerGroup) Consumed() []int64 {
+	consumed := make([]int64, len(group.consumed))
+	for i := range group.consumed {
+		consumed[i] = atomic.LoadInt64(&group.consumed[i])
+	}
+	return consumed
+}
+
+// Cancelled returns the tags of the consumers cancelled by the broker, e.g. because of the consumer timeout.
+// Consumers lost with a channel closed by the broker are reported with the consumers of the channel.
+func (group *ConsumerGroup) Cancelled() []string {
+	group.cancelMu.Lock()
+	defer group.cancelMu.Unlock()
+	return append([]string{}, group.cancelled...)
+}
+
+// kicked records a consumer cancelled by the broker.
+func (group *ConsumerGroup) kicked(consumer string) {
+	group.cancelMu.Lock()
+	defer group.cancelMu.Unlock()
+	group.cancelled = append(group.cancelled, consumer)
+	group.deactivated(consumer)
+}
+
+// Drain stops the consumers from receiving new deliveries (basic.cancel) and lets them process the
+// deliveries already buffered for up to timeoutMs, modeling a graceful consumer shutdown. The channels
+// are closed afterwards and the number of deliveries left unprocessed, requeued by the broker unless
+// acknowledged automatically, is returned.
+func (group *ConsumerGroup) Drain(timeoutMs int) (settled int64, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	for i, consumer := range group.consumers {
+		ch := group.channels[i%len(group.channels)]
+		if cancelErr := ch.Cancel(consumer, false); cancelErr != nil && err == nil {
+			err = cancelErr
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		group.wg.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		group.expireOnce.Do(func() {
+			close(group.expired)
+		})
+		<-drained
+	}
+
+	if stopErr := group.Stop(); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return atomic.LoadInt64(&group.unprocessed), err
+}
+
+// drainExpired tells whether the consumers must stop processing deliveries after a drain timed out.
+func (group *ConsumerGroup) drainExpired() bool {
+	select {
+	case <-group.expired:
+		return true
+	default:
+		return false
+	}
+}
+
+// pause waits for the simulated processing time, false if the VU is done or a drain timed out meanwhile.
+func (group *ConsumerGroup) pause(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-group.expired:
+		return false
+	}
+}
+
+// stopOnDone stops the group once the iteration is over, the channels of a shared connection outliving it.
+func (group *ConsumerGroup) stopOnDone(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = group.Stop()
+		case <-group.stopped:
+		}
+	}()
+}
+
+// Stop closes the pooled channels and waits for the consumers to finish their current delivery.
+func (group *ConsumerGroup) Stop() (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	group.stopOnce.Do(func() {
+		for _, ch := range group.channels {
+			if closeErr := ch.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		group.wg.Wait()
+		group.pacer.stop()
+		close(group.stopped)
+	})
+	return err
+}
@@ -0,0 +1,34 @@
+package amqp
+
+import (
+	"context"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// closeOnDone closes the connection once the VU context is done (test aborted or scenario over),
+// which stops every consumer, publish and wait relying on it.
+func closeOnDone(ctx context.Context, conn *amqpDriver.Connection) {
+	closed := conn.NotifyClose(make(chan *amqpDriver.Error, 1))
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-closed:
+		}
+	}()
+}
+
+// sleepContext pauses for the given duration or until the context is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package amqp
+
+import "errors"
+
+// correlate fills the correlation id of a message, copied from the message replied to or generated with
+// AutoCorrelationId, and routes a reply without a destination to the reply-to queue of the request.
+func (options PublishOptions) correlate() (PublishOptions, error) {
+	if request := options.InReplyTo; request != nil {
+		if options.CorrelationId == "" {
+			options.CorrelationId = request.CorrelationId
+		}
+		if options.Exchange == "" && options.RoutingKey == "" && options.QueueName == "" {
+			if request.ReplyTo == "" {
+				return options, errors.New("no destination for the reply, the request has no reply_to")
+			}
+			options.RoutingKey = request.ReplyTo
+		}
+	}
+	if options.AutoCorrelationId && options.CorrelationId == "" {
+		options.CorrelationId = newUUID()
+	}
+	return options, nil
+}
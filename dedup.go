@@ -0,0 +1,58 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// dedupWindow remembers message identities seen within a sliding time window.
+// It is not safe for concurrent use.
+type dedupWindow struct {
+	window time.Duration
+	header string
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newDedupWindow(window time.Duration, header string) *dedupWindow {
+	return &dedupWindow{
+		window: window,
+		header: header,
+		seen:   make(map[string]time.Time),
+		pruned: time.Now(),
+	}
+}
+
+// duplicate reports whether a delivery with the same identity was seen within the window and records it.
+// Deliveries without an identity are never considered duplicates.
+func (w *dedupWindow) duplicate(d amqpDriver.Delivery) bool {
+	id := d.MessageId
+	if w.header != "" {
+		value, ok := d.Headers[w.header]
+		if !ok {
+			return false
+		}
+		id = fmt.Sprint(value)
+	}
+	if id == "" {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(w.pruned) > w.window {
+		for k, seenAt := range w.seen {
+			if now.Sub(seenAt) > w.window {
+				delete(w.seen, k)
+			}
+		}
+		w.pruned = now
+	}
+
+	if seenAt, ok := w.seen[id]; ok && now.Sub(seenAt) <= w.window {
+		return true
+	}
+	w.seen[id] = now
+	return false
+}
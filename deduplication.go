@@ -0,0 +1,105 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// deduplicationKind is the exchange type of the message deduplication plugin.
+const deduplicationKind = "x-message-deduplication"
+
+// Headers read by the message deduplication plugin.
+const (
+	headerDeduplication    = "x-deduplication-header"
+	headerDeduplicationTTL = "x-cache-ttl"
+)
+
+// deduplicationDetectWindow is how long the deduplication ids consumed are remembered to detect duplicates.
+const deduplicationDetectWindow = 5 * time.Minute
+
+// deduplicationExchangeArgs returns the cache arguments of an x-message-deduplication exchange.
+func deduplicationExchangeArgs(args amqpDriver.Table, kind string, options ExchangeDeclareOptions) (amqpDriver.Table, error) {
+	if kind != deduplicationKind {
+		if options.DedupCacheSize != 0 || options.DedupCacheTTLMs != 0 || options.DedupPersistence != "" {
+			return nil, fmt.Errorf("the deduplication cache options only apply to %s exchanges", deduplicationKind)
+		}
+		return args, nil
+	}
+	if options.DedupCacheSize <= 0 {
+		return nil, fmt.Errorf("%s exchanges need a positive dedup_cache_size", deduplicationKind)
+	}
+	args = withField(args, "x-cache-size", int64(options.DedupCacheSize))
+	if options.DedupCacheTTLMs < 0 {
+		return nil, errors.New("the deduplication cache TTL must not be negative")
+	}
+	if options.DedupCacheTTLMs > 0 {
+		args = withField(args, "x-cache-ttl", int64(options.DedupCacheTTLMs))
+	}
+	switch options.DedupPersistence {
+	case "":
+	case "memory", "disk":
+		args = withField(args, "x-cache-persistence", options.DedupPersistence)
+	default:
+		return nil, fmt.Errorf("unknown deduplication cache persistence %q, expected memory or disk", options.DedupPersistence)
+	}
+	return args, nil
+}
+
+// stampDeduplication sets the headers of a message published with a deduplication id.
+func (options PublishOptions) stampDeduplication() (PublishOptions, error) {
+	if options.DeduplicationTTLMs < 0 {
+		return options, errors.New("the deduplication TTL must not be negative")
+	}
+	if options.DeduplicationId == "" {
+		if options.DeduplicationTTLMs > 0 {
+			return options, errors.New("deduplication_ttl_ms needs a deduplication_id")
+		}
+		return options, nil
+	}
+	options.Headers = withField(options.Headers, headerDeduplication, options.DeduplicationId)
+	if options.DeduplicationTTLMs > 0 {
+		options.Headers = withField(options.Headers, headerDeduplicationTTL, options.DeduplicationTTLMs)
+	}
+	return options, nil
+}
+
+// duplicateDetector remembers the deduplication ids consumed from each queue by all the VUs, so that the
+// duplicates let through by the deduplication plugin are counted whichever VU consumes them.
+type duplicateDetector struct {
+	mu      sync.Mutex
+	windows map[string]*dedupWindow // by queue
+}
+
+// duplicate reports whether a delivery with the same deduplication id was already consumed from the queue,
+// false for messages without one and for redeliveries, which the broker returns on purpose. The same id
+// consumed from several queues, e.g. bound to a fanout exchange, is not a duplicate either.
+func (detector *duplicateDetector) duplicate(queue string, d amqpDriver.Delivery) bool {
+	if _, ok := d.Headers[headerDeduplication]; !ok || d.Redelivered {
+		return false
+	}
+
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	if detector.windows == nil {
+		detector.windows = make(map[string]*dedupWindow)
+	}
+	window, ok := detector.windows[queue]
+	if !ok {
+		window = newDedupWindow(deduplicationDetectWindow, headerDeduplication)
+		detector.windows[queue] = window
+	}
+	return window.duplicate(d)
+}
+
+// detectDuplicate counts a delivery whose deduplication id was consumed from the same queue within the last
+// 5 minutes as amqp_duplicates_detected. A deduplicating queue only drops the duplicates of the messages it
+// holds, and an exchange those within the TTL of its cache, so the count is only an error within those bounds.
+func (amqp *AMQP) detectDuplicate(d amqpDriver.Delivery, tags map[string]string) {
+	if amqp.duplicates.duplicate(tags["queue"], d) {
+		amqp.metrics.push(amqp.metrics.DuplicatesDetected, 1, tags)
+	}
+}
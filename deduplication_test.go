@@ -0,0 +1,35 @@
+package amqp
+
+import (
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDuplicateDetector(t *testing.T) {
+	t.Parallel()
+
+	withID := func(id string, redelivered bool) amqpDriver.Delivery {
+		return amqpDriver.Delivery{Headers: amqpDriver.Table{headerDeduplication: id}, Redelivered: redelivered}
+	}
+	var detector duplicateDetector
+	steps := []struct {
+		name      string
+		queue     string
+		delivery  amqpDriver.Delivery
+		duplicate bool
+	}{
+		{"first", "orders", withID("1", false), false},
+		{"without id", "orders", amqpDriver.Delivery{MessageId: "1"}, false},
+		{"redelivery", "orders", withID("1", true), false},
+		{"other queue", "audit", withID("1", false), false},
+		{"other id", "orders", withID("2", false), false},
+		{"duplicate", "orders", withID("1", false), true},
+		{"duplicate on the other queue", "audit", withID("1", false), true},
+	}
+	for _, step := range steps {
+		if duplicate := detector.duplicate(step.queue, step.delivery); duplicate != step.duplicate {
+			t.Errorf("%s: duplicate = %v, expected %v", step.name, duplicate, step.duplicate)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package amqp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// TLSOptions defines the TLS settings used for amqps:// connections, an error with amqp:// ones.
+type TLSOptions struct {
+	CaCertFile         string // PEM encoded CA certificates used to verify the broker
+	CertFile           string // PEM encoded client certificate, required for EXTERNAL auth
+	KeyFile            string // PEM encoded client private key
+	ServerName         string // overrides the host name used to verify the broker certificate
+	InsecureSkipVerify bool
+	MinValidityDays    int // fail to start if a broker certificate expires within this number of days
+}
+
+// SASL mechanisms supported by the AuthMechanism option.
+const (
+	authPlain    = "PLAIN"
+	authAMQPlain = "AMQPLAIN"
+	authExternal = "EXTERNAL"
+)
+
+const (
+	defaultHeartbeat         = 10 * time.Second
+	defaultLocale            = "en_US"
+	defaultConnectionTimeout = 30 * time.Second
+	defaultProduct           = "xk6-amqp"
+	defaultPlatform          = "Golang"
+)
+
+// dial opens a connection to the broker given the session options. The IP address of a node, if any, is
+// connected to instead of the host of the URL, which is still used for TLS. The TCP connection attempt is
+// abandoned once the context is done.
+func dial(ctx context.Context, options Options, node string) (*amqpDriver.Connection, error) {
+	config, err := dialConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	config.Dial = func(network, addr string) (net.Conn, error) {
+		if node != "" {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			addr = net.JoinHostPort(node, port)
+		}
+		dialer := net.Dialer{Timeout: defaultConnectionTimeout}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// Handshakes are bounded by a deadline, which the driver clears once the connection is open.
+		if err = conn.SetDeadline(time.Now().Add(defaultConnectionTimeout)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return amqpDriver.DialConfig(options.ConnectionURL, config)
+}
+
+// Orders in which the connection URLs are tried.
+const (
+	connectionOrdered = "ordered"
+	connectionRandom  = "random"
+)
+
+func checkConnectionOrder(order string) error {
+	switch order {
+	case "", connectionOrdered, connectionRandom:
+		return nil
+	}
+	return fmt.Errorf("unknown connection order %q, expected ordered or random", order)
+}
+
+// connectionURLs returns the URLs to try for a new connection, ConnectionURL first.
+func (options Options) connectionURLs() []string {
+	urls := make([]string, 0, len(options.ConnectionURLs)+1)
+	if options.ConnectionURL != "" || len(options.ConnectionURLs) == 0 {
+		urls = append(urls, options.ConnectionURL)
+	}
+	urls = append(urls, options.ConnectionURLs...)
+	if options.ConnectionOrder == connectionRandom {
+		rand.Shuffle(len(urls), func(i, j int) { //nolint:gosec
+			urls[i], urls[j] = urls[j], urls[i]
+		})
+	}
+	return urls
+}
+
+// brokerAddress returns the host, port and virtual host of a connection URL, leaving out the credentials.
+func brokerAddress(connectionURL string) string {
+	uri, err := amqpDriver.ParseURI(connectionURL)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d/%s", uri.Host, uri.Port, uri.Vhost)
+}
+
+// dialConfig builds the connection configuration for the session options.
+func dialConfig(options Options) (amqpDriver.Config, error) {
+	uri, err := amqpDriver.ParseURI(options.ConnectionURL)
+	if err != nil {
+		return amqpDriver.Config{}, err
+	}
+
+	config := amqpDriver.Config{
+		Heartbeat: defaultHeartbeat,
+		Locale:    defaultLocale,
+	}
+	if options.Locale != "" {
+		config.Locale = options.Locale
+	}
+	if config.Properties, err = clientProperties(options.ClientProperties); err != nil {
+		return config, err
+	}
+
+	username, password := uri.Username, uri.Password
+	if options.Username != "" {
+		username = options.Username
+	}
+	if options.Password != "" {
+		password = options.Password
+	}
+
+	switch strings.ToUpper(options.AuthMechanism) {
+	case "", authPlain:
+		config.SASL = []amqpDriver.Authentication{&amqpDriver.PlainAuth{Username: username, Password: password}}
+	case authAMQPlain:
+		config.SASL = []amqpDriver.Authentication{&amqpDriver.AMQPlainAuth{Username: username, Password: password}}
+	case authExternal:
+		if options.TLS.CertFile == "" {
+			return config, errors.New("EXTERNAL auth requires a client certificate, set tls.cert_file and tls.key_file")
+		}
+		config.SASL = []amqpDriver.Authentication{&amqpDriver.ExternalAuth{}}
+	default:
+		return config, fmt.Errorf("unsupported auth mechanism %q, expected one of PLAIN, AMQPLAIN, EXTERNAL", options.AuthMechanism)
+	}
+
+	if config.TLSClientConfig, err = tlsConfig(options.TLS); err != nil {
+		return config, err
+	}
+	// The driver only encrypts amqps:// connections, the TLS options would silently go unused otherwise.
+	if uri.Scheme != "amqps" && (config.TLSClientConfig != nil || options.TLS.MinValidityDays > 0) {
+		return config, fmt.Errorf("the tls options need an amqps:// connection url, %s is not encrypted", brokerAddress(options.ConnectionURL))
+	}
+	return config, nil
+}
+
+// clientProperties returns the properties advertised in the handshake, overrides taking precedence over
+// the defaults. The driver always advertises its own capabilities, which cannot be overridden.
+func clientProperties(overrides amqpDriver.Table) (amqpDriver.Table, error) {
+	coerced, err := coerceTable(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("client properties: %w", err)
+	}
+	properties := amqpDriver.Table{
+		"product":  defaultProduct,
+		"version":  version,
+		"platform": defaultPlatform,
+	}
+	for k, v := range coerced {
+		properties[k] = v
+	}
+	return properties, nil
+}
+
+// tlsConfig builds a tls.Config from the options, nil if none are set so the URL settings apply.
+func tlsConfig(options TLSOptions) (*tls.Config, error) {
+	if options == (TLSOptions{MinValidityDays: options.MinValidityDays}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify, //nolint:gosec
+	}
+	if options.CaCertFile != "" {
+		pem, err := os.ReadFile(options.CaCertFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", options.CaCertFile)
+		}
+	}
+	if options.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
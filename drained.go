@@ -0,0 +1,95 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+)
+
+// DrainedOptions defines the queues expected to be drained at the end of a test.
+type DrainedOptions struct {
+	Queues      []string
+	MaxMessages int            // ready messages tolerated in every queue, 0 by default
+	Thresholds  map[string]int // ready messages tolerated by queue name, overriding MaxMessages
+	TimeoutMs   int            // how long the queues are given to drain, all together
+}
+
+// DrainedQueue reports the messages left in a queue.
+type DrainedQueue struct {
+	Name        string
+	Messages    int
+	MaxMessages int
+	Drained     bool
+	Error       string // set if the queue could not be inspected, e.g. because it does not exist
+}
+
+// DrainedReport tells whether every queue drained below its threshold in time.
+type DrainedReport struct {
+	Drained bool
+	Queues  []DrainedQueue
+}
+
+// VerifyDrained waits, in parallel, for every queue to hold no more ready messages than its threshold and
+// reports the messages left once they all did or the timeout expired. The leftovers are emitted as the
+// amqp_queue_leftover gauge tagged with the queue name.
+func (queue *Queue) VerifyDrained(options DrainedOptions) (report DrainedReport, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	deadline := time.Now().Add(time.Duration(options.TimeoutMs) * time.Millisecond)
+	report = DrainedReport{Drained: true, Queues: make([]DrainedQueue, len(options.Queues))}
+
+	var wg sync.WaitGroup
+	for i, name := range options.Queues {
+		threshold := options.MaxMessages
+		if t, ok := options.Thresholds[name]; ok {
+			threshold = t
+		}
+		report.Queues[i] = DrainedQueue{Name: name, MaxMessages: threshold}
+
+		wg.Add(1)
+		go func(drained *DrainedQueue) {
+			defer wg.Done()
+			queue.waitDrained(drained, deadline)
+		}(&report.Queues[i])
+	}
+	wg.Wait()
+
+	if err := queue.vu.Context().Err(); err != nil {
+		return report, err
+	}
+	for _, drained := range report.Queues {
+		if drained.Error == "" {
+			queue.metrics.push(queue.metrics.QueueLeftover, float64(drained.Messages), map[string]string{"queue": drained.Name})
+		}
+		report.Drained = report.Drained && drained.Drained
+	}
+	return report, nil
+}
+
+// waitDrained polls a queue on its own channel until it drained below its threshold or the deadline.
+func (queue *Queue) waitDrained(drained *DrainedQueue, deadline time.Time) {
+	ch, err := queue.channel()
+	if err != nil {
+		drained.Error = err.Error()
+		return
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	for {
+		q, err := ch.QueueInspect(drained.Name)
+		if err != nil {
+			drained.Error = structuredError(err).Error()
+			return
+		}
+		drained.Messages = q.Messages
+		if drained.Drained = q.Messages <= drained.MaxMessages; drained.Drained || !time.Now().Before(deadline) {
+			return
+		}
+		if sleepContext(queue.vu.Context(), waitForMessagesInterval) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Behaviours of a declare finding the queue or exchange already exists with different properties.
+const (
+	driftFail     = ""         // return the PRECONDITION_FAILED error of the broker
+	driftReport   = "report"   // keep the existing entity and report the mismatch
+	driftRecreate = "recreate" // delete the existing entity and declare it again
+)
+
+func checkDriftMode(mode string) error {
+	switch mode {
+	case driftFail, driftReport, driftRecreate:
+		return nil
+	default:
+		return fmt.Errorf("unknown drift mode %q, expected report or recreate", mode)
+	}
+}
+
+// isDrift tells whether a declare failed because the entity exists with different durability or arguments.
+func isDrift(err error) bool {
+	var amqpErr *amqpDriver.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqpDriver.PreconditionFailed
+}
+
+// reportDrift counts a topology mismatch with the amqp_topology_drift metric and logs the broker explanation.
+func (m *amqpMetrics) reportDrift(kind string, name string, err error) {
+	m.push(m.TopologyDrift, 1, map[string]string{"kind": kind, "name": name})
+	if state := m.vu.State(); state != nil {
+		state.Logger.WithError(err).Warnf("%s %q already exists with different properties", kind, name)
+	}
+}
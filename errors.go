@@ -0,0 +1,71 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Error is a protocol error returned by the broker or the client library. Thrown to scripts as the value
+// of the exception, e.g. e.value.name is "NOT_FOUND" when declaring a binding to a missing queue.
+type Error struct {
+	Code        int    // AMQP reply code, e.g. 404 or 403
+	Name        string // AMQP reply name, e.g. NOT_FOUND or ACCESS_REFUSED
+	Reason      string
+	Server      bool // raised by the broker rather than the client library
+	Recoverable bool // the connection is still usable, only the channel was closed
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Name, e.Code, e.Reason)
+}
+
+// replyNames maps the AMQP 0-9-1 reply codes to their names.
+var replyNames = map[int]string{ //nolint:gochecknoglobals
+	amqpDriver.ContentTooLarge:    "CONTENT_TOO_LARGE",
+	amqpDriver.NoRoute:            "NO_ROUTE",
+	amqpDriver.NoConsumers:        "NO_CONSUMERS",
+	amqpDriver.ConnectionForced:   "CONNECTION_FORCED",
+	amqpDriver.InvalidPath:        "INVALID_PATH",
+	amqpDriver.AccessRefused:      "ACCESS_REFUSED",
+	amqpDriver.NotFound:           "NOT_FOUND",
+	amqpDriver.ResourceLocked:     "RESOURCE_LOCKED",
+	amqpDriver.PreconditionFailed: "PRECONDITION_FAILED",
+	amqpDriver.FrameError:         "FRAME_ERROR",
+	amqpDriver.SyntaxError:        "SYNTAX_ERROR",
+	amqpDriver.CommandInvalid:     "COMMAND_INVALID",
+	amqpDriver.ChannelError:       "CHANNEL_ERROR",
+	amqpDriver.UnexpectedFrame:    "UNEXPECTED_FRAME",
+	amqpDriver.ResourceError:      "RESOURCE_ERROR",
+	amqpDriver.NotAllowed:         "NOT_ALLOWED",
+	amqpDriver.NotImplemented:     "NOT_IMPLEMENTED",
+	amqpDriver.InternalError:      "INTERNAL_ERROR",
+}
+
+// structuredError converts errors of the client library to Error, other errors are returned unchanged.
+func structuredError(err error) error {
+	var amqpErr *amqpDriver.Error
+	if !errors.As(err, &amqpErr) {
+		return err
+	}
+
+	name, ok := replyNames[amqpErr.Code]
+	if !ok {
+		name = "UNKNOWN"
+	}
+	return &Error{
+		Code:        amqpErr.Code,
+		Name:        name,
+		Reason:      amqpErr.Reason,
+		Server:      amqpErr.Server,
+		Recoverable: amqpErr.Recover,
+	}
+}
+
+// isNotFound tells whether an operation failed because the queue or exchange does not exist.
+func isNotFound(err error) bool {
+	var amqpErr *amqpDriver.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqpDriver.NotFound
+}
@@ -0,0 +1,27 @@
+package amqp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQueueHelpersReturnStructuredErrors(t *testing.T) {
+	t.Parallel()
+
+	_, amqp := newLoopbackRuntime(t)
+	for name, call := range map[string]func() error{
+		"partitionLag": func() error {
+			_, err := amqp.Queue.PartitionLag([]string{"structured-missing"})
+			return err
+		},
+		"waitForMessages": func() error {
+			_, err := amqp.Queue.WaitForMessages("structured-missing", 1, 1)
+			return err
+		},
+	} {
+		var amqpErr *Error
+		if err := call(); !errors.As(err, &amqpErr) || amqpErr.Name != "NOT_FOUND" {
+			t.Errorf("%s: got %#v, want a NOT_FOUND error", name, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modules"
+)
+
+// eventLogger is the EventLog value sending the events to the k6 logger instead of a file.
+const eventLogger = "logger"
+
+// eventLog records operational events (connect, disconnect, reconnect, declare, error, alarm) as JSON,
+// either as lines appended to a file shared by the VUs or through the k6 logger.
+// A nil log records nothing, which is the case unless the EventLog option is set.
+type eventLog struct {
+	vu   modules.VU
+	file *eventFile // nil when events go to the k6 logger
+}
+
+// eventFile is a JSON lines file written by all the VUs.
+type eventFile struct {
+	path  string
+	users int // sessions whose iteration is not over, guarded by eventFiles
+
+	mu   sync.Mutex
+	file *os.File // nil once closed
+}
+
+// eventFiles opens each event log file once for all the VUs of the test run, and closes it once the
+// iterations using it are over.
+type eventFiles struct {
+	mu    sync.Mutex
+	files map[string]*eventFile
+}
+
+// open returns the event log file at path, used until ctx is done.
+func (files *eventFiles) open(ctx context.Context, path string) (*eventFile, error) {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	f, ok := files.files[path]
+	if !ok {
+		file, err := openEventFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if files.files == nil {
+			files.files = make(map[string]*eventFile)
+		}
+		f = &eventFile{path: path, file: file}
+		files.files[path] = f
+	}
+	f.users++
+	go func() {
+		<-ctx.Done()
+		files.release(f)
+	}()
+	return f, nil
+}
+
+// release closes the file once the last iteration using it is over.
+func (files *eventFiles) release(f *eventFile) {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	f.users--
+	if f.users > 0 {
+		return
+	}
+	delete(files.files, f.path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = f.file.Close()
+	f.file = nil
+}
+
+func openEventFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644) //nolint:gosec
+}
+
+// write appends a line to the file, reopening it for the line if already closed, as for the disconnections
+// recorded as the iterations end.
+func (f *eventFile) write(line []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		_, _ = f.file.Write(line)
+		return
+	}
+	file, err := openEventFile(f.path)
+	if err != nil {
+		return
+	}
+	_, _ = file.Write(line)
+	_ = file.Close()
+}
+
+// newEventLog returns the event log writing to the destination of the EventLog option.
+func (amqp *AMQP) newEventLog(destination string) (*eventLog, error) {
+	if destination == "" {
+		return nil, nil
+	}
+	if destination == eventLogger {
+		return &eventLog{vu: amqp.vu}, nil
+	}
+	file, err := amqp.eventFiles.open(amqp.vu.Context(), destination)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{vu: amqp.vu, file: file}, nil
+}
+
+// emit records an event with its fields.
+func (l *eventLog) emit(event string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+
+	record := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if v == nil {
+			continue
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		record[k] = v
+	}
+	state := l.vu.State()
+	if l.file == nil {
+		if state != nil {
+			state.Logger.WithField("amqp_event", event).WithFields(record).Info("AMQP " + event)
+		}
+		return
+	}
+
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	record["event"] = event
+	if state != nil {
+		record["vu"] = state.VUID
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	l.file.write(append(line, '\n'))
+}
+
+// watchConnection records the disconnection of a connection and the alarms blocking its publishers.
+func (l *eventLog) watchConnection(conn *amqpDriver.Connection) {
+	if l == nil {
+		return
+	}
+	closes := conn.NotifyClose(make(chan *amqpDriver.Error, 1))
+	blocks := conn.NotifyBlocked(make(chan amqpDriver.Blocking, 1))
+	go func() {
+		for {
+			select {
+			case closeErr := <-closes:
+				fields := map[string]interface{}{"server": false}
+				if closeErr != nil {
+					fields = map[string]interface{}{"server": closeErr.Server, "code": closeErr.Code, "reason": closeErr.Reason}
+				}
+				l.emit("disconnect", fields)
+				return
+			case blocking, ok := <-blocks:
+				if !ok {
+					blocks = nil
+					continue
+				}
+				l.emit("alarm", map[string]interface{}{"active": blocking.Active, "reason": blocking.Reason})
+			}
+		}
+	}()
+}
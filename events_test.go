@@ -0,0 +1,61 @@
+package amqp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventFilesClosedOnceUnused(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	var files eventFiles
+	first, endFirst := context.WithCancel(context.Background())
+	second, endSecond := context.WithCancel(context.Background())
+	defer endSecond()
+
+	f, err := files.open(first, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := files.open(second, path); err != nil || shared != f {
+		t.Fatalf("the file is not shared: %v", err)
+	}
+	closed := func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.file == nil
+	}
+
+	endFirst()
+	f.write([]byte("a\n"))
+	if closed() {
+		t.Fatal("the file was closed while in use")
+	}
+	endSecond()
+	if !eventually(closed) {
+		t.Fatal("the file was not closed once unused")
+	}
+	// A disconnection recorded as the iteration ends is still written.
+	f.write([]byte("b\n"))
+
+	third, endThird := context.WithCancel(context.Background())
+	defer endThird()
+	reopened, err := files.open(third, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened == f {
+		t.Error("the closed file was reused")
+	}
+	reopened.write([]byte("c\n"))
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\nc\n" {
+		t.Errorf("wrote %q", content)
+	}
+}
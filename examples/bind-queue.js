@@ -15,7 +15,9 @@ export default function () {
     routing_key: '',
     exchange_name: exchangeName,
     no_wait: false,
-    args: null
+    args: null,
+    // headers_match: 'any', // for headers exchanges: 'all' (default) or 'any'
+    // headers: { 'x-tenant': 'k6' },
   })
 
   console.log(queueName + " queue binded to " + exchangeName)
@@ -13,7 +13,7 @@ export default function () {
 
   Exchange.declare({
     name: exchangeName,
-  	kind: 'direct',
+  	kind: Exchange.kinds.direct, // direct, fanout, topic, headers, delayed_message or consistent_hash
     durable: false,
     auto_delete: false,
     internal: false,
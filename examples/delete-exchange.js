@@ -9,7 +9,10 @@ export default function () {
   
   const exchangeName = 'K6 exchange'
 
-  Exchange.delete(exchangeName)
+  Exchange.delete(exchangeName, {
+    // if_unused: false, // fail with PRECONDITION_FAILED if the exchange still has bindings
+    // no_wait: false,
+  })
 
   console.log(exchangeName + " exchange deleted")
 }
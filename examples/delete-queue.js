@@ -9,7 +9,11 @@ export default function () {
   
   const queueName = 'K6 queue'
   
-  Queue.delete(queueName)
+  // reset the queue between scenarios without dropping the rest of the topology
+  Queue.purge(queueName, false)
 
-  console.log(queueName + " queue deleted")
+  // fails, leaving the queue in place, if it still has consumers or ready messages
+  const count = Queue.delete(queueName, { if_unused: true, if_empty: true })
+
+  console.log(queueName + " queue deleted with " + count + " messages")
 }
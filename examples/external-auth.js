@@ -0,0 +1,25 @@
+import Amqp from 'k6/x/amqp';
+
+export default function () {
+  // client certificate authentication with the rabbitmq_auth_mechanism_ssl plugin
+  Amqp.start({
+    connection_url: "amqps://localhost:5671/",
+    auth_mechanism: 'EXTERNAL', // PLAIN (default), AMQPLAIN or EXTERNAL
+    tls: {
+      ca_cert_file: './certs/ca_certificate.pem',
+      cert_file: './certs/client_certificate.pem',
+      key_file: './certs/client_key.pem',
+      // server_name: 'rabbitmq.local',
+      // insecure_skip_verify: false,
+    },
+  })
+
+  // credentials may also be supplied separately from the URL
+  // Amqp.start({
+  //   connection_url: "amqp://localhost:5672/",
+  //   username: __ENV.AMQP_USER,
+  //   password: __ENV.AMQP_PASSWORD,
+  // })
+
+  console.log("Connection opened with EXTERNAL auth")
+}
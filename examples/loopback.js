@@ -0,0 +1,21 @@
+import Amqp from 'k6/x/amqp';
+import Queue from 'k6/x/amqp/queue';
+import Exchange from 'k6/x/amqp/exchange';
+import { check } from 'k6';
+
+export default function () {
+  // no broker needed, e.g. to check a script in CI
+  Amqp.start({ loopback: true })
+
+  const capabilities = Amqp.serverProperties().capabilities
+  console.log('dead lettering simulated: ' + capabilities.dead_lettering)
+
+  Exchange.declare({ name: 'K6 orders', kind: Exchange.kinds.topic })
+  Queue.declare({ name: 'K6 eu orders' })
+  Queue.bind({ queue_name: 'K6 eu orders', exchange_name: 'K6 orders', routing_key: 'eu.*' })
+
+  Amqp.publish({ exchange: 'K6 orders', routing_key: 'eu.paris', body: 'order', confirm: true })
+
+  const body = Amqp.get({ queue_name: 'K6 eu orders', waiting_timeout_sec: 1 })
+  check(body, { 'order routed': (b) => b === 'order' })
+}
@@ -0,0 +1,38 @@
+import Management from 'k6/x/amqp/management';
+
+export function setup() {
+  Management.start({
+    url: 'http://localhost:15672',
+    username: 'guest',
+    password: 'guest',
+    // timeout_sec: 10,
+    // vhost: '/', // virtual host of the policies
+  })
+
+  // definition keys as with rabbitmqctl set_policy, e.g. max-length, dead-letter-exchange or ha-mode
+  Management.setPolicy('k6-ttl', '^K6 ', { message_ttl: 60000, 'queue-mode': 'lazy' }, 'queues', 1)
+}
+
+export default function () {
+  Management.start({
+    url: 'http://localhost:15672',
+    username: 'guest',
+    password: 'guest',
+  })
+
+  Management.queues('/').forEach(function (queue) {
+    console.log(queue.name + ": " + queue.messages + " messages")
+  })
+  console.log("node count: " + Management.nodes().length)
+  console.log("open connections: " + Management.connections().length)
+  console.log("churn rates: " + JSON.stringify(Management.churnRates()))
+}
+
+export function teardown() {
+  Management.start({
+    url: 'http://localhost:15672',
+    username: 'guest',
+    password: 'guest',
+  })
+  Management.deletePolicy('k6-ttl')
+}
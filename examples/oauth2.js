@@ -0,0 +1,18 @@
+import Amqp from 'k6/x/amqp';
+
+export default function () {
+  // the JWT obtained from the token endpoint is used as the password and
+  // refreshed with connection.update-secret before it expires
+  Amqp.start({
+    connection_url: "amqp://localhost:5672/",
+    oauth2: {
+      token_url: 'http://localhost:8080/realms/test/protocol/openid-connect/token',
+      client_id: 'producer',
+      client_secret: __ENV.CLIENT_SECRET,
+      // scope: 'rabbitmq.read:*/* rabbitmq.write:*/*',
+      // refresh_before_sec: 60,
+    },
+  })
+
+  console.log("Connection opened with an OAuth2 token")
+}
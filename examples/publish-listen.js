@@ -40,6 +40,7 @@ export default function () {
   Amqp.listen({
     queue_name: queueName,
     listener: listener,
+    max_messages: 3,
     auto_ack: true,
     consumer: consumerName,
     // exclusive: false,
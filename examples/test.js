@@ -35,6 +35,7 @@ export default function () {
   Amqp.listen({
     queue_name: queueName,
     listener: listener,
+    max_messages: 1,
     auto_ack: true,
     // consumer: '',
     // exclusive: false,
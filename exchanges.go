@@ -1,13 +1,77 @@
 package amqp
 
 import (
+	"fmt"
+	"strings"
+
 	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modules"
+)
+
+// Exchange types provided by the rabbitmq_delayed_message_exchange and rabbitmq_consistent_hash_exchange plugins.
+const (
+	delayedMessageKind = "x-delayed-message"
+	consistentHashKind = "x-consistent-hash"
 )
 
+// ExchangeKinds lists the exchange types accepted by Declare, exposed to scripts as Exchange.kinds.
+type ExchangeKinds struct {
+	Direct         string
+	Fanout         string
+	Topic          string
+	Headers        string
+	DelayedMessage string
+	ConsistentHash string
+	Deduplication  string
+}
+
+//nolint:gochecknoglobals
+var exchangeKinds = ExchangeKinds{
+	Direct:         amqpDriver.ExchangeDirect,
+	Fanout:         amqpDriver.ExchangeFanout,
+	Topic:          amqpDriver.ExchangeTopic,
+	Headers:        amqpDriver.ExchangeHeaders,
+	DelayedMessage: delayedMessageKind,
+	ConsistentHash: consistentHashKind,
+	Deduplication:  deduplicationKind,
+}
+
+// checkExchangeKind fails for exchange types other than the ones of ExchangeKinds, unless not strict and
+// prefixed with x- as the types of plugins are. The routing kind of a delayed message exchange cannot be
+// x-delayed-message itself.
+func checkExchangeKind(kind string, delayed bool, strict bool) error {
+	known := []string{
+		exchangeKinds.Direct, exchangeKinds.Fanout, exchangeKinds.Topic,
+		exchangeKinds.Headers, exchangeKinds.ConsistentHash,
+	}
+	if !delayed {
+		known = append(known, exchangeKinds.DelayedMessage, exchangeKinds.Deduplication)
+	}
+	for _, k := range known {
+		if kind == k {
+			return nil
+		}
+	}
+	if !strict && strings.HasPrefix(kind, "x-") && kind != delayedMessageKind {
+		return nil
+	}
+	if delayed {
+		return fmt.Errorf("unknown delayed exchange type %q, expected one of %s", kind, strings.Join(known, ", "))
+	}
+	return fmt.Errorf("unknown exchange kind %q, expected one of %s", kind, strings.Join(known, ", "))
+}
+
 // Exchange defines a connection to publish/subscribe destinations.
 type Exchange struct {
-	Version    string
-	Connection *amqpDriver.Connection
+	Version string
+	Kinds   ExchangeKinds
+
+	vu         modules.VU
+	connection *sessionConnection
+	metrics    *amqpMetrics
+	tracer     *tracer
+	events     *eventLog
+	timeouts   TimeoutOptions
 }
 
 // ExchangeOptions defines configuration settings for accessing an exchange.
@@ -17,13 +81,29 @@ type ExchangeOptions struct {
 
 // ExchangeDeclareOptions provides options when declaring (creating) an exchange.
 type ExchangeDeclareOptions struct {
-	Name       string
-	Kind       string
-	Durable    bool
-	AutoDelete bool
-	Internal   bool
-	NoWait     bool
-	Args       amqpDriver.Table
+	Name              string
+	Kind              string
+	Durable           bool
+	AutoDelete        bool
+	Internal          bool
+	NoWait            bool
+	Args              amqpDriver.Table
+	DelayedType       string // declares an x-delayed-message exchange routing like this kind (direct, topic, ...)
+	AlternateExchange string // exchange receiving the messages this exchange cannot route
+	HashHeader        string // header hashed by an x-consistent-hash exchange instead of the routing key
+	HashProperty      string // property hashed by an x-consistent-hash exchange: message_id, correlation_id or timestamp
+	DedupCacheSize    int    // ids remembered by an x-message-deduplication exchange, sets x-cache-size
+	DedupCacheTTLMs   int    // how long an x-message-deduplication exchange remembers an id, sets x-cache-ttl
+	DedupPersistence  string // memory or disk, where the ids are kept, sets x-cache-persistence
+	OnDrift           string // report or recreate an existing exchange with different properties instead of failing
+	Passive           bool   // only check the exchange exists, failing with NOT_FOUND otherwise, see Exists
+	StrictKind        bool   // also reject the x- types of plugins not listed in Exchange.kinds, passed to the broker otherwise
+}
+
+// ExchangeDeleteOptions provides the conditions an exchange must meet to be deleted.
+type ExchangeDeleteOptions struct {
+	IfUnused bool // fail instead of deleting an exchange with bindings
+	NoWait   bool
 }
 
 // ExchangeBindOptions provides options when binding (subscribing) one exchange to another.
@@ -33,6 +113,8 @@ type ExchangeBindOptions struct {
 	RoutingKey              string
 	NoWait                  bool
 	Args                    amqpDriver.Table
+	HeadersMatch            string           // x-match mode for headers exchanges: all (default) or any
+	Headers                 amqpDriver.Table // header values to match against when binding to a headers exchange
 }
 
 // ExchangeUnbindOptions provides options when unbinding (unsubscribing) one exchange from another.
@@ -42,31 +124,136 @@ type ExchangeUnbindOptions struct {
 	RoutingKey              string
 	NoWait                  bool
 	Args                    amqpDriver.Table
+	HeadersMatch            string           // x-match mode for headers exchanges: all (default) or any
+	Headers                 amqpDriver.Table // header values of the headers exchange binding to remove
 }
 
 // Declare creates a new exchange given the provided options.
-func (exchange *Exchange) Declare(options ExchangeDeclareOptions) error {
-	ch, err := exchange.Connection.Channel()
+func (exchange *Exchange) Declare(options ExchangeDeclareOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	span := exchange.tracer.start(options.Name+" declare", spanClient, "", map[string]string{
+		"messaging.system":           "rabbitmq",
+		"messaging.destination.name": options.Name,
+	})
+	defer func() {
+		span.end(err)
+		exchange.events.emit("declare", map[string]interface{}{"kind": "exchange", "name": options.Name, "error": err})
+	}()
+
+	if options.Passive {
+		return exchange.declarePassive(options.Name)
+	}
+
+	kind, args := options.Kind, options.Args
+	if options.DelayedType != "" {
+		if err = checkExchangeKind(options.DelayedType, true, options.StrictKind); err != nil {
+			return err
+		}
+		kind = delayedMessageKind
+		args = withField(args, "x-delayed-type", options.DelayedType)
+	}
+	if err = checkExchangeKind(kind, false, options.StrictKind); err != nil {
+		return err
+	}
+	if args, err = consistentHashArgs(args, kind, options.HashHeader, options.HashProperty); err != nil {
+		return err
+	}
+	if args, err = deduplicationExchangeArgs(args, kind, options); err != nil {
+		return err
+	}
+	if options.AlternateExchange != "" {
+		args = withField(args, "alternate-exchange", options.AlternateExchange)
+	}
+
+	if err = checkDriftMode(options.OnDrift); err != nil {
+		return err
+	}
+
+	ch, err := exchange.channel()
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = ch.Close()
 	}()
-	return ch.ExchangeDeclare(
-		options.Name,
-		options.Kind,
-		options.Durable,
-		options.AutoDelete,
-		options.Internal,
-		options.NoWait,
-		options.Args,
-	)
+	declare := func() error {
+		return ch.ExchangeDeclare(
+			options.Name,
+			kind,
+			options.Durable,
+			options.AutoDelete,
+			options.Internal,
+			options.NoWait,
+			args,
+		)
+	}
+
+	err = declare()
+	if isDrift(err) && options.OnDrift != driftFail {
+		exchange.metrics.reportDrift("exchange", options.Name, err)
+
+		// The failed declare closed the channel.
+		reopened, reopenErr := exchange.channel()
+		if reopenErr != nil {
+			return reopenErr
+		}
+		ch = reopened
+		if options.OnDrift == driftReport {
+			return nil
+		}
+		if err = ch.ExchangeDelete(options.Name, false, false); err != nil {
+			return err
+		}
+		err = declare()
+	}
+	return err
+}
+
+// declarePassive fails unless the exchange exists, without creating it.
+func (exchange *Exchange) declarePassive(name string) error {
+	ch, err := exchange.channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	// The kind and flags are not checked by the broker on a passive declare.
+	return ch.ExchangeDeclarePassive(name, amqpDriver.ExchangeDirect, false, false, false, false, nil)
+}
+
+// Exists tells whether an exchange exists with a passive declare, for preflight checks in setup.
+func (exchange *Exchange) Exists(name string) (exists bool, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if err = exchange.declarePassive(name); isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WaitDeleted waits up to timeoutMs for an exchange to be removed by the broker, telling whether it was, to
+// assert that an auto-delete exchange goes away once its last binding is removed.
+func (exchange *Exchange) WaitDeleted(name string, timeoutMs int) (deleted bool, err error) {
+	deleted, err = waitDeleted(exchange.vu.Context(), timeoutMs, func() (bool, error) {
+		return exchange.Exists(name)
+	})
+	return deleted, structuredError(err)
 }
 
 // Delete removes an exchange from the remote server given the exchange name.
-func (exchange *Exchange) Delete(name string) error {
-	ch, err := exchange.Connection.Channel()
+// The options are optional, by default the exchange is deleted along with its bindings.
+func (exchange *Exchange) Delete(name string, options ExchangeDeleteOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := exchange.channel()
 	if err != nil {
 		return err
 	}
@@ -75,14 +262,23 @@ func (exchange *Exchange) Delete(name string) error {
 	}()
 	return ch.ExchangeDelete(
 		name,
-		false, // ifUnused
-		false, // noWait
+		options.IfUnused,
+		options.NoWait,
 	)
 }
 
 // Bind subscribes one exchange to another.
-func (exchange *Exchange) Bind(options ExchangeBindOptions) error {
-	ch, err := exchange.Connection.Channel()
+func (exchange *Exchange) Bind(options ExchangeBindOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := headersBindArgs(options.Args, options.HeadersMatch, options.Headers)
+	if err != nil {
+		return err
+	}
+
+	ch, err := exchange.channel()
 	if err != nil {
 		return err
 	}
@@ -94,13 +290,22 @@ func (exchange *Exchange) Bind(options ExchangeBindOptions) error {
 		options.RoutingKey,
 		options.SourceExchangeName,
 		options.NoWait,
-		options.Args,
+		args,
 	)
 }
 
 // Unbind removes a subscription from one exchange to another.
-func (exchange *Exchange) Unbind(options ExchangeUnbindOptions) error {
-	ch, err := exchange.Connection.Channel()
+func (exchange *Exchange) Unbind(options ExchangeUnbindOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := headersBindArgs(options.Args, options.HeadersMatch, options.Headers)
+	if err != nil {
+		return err
+	}
+
+	ch, err := exchange.channel()
 	if err != nil {
 		return err
 	}
@@ -112,6 +317,6 @@ func (exchange *Exchange) Unbind(options ExchangeUnbindOptions) error {
 		options.RoutingKey,
 		options.SourceExchangeName,
 		options.NoWait,
-		options.Args,
+		args,
 	)
 }
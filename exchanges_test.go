@@ -0,0 +1,32 @@
+package amqp
+
+import "testing"
+
+func TestCheckExchangeKind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		kind    string
+		delayed bool
+		strict  bool
+		valid   bool
+	}{
+		{"topic", false, true, true},
+		{"x-consistent-hash", false, true, true},
+		{"x-delayed-message", false, true, true},
+		{"x-message-deduplication", false, true, true},
+		{"x-random", false, false, true},
+		{"x-random", false, true, false},
+		{"x-random", true, false, true},
+		{"x-delayed-message", true, false, false},
+		{"x-message-deduplication", true, false, true},
+		{"x-message-deduplication", true, true, false},
+		{"random", false, false, false},
+		{"", false, false, false},
+	}
+	for _, tt := range tests {
+		if err := checkExchangeKind(tt.kind, tt.delayed, tt.strict); (err == nil) != tt.valid {
+			t.Errorf("kind %q delayed=%v strict=%v: %v", tt.kind, tt.delayed, tt.strict, err)
+		}
+	}
+}
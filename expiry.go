@@ -0,0 +1,213 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Distributions of the per-message TTLs of an expiry sweep.
+const (
+	ttlFixed       = "fixed"
+	ttlUniform     = "uniform"
+	ttlExponential = "exponential"
+)
+
+const expiryPollInterval = 100 * time.Millisecond
+
+// ExpirySweepOptions defines an expiry sweep run.
+type ExpirySweepOptions struct {
+	QueueName       string // queue receiving the messages, without consumers
+	DeadLetterQueue string // queue the dead letter exchange of QueueName routes expired messages to, optional
+	Count           int
+	Distribution    string // fixed (default), uniform or exponential
+	MinTTLMs        int    // TTL with the fixed distribution, lower bound with the others
+	MaxTTLMs        int    // upper bound of the uniform distribution, optional cap of the exponential one
+	MeanTTLMs       int    // mean of the exponential distribution, added to MinTTLMs
+	TimeoutSec      int    // how long to wait after the last TTL elapsed, 30 by default
+}
+
+// ExpirySweepResult reports how the broker swept the expired messages.
+type ExpirySweepResult struct {
+	Published     int
+	Expired       int
+	DurationMs    float64 // from the end of the publishing until the last expiry observed
+	ExpiredPerSec float64
+	MaxLatenessMs float64 // longest delay between the TTL of a message elapsing and its dead lettering
+}
+
+// ExpirySweep publishes messages with TTLs following the configured distribution and measures how fast
+// the broker expires them. With a dead letter queue every expired message is received and its lateness
+// is emitted as the amqp_expiry_lateness trend, otherwise the depth of the queue is polled.
+func (amqp *AMQP) ExpirySweep(options ExpirySweepOptions) (result ExpirySweepResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ttl, err := ttlGenerator(options)
+	if err != nil {
+		return result, err
+	}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	var deliveries <-chan amqpDriver.Delivery
+	if options.DeadLetterQueue != "" {
+		if deliveries, err = ch.Consume(options.DeadLetterQueue, "", true, false, false, false, nil); err != nil {
+			return result, err
+		}
+	}
+
+	deadlines := make(map[string]time.Time, options.Count)
+	var lastDeadline time.Time
+	for i := 0; i < options.Count; i++ {
+		ms := ttl()
+		id := fmt.Sprintf("expiry-%s-%d", newUUID(), i)
+		err = amqp.publish(ch, PublishOptions{
+			QueueName:  options.QueueName,
+			MessageId:  id,
+			Expiration: strconv.Itoa(ms),
+		})
+		if err != nil {
+			return result, err
+		}
+		deadline := time.Now().Add(time.Duration(ms) * time.Millisecond)
+		deadlines[id] = deadline
+		if deadline.After(lastDeadline) {
+			lastDeadline = deadline
+		}
+		result.Published++
+	}
+
+	timeoutSec := options.TimeoutSec
+	if timeoutSec <= 0 {
+		timeoutSec = 30
+	}
+	start := time.Now()
+	timeout := time.NewTimer(time.Until(lastDeadline) + time.Duration(timeoutSec)*time.Second)
+	defer timeout.Stop()
+
+	if deliveries != nil {
+		err = amqp.sweepDeadLetters(deliveries, deadlines, timeout.C, &result)
+	} else {
+		err = amqp.sweepDepth(ch, options.QueueName, timeout.C, &result)
+	}
+	result.DurationMs = sinceMs(start)
+	if result.DurationMs > 0 {
+		result.ExpiredPerSec = float64(result.Expired) / (result.DurationMs / 1000)
+	}
+	return result, err
+}
+
+// sweepDeadLetters counts the expired messages dead lettered until all were received or the timeout elapsed.
+func (amqp *AMQP) sweepDeadLetters(
+	deliveries <-chan amqpDriver.Delivery, deadlines map[string]time.Time, timeout <-chan time.Time, result *ExpirySweepResult,
+) error {
+	for result.Expired < len(deadlines) {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return amqp.closeFailure(nil)
+			}
+			deadline, seeded := deadlines[d.MessageId]
+			if !seeded {
+				continue
+			}
+			delete(deadlines, d.MessageId)
+			result.Expired++
+
+			lateness := sinceMs(deadline)
+			amqp.metrics.push(amqp.metrics.ExpiryLateness, lateness, nil)
+			if lateness > result.MaxLatenessMs {
+				result.MaxLatenessMs = lateness
+			}
+		case <-timeout:
+			return nil
+		case <-amqp.vu.Context().Done():
+			return amqp.vu.Context().Err()
+		}
+	}
+	return nil
+}
+
+// sweepDepth polls the depth of the queue until it is empty or the timeout elapsed.
+func (amqp *AMQP) sweepDepth(ch *amqpDriver.Channel, queueName string, timeout <-chan time.Time, result *ExpirySweepResult) error {
+	for {
+		q, err := ch.QueueInspect(queueName)
+		if err != nil {
+			return err
+		}
+		result.Expired = result.Published - q.Messages
+		if q.Messages == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(expiryPollInterval):
+		case <-timeout:
+			return nil
+		case <-amqp.vu.Context().Done():
+			return amqp.vu.Context().Err()
+		}
+	}
+}
+
+// ttlGenerator returns a function drawing the TTL of each message in milliseconds.
+func ttlGenerator(options ExpirySweepOptions) (func() int, error) {
+	if options.QueueName == "" {
+		return nil, errors.New("queue name is required")
+	}
+	minTTL, maxTTL := options.MinTTLMs, options.MaxTTLMs
+	if minTTL < 0 || (options.Distribution == ttlUniform && maxTTL < minTTL) {
+		return nil, fmt.Errorf("invalid TTL bounds %d-%d", minTTL, maxTTL)
+	}
+
+	switch options.Distribution {
+	case "", ttlFixed:
+		return func() int { return minTTL }, nil
+	case ttlUniform:
+		return func() int { return minTTL + rand.Intn(maxTTL-minTTL+1) }, nil //nolint:gosec
+	case ttlExponential:
+		if options.MeanTTLMs <= 0 {
+			return nil, errors.New("mean TTL is required by the exponential distribution")
+		}
+		return func() int {
+			ttl := minTTL + int(rand.ExpFloat64()*float64(options.MeanTTLMs)) //nolint:gosec
+			if maxTTL > 0 && ttl > maxTTL {
+				return maxTTL
+			}
+			return ttl
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown TTL distribution %q, expected fixed, uniform or exponential", options.Distribution)
+	}
+}
+
+// waitDeleted checks every expiryPollInterval whether an entity still exists, until it is gone or timeoutMs
+// elapsed. The entity is checked at least once.
+func waitDeleted(ctx context.Context, timeoutMs int, exists func() (bool, error)) (bool, error) {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for {
+		found, err := exists()
+		if err != nil || !found {
+			return err == nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		if err := sleepContext(ctx, expiryPollInterval); err != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package amqp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.k6.io/k6/js/modules"
+)
+
+// fixtureFiles holds the files published whole, read once for all the VUs.
+type fixtureFiles struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// read returns the content of a file, read outside of the lock so that the VUs publishing cached files are
+// not held up by a large file being read. VUs reading the same file at once keep the first content cached.
+func (f *fixtureFiles) read(path string) ([]byte, error) {
+	f.mu.Lock()
+	content, ok := f.files[path]
+	f.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+
+	content, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cached, ok := f.files[path]; ok {
+		return cached, nil
+	}
+	if f.files == nil {
+		f.files = make(map[string][]byte)
+	}
+	f.files[path] = content
+	return content, nil
+}
+
+// scriptDir returns the directory of the script run by the VU, known in the init context, empty if the
+// script is not a local file.
+func scriptDir(vu modules.VU) string {
+	env := vu.InitEnv()
+	if env == nil || env.CWD == nil || env.CWD.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(env.CWD.Path)
+}
+
+// scriptPath resolves a relative path against the directory of the script, as open() does, rather than
+// against the working directory of k6.
+func (amqp *AMQP) scriptPath(path string) string {
+	if amqp.scriptDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(amqp.scriptDir, path)
+}
+
+// PublishFromFile publishes the content of a file, e.g. a binary fixture, without it going through a JS
+// string, and returns the correlation id of the message. Whole files are cached in memory and shared by
+// the VUs. With a chunk size, the file is streamed from disk as chunks also carrying the x-k6-file-name header.
+// Relative paths are resolved against the directory of the script.
+func (amqp *AMQP) PublishFromFile(path string, options PublishOptions) (correlationID string, err error) {
+	path = amqp.scriptPath(path)
+	if options.Payload.enabled() {
+		return "", structuredError(errors.New("files are published as the body, payload options are not supported"))
+	}
+	if options.ChunkSize <= 0 {
+		if options.body, err = amqp.fixtures.read(path); err != nil {
+			return "", structuredError(err)
+		}
+		return amqp.Publish(options)
+	}
+
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return "", structuredError(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return "", structuredError(err)
+	}
+	return amqp.publishChunks(file, info.Size(), filepath.Base(path), options)
+}
@@ -0,0 +1,62 @@
+package amqp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestPublishFromFileRelativeToScript(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "fixtures"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fixtures", "order.json"), []byte(`{"id":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := modulestest.NewRuntime(t)
+	rt.VU.InitEnvField.CWD = &url.URL{Scheme: "file", Path: filepath.ToSlash(dir) + "/"}
+	amqp := (&RootModule{}).instance(rt.VU)
+	if err := amqp.Start(Options{Loopback: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := amqp.Queue.Declare(DeclareOptions{Name: "fixtures"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := amqp.PublishFromFile("fixtures/order.json", PublishOptions{QueueName: "fixtures"}); err != nil {
+		t.Fatal(err)
+	}
+	body, err := amqp.Get(GetOptions{QueueName: "fixtures"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"id":1}` {
+		t.Errorf("published %q", body)
+	}
+	if _, err = amqp.PublishFromFile("missing.json", PublishOptions{QueueName: "fixtures"}); err == nil {
+		t.Error("published a missing file")
+	}
+}
+
+func TestScriptPath(t *testing.T) {
+	t.Parallel()
+
+	amqp := &AMQP{}
+	if path := amqp.scriptPath("fixtures/a.bin"); path != "fixtures/a.bin" {
+		t.Errorf("resolved %q without a script directory", path)
+	}
+	amqp.scriptDir = filepath.FromSlash("/tests")
+	if path := amqp.scriptPath("fixtures/a.bin"); path != filepath.FromSlash("/tests/fixtures/a.bin") {
+		t.Errorf("resolved %q", path)
+	}
+	abs, _ := filepath.Abs("a.bin")
+	if path := amqp.scriptPath(abs); path != abs {
+		t.Errorf("resolved the absolute path %q to %q", abs, path)
+	}
+}
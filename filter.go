@@ -0,0 +1,97 @@
+package amqp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// ListenFilter selects the deliveries passed to the listener, all conditions set must match.
+type ListenFilter struct {
+	Headers       map[string]interface{} // header values the delivery must carry
+	RoutingKey    string                 // topic pattern, * matches a word and # zero or more words
+	CorrelationId string
+}
+
+// deliveryFilter is a compiled ListenFilter, nil matches every delivery.
+type deliveryFilter struct {
+	headers       map[string]string
+	routingKey    *topicMatcher
+	correlationID string
+}
+
+func compileFilter(filter ListenFilter) *deliveryFilter {
+	if len(filter.Headers) == 0 && filter.RoutingKey == "" && filter.CorrelationId == "" {
+		return nil
+	}
+
+	compiled := &deliveryFilter{correlationID: filter.CorrelationId}
+	if len(filter.Headers) > 0 {
+		compiled.headers = make(map[string]string, len(filter.Headers))
+		for name, value := range filter.Headers {
+			compiled.headers[name] = fmt.Sprint(value)
+		}
+	}
+	if filter.RoutingKey != "" {
+		compiled.routingKey = topicPattern(filter.RoutingKey)
+	}
+	return compiled
+}
+
+// topicMatcher matches routing keys against a topic exchange binding pattern.
+type topicMatcher struct {
+	expr *regexp.Regexp
+}
+
+// topicPattern compiles a topic exchange binding pattern. Every word of the key is matched with its leading
+// dot, so that # consumes zero or more words along with their dots, the empty key having no words.
+func topicPattern(pattern string) *topicMatcher {
+	var words []string
+	if pattern != "" {
+		words = strings.Split(pattern, ".")
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, word := range words {
+		switch word {
+		case "#":
+			expr.WriteString(`(?:\.[^.]*)*`)
+		case "*":
+			expr.WriteString(`\.[^.]*`)
+		default:
+			expr.WriteString(`\.` + regexp.QuoteMeta(word))
+		}
+	}
+	expr.WriteString("$")
+	return &topicMatcher{expr: regexp.MustCompile(expr.String())}
+}
+
+// matches tells whether the routing key matches the pattern.
+func (m *topicMatcher) matches(key string) bool {
+	if key != "" {
+		key = "." + key
+	}
+	return m.expr.MatchString(key)
+}
+
+// matches tells whether the delivery satisfies the filter. Header values are compared by their text.
+func (f *deliveryFilter) matches(d amqpDriver.Delivery) bool {
+	if f == nil {
+		return true
+	}
+	if f.correlationID != "" && d.CorrelationId != f.correlationID {
+		return false
+	}
+	if f.routingKey != nil && !f.routingKey.matches(d.RoutingKey) {
+		return false
+	}
+	for name, value := range f.headers {
+		actual, ok := d.Headers[name]
+		if !ok || fmt.Sprint(actual) != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package amqp
+
+import "testing"
+
+func TestTopicPattern(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		pattern string
+		key     string
+		matches bool
+	}{
+		{"#", "", true},
+		{"#", "a", true},
+		{"#", "a.b.c", true},
+		{"#.#", "", true},
+		{"#.#", "a", true},
+		{"#.#", "a.b", true},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"a.#", "ab", false},
+		{"a.#", "b.a", false},
+		{"#.a", "a", true},
+		{"#.a", "b.c.a", true},
+		{"#.a", "ba", false},
+		{"a.#.b", "a.b", true},
+		{"a.#.b", "a.x.y.b", true},
+		{"a.#.b", "a.xb", false},
+		{"#.*", "", false},
+		{"#.*", "a", true},
+		{"#.*", "a.b", true},
+		{"*", "", false},
+		{"*", "a", true},
+		{"*", "a.b", false},
+		{"*.b", "a.b", true},
+		{"a.*", "a", false},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"", "", true},
+		{"", "a", false},
+	} {
+		if got := topicPattern(tc.pattern).matches(tc.key); got != tc.matches {
+			t.Errorf("pattern %q, key %q: got %v, want %v", tc.pattern, tc.key, got, tc.matches)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package amqp
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	firehoseExchange         = "amq.rabbitmq.trace"
+	defaultFirehoseMaxEvents = 10000
+	firehoseWaitPollInterval = 10 * time.Millisecond
+	firehoseEventPublish     = "publish"
+	firehoseEventDeliver     = "deliver"
+)
+
+// FirehoseOptions selects the traces recorded from the firehose of the virtual host.
+type FirehoseOptions struct {
+	Exchanges []string // record the messages published to these exchanges, amq.default for the default one
+	Queues    []string // record the messages delivered from these queues
+	MaxEvents int      // events kept, the oldest being dropped, 10000 by default
+}
+
+// TraceEvent is a message published or delivered, as traced by the firehose.
+type TraceEvent struct {
+	Event        string   // publish or deliver
+	Exchange     string   // exchange the message was published to
+	RoutingKeys  []string // routing key and CC / BCC keys of the message
+	Queue        string   // queue the message was delivered from
+	RoutedQueues []string // queues the message was routed to, on brokers reporting them
+	Redelivered  bool
+	Node         string
+	User         string
+	Connection   string
+	Channel      int64
+	Properties   map[string]interface{} // basic properties, e.g. headers, message_id or correlation_id
+	Body         string
+	Time         int64 // unix milliseconds the trace was received
+}
+
+// Firehose is a handle to the traces recorded with StartFirehose.
+type Firehose struct {
+	amqp      *AMQP
+	ch        *amqpDriver.Channel
+	maxEvents int
+
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// StartFirehose records the traces of the firehose of the virtual host, so tests can check end-to-end
+// where messages were routed. Tracing must be turned on with rabbitmqctl trace_on, a server-named
+// exclusive queue being bound to amq.rabbitmq.trace. Without exchanges nor queues, everything is recorded.
+func (amqp *AMQP) StartFirehose(options FirehoseOptions) (firehose *Firehose, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	var keys []string
+	for _, exchange := range options.Exchanges {
+		keys = append(keys, firehoseEventPublish+"."+exchange)
+	}
+	for _, queue := range options.Queues {
+		keys = append(keys, firehoseEventDeliver+"."+queue)
+	}
+	if len(keys) == 0 {
+		keys = []string{"#"}
+	}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, err
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err == nil {
+		for _, key := range keys {
+			if err = ch.QueueBind(q.Name, key, firehoseExchange, false, nil); err != nil {
+				break
+			}
+		}
+	}
+	var traces <-chan amqpDriver.Delivery
+	if err == nil {
+		traces, err = amqp.metrics.consume(ch, amqp.options.Timeouts, q.Name, "", true, true, false, false, nil)
+	}
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	firehose = &Firehose{amqp: amqp, ch: ch, maxEvents: options.MaxEvents}
+	if firehose.maxEvents <= 0 {
+		firehose.maxEvents = defaultFirehoseMaxEvents
+	}
+	go func() {
+		for d := range traces {
+			firehose.record(traceEvent(d))
+		}
+	}()
+	return firehose, nil
+}
+
+// traceEvent decodes a message of the firehose.
+func traceEvent(d amqpDriver.Delivery) TraceEvent {
+	event := TraceEvent{
+		Body: string(d.Body),
+		Time: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	event.Event = d.RoutingKey
+	var name string
+	if i := strings.IndexByte(d.RoutingKey, '.'); i >= 0 {
+		event.Event, name = d.RoutingKey[:i], d.RoutingKey[i+1:]
+	}
+	if event.Event == firehoseEventDeliver {
+		event.Queue = name
+	}
+
+	event.Exchange, _ = d.Headers["exchange_name"].(string)
+	event.RoutingKeys = traceStrings(d.Headers["routing_keys"])
+	event.RoutedQueues = traceStrings(d.Headers["routed_queues"])
+	event.Node, _ = d.Headers["node"].(string)
+	event.User, _ = d.Headers["user"].(string)
+	event.Connection, _ = d.Headers["connection"].(string)
+	properties, _ := d.Headers["properties"].(amqpDriver.Table)
+	event.Properties = plainTable(properties)
+	switch redelivered := d.Headers["redelivered"].(type) {
+	case bool:
+		event.Redelivered = redelivered
+	case int32:
+		event.Redelivered = redelivered != 0
+	case int64:
+		event.Redelivered = redelivered != 0
+	}
+	switch channel := d.Headers["channel"].(type) {
+	case int32:
+		event.Channel = int64(channel)
+	case int64:
+		event.Channel = channel
+	}
+	return event
+}
+
+// plainTable converts a table and its nested tables to maps, which unlike tables are seen as objects by scripts.
+func plainTable(table amqpDriver.Table) map[string]interface{} {
+	plain := make(map[string]interface{}, len(table))
+	for k, v := range table {
+		if nested, ok := v.(amqpDriver.Table); ok {
+			v = plainTable(nested)
+		}
+		plain[k] = v
+	}
+	return plain
+}
+
+// traceStrings returns the strings of an array header of the firehose.
+func traceStrings(value interface{}) []string {
+	values, _ := value.([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+func (firehose *Firehose) record(event TraceEvent) {
+	firehose.mu.Lock()
+	defer firehose.mu.Unlock()
+	if len(firehose.events) == firehose.maxEvents {
+		firehose.events = firehose.events[1:]
+	}
+	firehose.events = append(firehose.events, event)
+}
+
+// Events returns the traces recorded so far, oldest first.
+func (firehose *Firehose) Events() []TraceEvent {
+	firehose.mu.Lock()
+	defer firehose.mu.Unlock()
+	return append([]TraceEvent{}, firehose.events...)
+}
+
+// WaitFor waits until at least count traces are recorded and returns them. An error is returned if the
+// timeout elapses first, the firehose being probably off.
+func (firehose *Firehose) WaitFor(count int, timeoutMs int) ([]TraceEvent, error) {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for {
+		events := firehose.Events()
+		if len(events) >= count {
+			return events, nil
+		}
+		if time.Now().After(deadline) {
+			return events, errors.New("firehose traces missing, is tracing on (rabbitmqctl trace_on)?")
+		}
+		if err := sleepContext(firehose.amqp.vu.Context(), firehoseWaitPollInterval); err != nil {
+			return events, err
+		}
+	}
+}
+
+// Clear forgets the traces recorded so far, e.g. between the steps of a test.
+func (firehose *Firehose) Clear() {
+	firehose.mu.Lock()
+	defer firehose.mu.Unlock()
+	firehose.events = nil
+}
+
+// Stop stops recording, the trace queue being deleted with its channel.
+func (firehose *Firehose) Stop() error {
+	return structuredError(firehose.ch.Close())
+}
@@ -3,14 +3,19 @@ module github.com/alex-tkachyk/xk6-amqp
 go 1.17
 
 require (
+	github.com/dop251/goja v0.0.0-20221106173738-3b8a68ca89b4
+	github.com/gorilla/websocket v1.5.0
+	github.com/klauspost/compress v1.15.11
 	github.com/rabbitmq/amqp091-go v1.5.0
+	github.com/serenize/snaker v0.0.0-20201027110005-a7ad2135616e
 	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.k6.io/k6 v0.42.0
+	golang.org/x/time v0.0.0-20220922220347-f3bd1da661af
+	google.golang.org/protobuf v1.28.1
 )
 
 require (
 	github.com/dlclark/regexp2 v1.7.0 // indirect
-	github.com/dop251/goja v0.0.0-20221106173738-3b8a68ca89b4 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/go-sourcemap/sourcemap v2.1.4-0.20211119122758-180fcef48034+incompatible // indirect
 	github.com/josharian/intern v1.0.0 // indirect
@@ -21,12 +26,10 @@ require (
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.24.2 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
-	github.com/serenize/snaker v0.0.0-20201027110005-a7ad2135616e // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	github.com/spf13/afero v1.1.2 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
-	golang.org/x/time v0.0.0-20220922220347-f3bd1da661af // indirect
 	gopkg.in/guregu/null.v3 v3.3.0 // indirect
 )
@@ -0,0 +1,110 @@
+package amqp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dop251/goja"
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// maxSafeInteger is the largest integer a JavaScript number represents exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// headersMatchModes lists the x-match values understood by a headers exchange.
+var headersMatchModes = map[string]bool{
+	"all":        true,
+	"any":        true,
+	"all-with-x": true,
+	"any-with-x": true,
+}
+
+// headersBindArgs merges header matching settings into binding arguments.
+// The original args table is left untouched.
+func headersBindArgs(args amqpDriver.Table, match string, headers amqpDriver.Table) (amqpDriver.Table, error) {
+	if match == "" && len(headers) == 0 {
+		return args, nil
+	}
+	if match == "" {
+		match = "all"
+	}
+	if !headersMatchModes[match] {
+		return nil, fmt.Errorf("unsupported headers match mode %q, expected one of all, any, all-with-x, any-with-x", match)
+	}
+
+	merged := amqpDriver.Table{}
+	for k, v := range args {
+		merged[k] = v
+	}
+	coerced, err := coerceTable(headers)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range coerced {
+		merged[k] = v
+	}
+	merged["x-match"] = match
+	return merged, nil
+}
+
+// coerceTable converts values exported from JavaScript into AMQP field table types.
+func coerceTable(table amqpDriver.Table) (amqpDriver.Table, error) {
+	if table == nil {
+		return nil, nil
+	}
+	coerced := make(amqpDriver.Table, len(table))
+	for k, v := range table {
+		value, err := coerceField(v)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", k, err)
+		}
+		coerced[k] = value
+	}
+	return coerced, nil
+}
+
+// coerceField converts a single JavaScript value into an AMQP field value.
+// Whole numbers become long integers since JavaScript has no separate integer type.
+func coerceField(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case nil, bool, string, []byte, int8, int16, int32, int64, time.Time, amqpDriver.Decimal:
+		return value, nil
+	case int:
+		return int64(value), nil
+	case float32:
+		return coerceField(float64(value))
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) <= maxSafeInteger {
+			return int64(value), nil
+		}
+		return value, nil
+	case goja.ArrayBuffer:
+		return value.Bytes(), nil
+	case map[string]interface{}:
+		return coerceTable(value)
+	case amqpDriver.Table:
+		return coerceTable(value)
+	case []interface{}:
+		coerced := make([]interface{}, len(value))
+		for i, item := range value {
+			c, err := coerceField(item)
+			if err != nil {
+				return nil, fmt.Errorf("array index %d: %w", i, err)
+			}
+			coerced[i] = c
+		}
+		return coerced, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// withField returns a copy of a field table (headers or arguments) with the given field set.
+func withField(table amqpDriver.Table, name string, value interface{}) amqpDriver.Table {
+	copied := make(amqpDriver.Table, len(table)+1)
+	for k, v := range table {
+		copied[k] = v
+	}
+	copied[name] = value
+	return copied
+}
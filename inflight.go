@@ -0,0 +1,104 @@
+package amqp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutstandingDeliveries describes the deliveries a consumer received but did not settle yet.
+type OutstandingDeliveries struct {
+	Consumer        string
+	QueueName       string
+	Unacked         int
+	OldestUnackedMs float64 // age of the oldest unacknowledged delivery, 0 if none
+}
+
+// inflightTracker records unacknowledged deliveries of a single consumer.
+// A nil tracker ignores every call, which is used for auto ack consumers.
+type inflightTracker struct {
+	consumer  string
+	queueName string
+
+	mu        sync.Mutex
+	delivered map[uint64]time.Time
+}
+
+// trackInflight registers a tracker for the consumer until untrackInflight is called.
+func (amqp *AMQP) trackInflight(consumer string, queueName string) *inflightTracker {
+	tracker := &inflightTracker{
+		consumer:  consumer,
+		queueName: queueName,
+		delivered: make(map[uint64]time.Time),
+	}
+	amqp.inflight.Store(tracker, struct{}{})
+	return tracker
+}
+
+// untrackInflight forgets a tracker once its consumer is gone.
+func (amqp *AMQP) untrackInflight(tracker *inflightTracker) {
+	if tracker != nil {
+		amqp.inflight.Delete(tracker)
+	}
+}
+
+func (t *inflightTracker) add(tag uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delivered[tag] = time.Now()
+}
+
+// settle forgets a settled delivery, or every delivery up to tag when multiple is set.
+func (t *inflightTracker) settle(tag uint64, multiple bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !multiple {
+		delete(t.delivered, tag)
+		return
+	}
+	for delivered := range t.delivered {
+		if delivered <= tag {
+			delete(t.delivered, delivered)
+		}
+	}
+}
+
+func (t *inflightTracker) snapshot() OutstandingDeliveries {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outstanding := OutstandingDeliveries{
+		Consumer:  t.consumer,
+		QueueName: t.queueName,
+		Unacked:   len(t.delivered),
+	}
+	now := time.Now()
+	for _, at := range t.delivered {
+		if age := float64(now.Sub(at)) / float64(time.Millisecond); age > outstanding.OldestUnackedMs {
+			outstanding.OldestUnackedMs = age
+		}
+	}
+	return outstanding
+}
+
+// Outstanding returns the unacknowledged deliveries of every manual ack consumer of the VU and emits
+// them as the amqp_unacked_deliveries and amqp_oldest_unacked_age gauges tagged by consumer.
+func (amqp *AMQP) Outstanding() []OutstandingDeliveries {
+	var all []OutstandingDeliveries
+	amqp.inflight.Range(func(key, _ interface{}) bool {
+		outstanding := key.(*inflightTracker).snapshot()
+		tags := map[string]string{"queue": outstanding.QueueName, "consumer": outstanding.Consumer}
+		amqp.metrics.push(amqp.metrics.UnackedDeliveries, float64(outstanding.Unacked), tags)
+		amqp.metrics.push(amqp.metrics.OldestUnackedAge, outstanding.OldestUnackedMs, tags)
+		all = append(all, outstanding)
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].Consumer < all[j].Consumer })
+	return all
+}
@@ -0,0 +1,199 @@
+package amqp
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modulestest"
+)
+
+// newLoopbackRuntime returns a runtime with the module exposed as Amqp and Queue, started on the loopback broker.
+func newLoopbackRuntime(t *testing.T) (*modulestest.Runtime, *AMQP) {
+	t.Helper()
+
+	rt := modulestest.NewRuntime(t)
+	amqp := (&RootModule{}).instance(rt.VU)
+	if err := rt.VU.Runtime().Set("Amqp", amqp); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.VU.Runtime().Set("Queue", amqp.Queue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.VU.Runtime().RunString(`Amqp.start({ loopback: true })`); err != nil {
+		t.Fatal(err)
+	}
+	return rt, amqp
+}
+
+// runIteration runs a script on the event loop and waits for the iteration to end, as k6 does.
+func runIteration(t *testing.T, rt *modulestest.Runtime, script string) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rt.EventLoop.Start(func() error {
+			_, err := rt.VU.Runtime().RunString(script)
+			return err
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the iteration did not end")
+	}
+}
+
+func TestListenMaxMessagesEndsIteration(t *testing.T) {
+	t.Parallel()
+
+	rt, amqp := newLoopbackRuntime(t)
+	runIteration(t, rt, `
+		Queue.declare({ name: 'max-messages' })
+		for (let i = 0; i < 3; i++) {
+			Amqp.publish({ queue_name: 'max-messages', body: 'm' + i })
+		}
+		var received = []
+		Amqp.listen({ queue_name: 'max-messages', ack_policy: 'manual', max_messages: 2, listener: (body) => { received.push(body) } })
+	`)
+
+	received := rt.VU.Runtime().Get("received").Export()
+	if got, ok := received.([]interface{}); !ok || len(got) != 2 || got[0] != "m0" || got[1] != "m1" {
+		t.Errorf("received %v, expected [m0 m1]", received)
+	}
+	// The message not passed to the listener is requeued once the channel is closed.
+	deadline := time.Now().Add(time.Second)
+	for {
+		q, err := amqp.Queue.Inspect("max-messages")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Messages == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages left in the queue, expected 1", q.Messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenCancelEndsIteration(t *testing.T) {
+	t.Parallel()
+
+	rt, _ := newLoopbackRuntime(t)
+	runIteration(t, rt, `
+		Queue.declare({ name: 'cancel' })
+		Amqp.publish({ queue_name: 'cancel', body: 'stop' })
+		var calls = 0
+		const listener = Amqp.listen({ queue_name: 'cancel', listener: () => { calls++; listener.cancel() } })
+	`)
+
+	if calls := rt.VU.Runtime().Get("calls").ToInteger(); calls != 1 {
+		t.Errorf("listener called %d times, expected 1", calls)
+	}
+}
+
+func TestListenPacedDeliveryLeftWhenIterationEnds(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	root := &RootModule{}
+	amqp := root.instance(rt.VU)
+	var calls int64
+	if err := rt.VU.Runtime().Set("Amqp", amqp); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.VU.Runtime().Set("seen", func() { atomic.AddInt64(&calls, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// The second delivery waits for the pacer when the iteration is interrupted.
+		deadline := time.Now().Add(2 * time.Second)
+		for atomic.LoadInt64(&calls) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		rt.CancelContext()
+	}()
+	runIteration(t, rt, `
+		Amqp.start({ loopback: true })
+		Amqp.queue.declare({ name: 'paced' })
+		Amqp.publish({ queue_name: 'paced', body: 'm0' })
+		Amqp.publish({ queue_name: 'paced', body: 'm1' })
+		Amqp.listen({ queue_name: 'paced', ack_policy: 'manual', error_requeue: 'never', max_rate_per_second: 0.1, listener: seen })
+	`)
+	if calls := atomic.LoadInt64(&calls); calls != 1 {
+		t.Errorf("listener called %d times, expected 1", calls)
+	}
+
+	// The held back delivery is neither processed nor rejected, the broker requeues it.
+	url, err := root.loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := amqpDriver.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		q, err := ch.QueueDeclarePassive("paced", false, false, false, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Messages == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages left in the queue, expected 1", q.Messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenBufferEndsWithListener(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{bufferBlock, bufferDropOldest} {
+		policy := policy
+		t.Run(policy, func(t *testing.T) {
+			t.Parallel()
+
+			rt := modulestest.NewRuntime(t)
+			amqp := (&RootModule{}).instance(rt.VU)
+			msgs := make(chan amqpDriver.Delivery, 3)
+			for i := 0; i < 3; i++ {
+				msgs <- amqpDriver.Delivery{DeliveryTag: uint64(i + 1)}
+			}
+			done := make(chan struct{})
+			buffer := amqp.bufferDeliveries(msgs, done, ListenOptions{BufferSize: 1, OverflowPolicy: policy}, true, nil)
+			time.Sleep(20 * time.Millisecond)
+
+			// The listener is done while the buffer is full, the delivery channel staying open.
+			close(done)
+			deadline := time.After(time.Second)
+			for {
+				select {
+				case _, ok := <-buffer:
+					if !ok {
+						return
+					}
+				case <-deadline:
+					t.Fatal("the buffer still reads the deliveries once the listener is done")
+				}
+			}
+		})
+	}
+}
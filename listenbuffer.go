@@ -0,0 +1,91 @@
+package amqp
+
+import (
+	"fmt"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Overflow policies of the listen buffer.
+const (
+	bufferBlock      = "block"
+	bufferDropOldest = "drop-oldest"
+	bufferDropNew    = "drop-new"
+)
+
+// checkBuffer validates the buffer options.
+func (options ListenOptions) checkBuffer() error {
+	if options.BufferSize < 0 {
+		return fmt.Errorf("buffer size must not be negative, got %d", options.BufferSize)
+	}
+	switch options.OverflowPolicy {
+	case "", bufferBlock, bufferDropOldest, bufferDropNew:
+		return nil
+	default:
+		return fmt.Errorf("unsupported overflow policy %q, expected one of block, drop-oldest, drop-new", options.OverflowPolicy)
+	}
+}
+
+// bufferDeliveries reads the deliveries of the consumer into a buffer of the given size, applying the
+// overflow policy when the listener falls behind, until the listener is done. Dropped deliveries are counted
+// as amqp_deliveries_dropped and rejected without requeue unless the consumer is in auto ack mode.
+func (amqp *AMQP) bufferDeliveries(
+	msgs <-chan amqpDriver.Delivery,
+	done <-chan struct{},
+	options ListenOptions,
+	autoAck bool,
+	tags map[string]string,
+) <-chan amqpDriver.Delivery {
+	buffer := make(chan amqpDriver.Delivery, options.BufferSize)
+	drop := func(d amqpDriver.Delivery) {
+		amqp.metrics.push(amqp.metrics.DeliveriesDropped, 1, tags)
+		if !autoAck {
+			_ = amqp.profile.reject(d, false)
+		}
+	}
+
+	go func() {
+		defer close(buffer)
+		for {
+			var d amqpDriver.Delivery
+			select {
+			case delivery, ok := <-msgs:
+				if !ok {
+					return
+				}
+				d = delivery
+			case <-done:
+				return
+			}
+
+			switch options.OverflowPolicy {
+			case bufferDropNew:
+				select {
+				case buffer <- d:
+				default:
+					drop(d)
+				}
+				continue
+			case bufferDropOldest:
+				select {
+				case buffer <- d:
+					continue
+				default:
+				}
+				// The listener may take the oldest one meanwhile, the buffer has room either way.
+				select {
+				case oldest := <-buffer:
+					drop(oldest)
+				default:
+				}
+			}
+			// Deliveries the listener no longer takes are left to the broker to requeue.
+			select {
+			case buffer <- d:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return buffer
+}
@@ -0,0 +1,1246 @@
+package amqp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	loopbackProduct    = "xk6-amqp loopback"
+	loopbackChannelMax = 2047
+	loopbackHeartbeat  = 60
+)
+
+// loopbackCapabilities are advertised in the server properties of the loopback broker. Besides the AMQP
+// capabilities of the driver, they flag the broker features the loopback broker does not simulate.
+func loopbackCapabilities() amqpDriver.Table {
+	return amqpDriver.Table{
+		"publisher_confirms":         true,
+		"exchange_exchange_bindings": true,
+		"basic.nack":                 true,
+		"consumer_cancel_notify":     true,
+		"per_consumer_qos":           true,
+		"connection.blocked":         false,
+		"persistence":                false,
+		"message_ttl":                false,
+		"dead_lettering":             false,
+		"priorities":                 false,
+		"max_length":                 false,
+		"streams":                    false,
+		"transactions":               false,
+		"delayed_messages":           false,
+		"consistent_hash":            false,
+		"management_api":             false,
+	}
+}
+
+// loopbackBroker is an in-process AMQP 0-9-1 broker shared by the VUs, listening on the loopback interface
+// so that scripts run unchanged without a real broker. It routes messages from exchanges to queues in
+// memory: nothing is persisted and the features flagged false in loopbackCapabilities are not simulated.
+type loopbackBroker struct {
+	startOnce sync.Once
+	url       string
+	startErr  error
+
+	mu        sync.Mutex
+	exchanges map[string]*loopExchange
+	queues    map[string]*loopQueue
+}
+
+type loopExchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       amqpDriver.Table
+	bindings   []*loopBinding // bindings having the exchange as source
+	bound      bool           // had bindings, auto delete applies once the last one is gone
+}
+
+// loopBinding routes messages to a queue or to another exchange.
+type loopBinding struct {
+	queue    *loopQueue
+	exchange *loopExchange
+	key      string
+	args     amqpDriver.Table
+	pattern  *topicMatcher // binding key of a topic exchange
+}
+
+type loopQueue struct {
+	name              string
+	durable           bool
+	exclusive         bool
+	autoDelete        bool
+	args              amqpDriver.Table
+	owner             *loopConn // connection of an exclusive queue
+	messages          []*loopMessage
+	consumers         []*loopConsumer
+	exclusiveConsumer *loopConsumer
+	next              int  // round robin position among the consumers
+	consumed          bool // had consumers, auto delete applies once the last one is gone
+	deleted           bool
+}
+
+type loopMessage struct {
+	exchange    string
+	routingKey  string
+	header      []byte // content header frame payload, forwarded as is
+	body        []byte
+	redelivered bool
+}
+
+type loopConsumer struct {
+	tag      string
+	channel  *loopChannel
+	queue    *loopQueue
+	noAck    bool
+	prefetch int
+	unacked  int
+}
+
+type loopDelivery struct {
+	message  *loopMessage
+	queue    *loopQueue
+	consumer *loopConsumer // nil for basic.get
+}
+
+// loopPublish is a message being received, between its basic.publish and its last body frame.
+type loopPublish struct {
+	exchange  string
+	key       string
+	mandatory bool
+	header    []byte
+	size      uint64
+	headers   amqpDriver.Table
+	body      []byte
+}
+
+// loopError is a channel or connection exception raised by the loopback broker.
+type loopError struct {
+	code uint16
+	text string
+}
+
+func loopErrorf(code int, format string, args ...interface{}) *loopError {
+	return &loopError{code: uint16(code), text: replyNames[code] + " - " + fmt.Sprintf(format, args...)}
+}
+
+// start listens on a random loopback port once per test run and returns the URL of the broker.
+func (b *loopbackBroker) start() (string, error) {
+	b.startOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.startErr = err
+			return
+		}
+		b.exchanges = map[string]*loopExchange{"": {kind: amqpDriver.ExchangeDirect, durable: true}}
+		for name, kind := range map[string]string{
+			"amq.direct":  amqpDriver.ExchangeDirect,
+			"amq.fanout":  amqpDriver.ExchangeFanout,
+			"amq.topic":   amqpDriver.ExchangeTopic,
+			"amq.headers": amqpDriver.ExchangeHeaders,
+			"amq.match":   amqpDriver.ExchangeHeaders,
+		} {
+			b.exchanges[name] = &loopExchange{name: name, kind: kind, durable: true}
+		}
+		b.queues = make(map[string]*loopQueue)
+		b.url = "amqp://guest:guest@" + listener.Addr().String() + "/"
+		go b.accept(listener)
+	})
+	return b.url, b.startErr
+}
+
+func (b *loopbackBroker) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(conn)
+	}
+}
+
+// loopConn is a client connection. Its channels are guarded by the broker lock, frames are written
+// by a goroutine of their own so that a slow client never blocks the broker.
+type loopConn struct {
+	broker   *loopbackBroker
+	netConn  net.Conn
+	channels map[uint16]*loopChannel
+	frameMax int
+	done     chan struct{}
+
+	outMu   sync.Mutex
+	outCond *sync.Cond
+	out     [][]byte
+	closing bool
+}
+
+func (b *loopbackBroker) serve(netConn net.Conn) {
+	c := &loopConn{
+		broker:   b,
+		netConn:  netConn,
+		channels: make(map[uint16]*loopChannel),
+		frameMax: wireFrameMax,
+		done:     make(chan struct{}),
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	go c.writeLoop()
+	defer func() {
+		close(c.done)
+		b.mu.Lock()
+		c.release()
+		b.mu.Unlock()
+		c.shutdown()
+	}()
+
+	reader := bufio.NewReader(netConn)
+	header := make([]byte, len(wireProtocolHeader))
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if string(header) != wireProtocolHeader {
+		c.write([]byte(wireProtocolHeader))
+		return
+	}
+	c.method(0, methodConnectionStart, func(w *wireWriter) {
+		w.octet(0)
+		w.octet(9)
+		w.table(amqpDriver.Table{
+			"product":      loopbackProduct,
+			"version":      version,
+			"platform":     defaultPlatform,
+			"capabilities": loopbackCapabilities(),
+		})
+		w.longstr([]byte("PLAIN AMQPLAIN EXTERNAL"))
+		w.longstr([]byte(defaultLocale))
+	})
+
+	for {
+		frame, err := readWireFrame(reader)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		open := c.handle(frame)
+		b.mu.Unlock()
+		if !open {
+			return
+		}
+	}
+}
+
+// handle processes a frame and reports whether the connection stays open.
+func (c *loopConn) handle(frame wireFrame) bool {
+	switch frame.typ {
+	case frameHeartbeat:
+		return true
+	case frameHeader, frameBody:
+		ch := c.channels[frame.channel]
+		if ch == nil || ch.closing {
+			return true
+		}
+		if err := ch.content(frame); err != nil {
+			return c.fail(err, 0)
+		}
+		return true
+	case frameMethod:
+	default:
+		return c.fail(loopErrorf(amqpDriver.FrameError, "unknown frame type %d", frame.typ), 0)
+	}
+
+	r := &wireReader{buf: frame.payload}
+	id := uint32(r.short())<<16 | uint32(r.short())
+	if frame.channel == 0 {
+		return c.handleConnection(id, r)
+	}
+
+	ch := c.channels[frame.channel]
+	switch {
+	case id == methodChannelOpen && ch == nil:
+		c.channels[frame.channel] = &loopChannel{
+			conn:      c,
+			id:        frame.channel,
+			consumers: make(map[string]*loopConsumer),
+			unacked:   make(map[uint64]*loopDelivery),
+		}
+		c.method(frame.channel, methodChannelOpenOk, func(w *wireWriter) {
+			w.longstr(nil)
+		})
+		return true
+	case id == methodChannelOpen:
+		return c.fail(loopErrorf(amqpDriver.ChannelError, "second 'channel.open' seen"), id)
+	case ch == nil:
+		return c.fail(loopErrorf(amqpDriver.ChannelError, "expected 'channel.open'"), id)
+	case ch.closing:
+		// Everything but the reply to the channel.close sent by the broker is discarded.
+		if id == methodChannelCloseOk {
+			delete(c.channels, ch.id)
+		}
+		return true
+	}
+
+	if err := ch.handle(id, r); err != nil {
+		ch.fail(err, id)
+	}
+	if r.err != nil {
+		return c.fail(loopErrorf(amqpDriver.SyntaxError, "%s", r.err), id)
+	}
+	return true
+}
+
+func (c *loopConn) handleConnection(id uint32, r *wireReader) bool {
+	switch id {
+	case methodConnectionStartOk:
+		c.method(0, methodConnectionTune, func(w *wireWriter) {
+			w.short(loopbackChannelMax)
+			w.long(wireFrameMax)
+			w.short(loopbackHeartbeat)
+		})
+	case methodConnectionTuneOk:
+		r.short() // channel max
+		if frameMax := int(r.long()); frameMax > 0 && frameMax < wireFrameMax {
+			c.frameMax = frameMax
+		}
+		if heartbeat := time.Duration(r.short()) * time.Second; heartbeat > 0 {
+			go c.heartbeat(heartbeat / 2)
+		}
+	case methodConnectionOpen:
+		c.method(0, methodConnectionOpenOk, func(w *wireWriter) {
+			w.shortstr("")
+		})
+	case methodConnectionClose:
+		c.method(0, methodConnectionCloseOk, nil)
+		return false
+	case methodConnectionCloseOk:
+		return false
+	case methodConnectionUpdateSecret:
+		c.method(0, methodConnectionSecretOk, nil)
+	default:
+		return c.fail(loopErrorf(amqpDriver.NotImplemented, "method %d.%d is not supported by the loopback broker", id>>16, id&0xffff), id)
+	}
+	return true
+}
+
+// fail closes the connection with an exception.
+func (c *loopConn) fail(err *loopError, id uint32) bool {
+	c.method(0, methodConnectionClose, func(w *wireWriter) {
+		w.short(err.code)
+		w.shortstr(err.text)
+		w.short(uint16(id >> 16))
+		w.short(uint16(id))
+	})
+	return false
+}
+
+// release frees the channels and exclusive queues of a closed connection.
+func (c *loopConn) release() {
+	for _, ch := range c.channels {
+		ch.release()
+	}
+	for _, q := range c.broker.queues {
+		if q.owner == c {
+			c.broker.deleteQueue(q)
+		}
+	}
+}
+
+func (c *loopConn) write(frames ...[]byte) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closing {
+		return
+	}
+	c.out = append(c.out, frames...)
+	c.outCond.Signal()
+}
+
+// shutdown closes the connection once the pending frames are written.
+func (c *loopConn) shutdown() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.closing = true
+	c.outCond.Signal()
+}
+
+func (c *loopConn) writeLoop() {
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.closing {
+			c.outCond.Wait()
+		}
+		out, closing := c.out, c.closing
+		c.out = nil
+		c.outMu.Unlock()
+
+		var batch []byte
+		for _, frame := range out {
+			batch = append(batch, frame...)
+		}
+		if _, err := c.netConn.Write(batch); err != nil || closing {
+			_ = c.netConn.Close()
+			c.shutdown()
+			return
+		}
+	}
+}
+
+func (c *loopConn) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.write(wireFrame{typ: frameHeartbeat}.encode())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *loopConn) method(channel uint16, id uint32, args func(w *wireWriter)) {
+	c.write(methodFrame(channel, id, args))
+}
+
+// content writes a method carrying a message, split into body frames no larger than the frame max.
+func (c *loopConn) content(channel uint16, id uint32, args func(w *wireWriter), message *loopMessage) {
+	frames := [][]byte{
+		methodFrame(channel, id, args),
+		wireFrame{typ: frameHeader, channel: channel, payload: message.header}.encode(),
+	}
+	limit := c.frameMax - wireFrameOverhead
+	for body := message.body; len(body) > 0; {
+		n := len(body)
+		if n > limit {
+			n = limit
+		}
+		frames = append(frames, wireFrame{typ: frameBody, channel: channel, payload: body[:n]}.encode())
+		body = body[n:]
+	}
+	c.write(frames...)
+}
+
+func methodFrame(channel uint16, id uint32, args func(w *wireWriter)) []byte {
+	var w wireWriter
+	w.short(uint16(id >> 16))
+	w.short(uint16(id))
+	if args != nil {
+		args(&w)
+	}
+	return wireFrame{typ: frameMethod, channel: channel, payload: w.Bytes()}.encode()
+}
+
+type loopChannel struct {
+	conn           *loopConn
+	id             uint16
+	prefetch       int // basic.qos of the consumers started afterwards
+	globalPrefetch int // basic.qos shared by the consumers of the channel
+	consumers      map[string]*loopConsumer
+	unacked        map[uint64]*loopDelivery
+	deliveryTag    uint64
+	confirm        bool
+	publishTag     uint64
+	publishing     *loopPublish
+	closing        bool // closed by the broker, waiting for channel.close-ok
+}
+
+// handle processes a method of the channel.
+func (ch *loopChannel) handle(id uint32, r *wireReader) *loopError {
+	b := ch.conn.broker
+	switch id {
+	case methodChannelFlow:
+		active := r.bits()[0]
+		ch.conn.method(ch.id, methodChannelFlowOk, func(w *wireWriter) {
+			w.bits(active)
+		})
+	case methodChannelClose:
+		ch.release()
+		delete(ch.conn.channels, ch.id)
+		ch.conn.method(ch.id, methodChannelCloseOk, nil)
+
+	case methodExchangeDeclare:
+		r.short()
+		name, kind, bits, args := r.shortstr(), r.shortstr(), r.bits(), r.table()
+		if r.err != nil {
+			return nil
+		}
+		if err := b.declareExchange(name, kind, bits[0], bits[1], bits[2], bits[3], args); err != nil {
+			return err
+		}
+		ch.reply(bits[4], methodExchangeDeclareOk, nil)
+	case methodExchangeDelete:
+		r.short()
+		name, bits := r.shortstr(), r.bits()
+		if r.err != nil {
+			return nil
+		}
+		if err := b.deleteExchangeNamed(name, bits[0]); err != nil {
+			return err
+		}
+		ch.reply(bits[1], methodExchangeDeleteOk, nil)
+	case methodExchangeBind, methodExchangeUnbind:
+		r.short()
+		destination, source, key, noWait, args := r.shortstr(), r.shortstr(), r.shortstr(), r.bits()[0], r.table()
+		if r.err != nil {
+			return nil
+		}
+		if id == methodExchangeUnbind {
+			b.unbindExchange(destination, source, key, args)
+			ch.reply(noWait, methodExchangeUnbindOk, nil)
+			return nil
+		}
+		if err := b.bindExchange(destination, source, key, args); err != nil {
+			return err
+		}
+		ch.reply(noWait, methodExchangeBindOk, nil)
+
+	case methodQueueDeclare:
+		r.short()
+		name, bits, args := r.shortstr(), r.bits(), r.table()
+		if r.err != nil {
+			return nil
+		}
+		q, err := b.declareQueue(ch.conn, name, bits[0], bits[1], bits[2], bits[3], args)
+		if err != nil {
+			return err
+		}
+		ch.reply(bits[4], methodQueueDeclareOk, func(w *wireWriter) {
+			w.shortstr(q.name)
+			w.long(uint32(len(q.messages)))
+			w.long(uint32(len(q.consumers)))
+		})
+	case methodQueueBind, methodQueueUnbind:
+		r.short()
+		queueName, exchangeName, key := r.shortstr(), r.shortstr(), r.shortstr()
+		noWait := false
+		if id == methodQueueBind {
+			noWait = r.bits()[0]
+		}
+		args := r.table()
+		if r.err != nil {
+			return nil
+		}
+		if id == methodQueueUnbind {
+			b.unbindQueue(queueName, exchangeName, key, args)
+			ch.reply(false, methodQueueUnbindOk, nil)
+			return nil
+		}
+		if err := b.bindQueue(ch.conn, queueName, exchangeName, key, args); err != nil {
+			return err
+		}
+		ch.reply(noWait, methodQueueBindOk, nil)
+	case methodQueuePurge:
+		r.short()
+		name, noWait := r.shortstr(), r.bits()[0]
+		if r.err != nil {
+			return nil
+		}
+		q, err := b.ownedQueue(ch.conn, name)
+		if err != nil {
+			return err
+		}
+		count := len(q.messages)
+		q.messages = nil
+		ch.reply(noWait, methodQueuePurgeOk, func(w *wireWriter) {
+			w.long(uint32(count))
+		})
+	case methodQueueDelete:
+		r.short()
+		name, bits := r.shortstr(), r.bits()
+		if r.err != nil {
+			return nil
+		}
+		count, err := b.deleteQueueNamed(ch.conn, name, bits[0], bits[1])
+		if err != nil {
+			return err
+		}
+		ch.reply(bits[2], methodQueueDeleteOk, func(w *wireWriter) {
+			w.long(uint32(count))
+		})
+
+	case methodBasicQos:
+		r.long() // prefetch size
+		count, global := int(r.short()), r.bits()[0]
+		if global {
+			ch.globalPrefetch = count
+		} else {
+			ch.prefetch = count
+		}
+		ch.conn.method(ch.id, methodBasicQosOk, nil)
+		ch.dispatch(nil)
+	case methodBasicConsume:
+		r.short()
+		queueName, tag, bits, _ := r.shortstr(), r.shortstr(), r.bits(), r.table()
+		if r.err != nil {
+			return nil
+		}
+		return ch.consume(queueName, tag, bits[1], bits[2], bits[3])
+	case methodBasicCancel:
+		tag, noWait := r.shortstr(), r.bits()[0]
+		if consumer, ok := ch.consumers[tag]; ok {
+			b.cancel(consumer)
+		}
+		ch.reply(noWait, methodBasicCancelOk, func(w *wireWriter) {
+			w.shortstr(tag)
+		})
+	case methodBasicPublish:
+		r.short()
+		exchange, key, bits := r.shortstr(), r.shortstr(), r.bits()
+		ch.publishing = &loopPublish{exchange: exchange, key: key, mandatory: bits[0]}
+	case methodBasicGet:
+		r.short()
+		name, noAck := r.shortstr(), r.bits()[0]
+		if r.err != nil {
+			return nil
+		}
+		return ch.get(name, noAck)
+	case methodBasicAck:
+		tag, multiple := r.longlong(), r.bits()[0]
+		return ch.settle(id, tag, multiple, false)
+	case methodBasicReject:
+		tag, requeue := r.longlong(), r.bits()[0]
+		return ch.settle(id, tag, false, requeue)
+	case methodBasicNack:
+		tag, bits := r.longlong(), r.bits()
+		return ch.settle(id, tag, bits[0], bits[1])
+	case methodBasicRecover, methodBasicRecoverAsync:
+		r.bits() // requeue, deliveries are always requeued
+		ch.requeue(ch.tags(0, true))
+		if id == methodBasicRecover {
+			ch.conn.method(ch.id, methodBasicRecoverOk, nil)
+		}
+
+	case methodConfirmSelect:
+		noWait := r.bits()[0]
+		ch.confirm = true
+		ch.reply(noWait, methodConfirmSelectOk, nil)
+	default:
+		return loopErrorf(amqpDriver.NotImplemented, "method %d.%d is not supported by the loopback broker", id>>16, id&0xffff)
+	}
+	return nil
+}
+
+// reply sends the reply of a method, unless the client asked for none.
+func (ch *loopChannel) reply(noWait bool, id uint32, args func(w *wireWriter)) {
+	if !noWait {
+		ch.conn.method(ch.id, id, args)
+	}
+}
+
+// fail closes the channel with an exception, releasing its consumers and deliveries.
+func (ch *loopChannel) fail(err *loopError, id uint32) {
+	ch.release()
+	ch.closing = true
+	ch.conn.method(ch.id, methodChannelClose, func(w *wireWriter) {
+		w.short(err.code)
+		w.shortstr(err.text)
+		w.short(uint16(id >> 16))
+		w.short(uint16(id))
+	})
+}
+
+// release cancels the consumers of the channel and requeues its unacknowledged deliveries.
+func (ch *loopChannel) release() {
+	for _, consumer := range ch.consumers {
+		ch.conn.broker.cancel(consumer)
+	}
+	ch.requeue(ch.tags(0, true))
+	ch.publishing = nil
+}
+
+// content receives the header and body frames of the message being published.
+func (ch *loopChannel) content(frame wireFrame) *loopError {
+	p := ch.publishing
+	if p == nil || (frame.typ == frameHeader) == (p.header != nil) {
+		return loopErrorf(amqpDriver.UnexpectedFrame, "unexpected content frame on channel %d", ch.id)
+	}
+	if frame.typ == frameHeader {
+		size, headers, err := contentHeaders(frame.payload)
+		if err != nil {
+			return loopErrorf(amqpDriver.FrameError, "%s", err)
+		}
+		p.header, p.size, p.headers = frame.payload, size, headers
+	} else {
+		p.body = append(p.body, frame.payload...)
+	}
+	if uint64(len(p.body)) >= p.size {
+		ch.publishing = nil
+		if err := ch.publish(p); err != nil {
+			ch.fail(err, methodBasicPublish)
+		}
+	}
+	return nil
+}
+
+// publish routes a received message to the queues, returns it if mandatory and unroutable, and
+// confirms it in confirm mode.
+func (ch *loopChannel) publish(p *loopPublish) *loopError {
+	b := ch.conn.broker
+	exchange, ok := b.exchanges[p.exchange]
+	if !ok {
+		return loopErrorf(amqpDriver.NotFound, "no exchange '%s' in vhost '/'", p.exchange)
+	}
+	if exchange.internal {
+		return loopErrorf(amqpDriver.AccessRefused, "cannot publish to internal exchange '%s' in vhost '/'", p.exchange)
+	}
+
+	routed := make(map[*loopQueue]bool)
+	b.route(exchange, p.key, p.headers, routed, make(map[*loopExchange]bool))
+	message := &loopMessage{exchange: p.exchange, routingKey: p.key, header: p.header, body: p.body}
+	if len(routed) == 0 && p.mandatory {
+		ch.conn.content(ch.id, methodBasicReturn, func(w *wireWriter) {
+			w.short(amqpDriver.NoRoute)
+			w.shortstr("NO_ROUTE")
+			w.shortstr(p.exchange)
+			w.shortstr(p.key)
+		}, message)
+	}
+	if ch.confirm {
+		ch.publishTag++
+		tag := ch.publishTag
+		ch.conn.method(ch.id, methodBasicAck, func(w *wireWriter) {
+			w.longlong(tag)
+			w.bits(false)
+		})
+	}
+	for q := range routed {
+		q.messages = append(q.messages, message)
+		b.dispatch(q)
+	}
+	return nil
+}
+
+func (ch *loopChannel) consume(queueName string, tag string, noAck bool, exclusive bool, noWait bool) *loopError {
+	b := ch.conn.broker
+	q, err := b.ownedQueue(ch.conn, queueName)
+	if err != nil {
+		return err
+	}
+	if tag == "" {
+		tag = "amq.ctag-" + randomHex(11)
+	}
+	if _, ok := ch.consumers[tag]; ok {
+		return loopErrorf(amqpDriver.NotAllowed, "attempt to reuse consumer tag '%s'", tag)
+	}
+	if q.exclusiveConsumer != nil || (exclusive && len(q.consumers) > 0) {
+		return loopErrorf(amqpDriver.AccessRefused, "queue '%s' in vhost '/' in exclusive use", queueName)
+	}
+
+	consumer := &loopConsumer{tag: tag, channel: ch, queue: q, noAck: noAck, prefetch: ch.prefetch}
+	if exclusive {
+		q.exclusiveConsumer = consumer
+	}
+	ch.consumers[tag] = consumer
+	q.consumers = append(q.consumers, consumer)
+	q.consumed = true
+	ch.reply(noWait, methodBasicConsumeOk, func(w *wireWriter) {
+		w.shortstr(tag)
+	})
+	b.dispatch(q)
+	return nil
+}
+
+func (ch *loopChannel) get(queueName string, noAck bool) *loopError {
+	q, err := ch.conn.broker.ownedQueue(ch.conn, queueName)
+	if err != nil {
+		return err
+	}
+	if len(q.messages) == 0 {
+		ch.conn.method(ch.id, methodBasicGetEmpty, func(w *wireWriter) {
+			w.shortstr("")
+		})
+		return nil
+	}
+
+	message := q.messages[0]
+	q.messages = q.messages[1:]
+	ch.deliveryTag++
+	tag := ch.deliveryTag
+	if !noAck {
+		ch.unacked[tag] = &loopDelivery{message: message, queue: q}
+	}
+	ch.conn.content(ch.id, methodBasicGetOk, func(w *wireWriter) {
+		w.longlong(tag)
+		w.bits(message.redelivered)
+		w.shortstr(message.exchange)
+		w.shortstr(message.routingKey)
+		w.long(uint32(len(q.messages)))
+	}, message)
+	return nil
+}
+
+// deliver sends a message to a consumer of the channel.
+func (ch *loopChannel) deliver(consumer *loopConsumer, message *loopMessage) {
+	ch.deliveryTag++
+	tag := ch.deliveryTag
+	if !consumer.noAck {
+		ch.unacked[tag] = &loopDelivery{message: message, queue: consumer.queue, consumer: consumer}
+		consumer.unacked++
+	}
+	ch.conn.content(ch.id, methodBasicDeliver, func(w *wireWriter) {
+		w.shortstr(consumer.tag)
+		w.longlong(tag)
+		w.bits(message.redelivered)
+		w.shortstr(message.exchange)
+		w.shortstr(message.routingKey)
+	}, message)
+}
+
+// settle acknowledges or rejects deliveries of the channel.
+func (ch *loopChannel) settle(id uint32, tag uint64, multiple bool, requeue bool) *loopError {
+	tags := ch.tags(tag, multiple)
+	if len(tags) == 0 && !(multiple && tag == 0) {
+		return loopErrorf(amqpDriver.PreconditionFailed, "unknown delivery tag %d", tag)
+	}
+	if id == methodBasicAck || !requeue {
+		for _, t := range tags {
+			ch.forget(t)
+		}
+		ch.dispatch(nil)
+		return nil
+	}
+	ch.requeue(tags)
+	return nil
+}
+
+// tags returns the unacknowledged delivery tags settled by a tag, all of them for multiple 0.
+func (ch *loopChannel) tags(tag uint64, multiple bool) []uint64 {
+	if !multiple {
+		if _, ok := ch.unacked[tag]; ok {
+			return []uint64{tag}
+		}
+		return nil
+	}
+	var tags []uint64
+	for t := range ch.unacked {
+		if tag == 0 || t <= tag {
+			tags = append(tags, t)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
+// forget settles a delivery, returning it.
+func (ch *loopChannel) forget(tag uint64) *loopDelivery {
+	d := ch.unacked[tag]
+	delete(ch.unacked, tag)
+	if d.consumer != nil {
+		d.consumer.unacked--
+	}
+	return d
+}
+
+// requeue puts deliveries back at the head of their queues in their original order, flagged redelivered.
+func (ch *loopChannel) requeue(tags []uint64) {
+	requeued := make(map[*loopQueue][]*loopMessage)
+	for _, tag := range tags {
+		d := ch.forget(tag)
+		if d.queue.deleted {
+			continue
+		}
+		message := *d.message
+		message.redelivered = true
+		requeued[d.queue] = append(requeued[d.queue], &message)
+	}
+	for q, messages := range requeued {
+		q.messages = append(messages, q.messages...)
+	}
+	ch.dispatch(requeued)
+}
+
+// dispatch delivers messages to the consumers of the channel which got room for them, and to the
+// consumers of the given queues.
+func (ch *loopChannel) dispatch(queues map[*loopQueue][]*loopMessage) {
+	for q := range queues {
+		ch.conn.broker.dispatch(q)
+	}
+	for _, consumer := range ch.consumers {
+		ch.conn.broker.dispatch(consumer.queue)
+	}
+}
+
+// ready reports whether the consumer has room for another delivery.
+func (consumer *loopConsumer) ready() bool {
+	ch := consumer.channel
+	if ch.closing {
+		return false
+	}
+	if consumer.noAck {
+		return true
+	}
+	return (consumer.prefetch == 0 || consumer.unacked < consumer.prefetch) &&
+		(ch.globalPrefetch == 0 || len(ch.unacked) < ch.globalPrefetch)
+}
+
+// dispatch delivers the messages of a queue to its consumers in turn, as long as they have room for them.
+func (b *loopbackBroker) dispatch(q *loopQueue) {
+	for len(q.messages) > 0 && !q.deleted {
+		var consumer *loopConsumer
+		for i := range q.consumers {
+			candidate := q.consumers[(q.next+i)%len(q.consumers)]
+			if candidate.ready() {
+				consumer = candidate
+				q.next = (q.next + i + 1) % len(q.consumers)
+				break
+			}
+		}
+		if consumer == nil {
+			return
+		}
+		message := q.messages[0]
+		q.messages = q.messages[1:]
+		consumer.channel.deliver(consumer, message)
+	}
+}
+
+// route collects the queues a message published to an exchange is routed to, following exchange to exchange
+// bindings and the alternate exchange of an exchange routing the message nowhere.
+func (b *loopbackBroker) route(exchange *loopExchange, key string, headers amqpDriver.Table,
+	routed map[*loopQueue]bool, visited map[*loopExchange]bool,
+) {
+	if visited[exchange] {
+		return
+	}
+	visited[exchange] = true
+	if exchange.name == "" {
+		if q, ok := b.queues[key]; ok {
+			routed[q] = true
+		}
+		return
+	}
+
+	before := len(routed)
+	for _, binding := range exchange.bindings {
+		if !binding.matches(exchange.kind, key, headers) {
+			continue
+		}
+		if binding.queue != nil {
+			routed[binding.queue] = true
+		} else {
+			b.route(binding.exchange, key, headers, routed, visited)
+		}
+	}
+	if len(routed) > before {
+		return
+	}
+	if name, ok := exchange.args["alternate-exchange"].(string); ok {
+		if alternate, ok := b.exchanges[name]; ok {
+			b.route(alternate, key, headers, routed, visited)
+		}
+	}
+}
+
+func (binding *loopBinding) matches(kind string, key string, headers amqpDriver.Table) bool {
+	switch kind {
+	case amqpDriver.ExchangeFanout:
+		return true
+	case amqpDriver.ExchangeTopic:
+		return binding.pattern.matches(key)
+	case amqpDriver.ExchangeHeaders:
+		return headersMatch(binding.args, headers)
+	default:
+		return binding.key == key
+	}
+}
+
+// headersMatch tells whether message headers match the arguments of a headers exchange binding.
+func headersMatch(args amqpDriver.Table, headers amqpDriver.Table) bool {
+	mode, _ := args["x-match"].(string)
+	anyMatch := mode == "any" || mode == "any-with-x"
+	withX := mode == "all-with-x" || mode == "any-with-x"
+	for name, expected := range args {
+		if name == "x-match" || (!withX && len(name) > 1 && name[:2] == "x-") {
+			continue
+		}
+		actual, ok := headers[name]
+		matched := ok && (expected == nil || fmt.Sprint(actual) == fmt.Sprint(expected))
+		if matched && anyMatch {
+			return true
+		}
+		if !matched && !anyMatch {
+			return false
+		}
+	}
+	return !anyMatch
+}
+
+func (b *loopbackBroker) declareExchange(name string, kind string, passive bool, durable bool,
+	autoDelete bool, internal bool, args amqpDriver.Table,
+) *loopError {
+	exchange, ok := b.exchanges[name]
+	if passive {
+		if !ok {
+			return loopErrorf(amqpDriver.NotFound, "no exchange '%s' in vhost '/'", name)
+		}
+		return nil
+	}
+	if name == "" {
+		return loopErrorf(amqpDriver.AccessRefused, "operation not permitted on the default exchange")
+	}
+	switch kind {
+	case amqpDriver.ExchangeDirect, amqpDriver.ExchangeFanout, amqpDriver.ExchangeTopic, amqpDriver.ExchangeHeaders:
+	case delayedMessageKind, consistentHashKind:
+		return loopErrorf(amqpDriver.NotImplemented, "exchange type '%s' is not supported by the loopback broker", kind)
+	default:
+		return loopErrorf(amqpDriver.CommandInvalid, "invalid exchange type '%s'", kind)
+	}
+
+	if ok {
+		if exchange.kind != kind || exchange.durable != durable || exchange.autoDelete != autoDelete ||
+			exchange.internal != internal || !equalArgs(exchange.args, args) {
+			return loopErrorf(amqpDriver.PreconditionFailed, "inequivalent arg for exchange '%s' in vhost '/'", name)
+		}
+		return nil
+	}
+	b.exchanges[name] = &loopExchange{
+		name:       name,
+		kind:       kind,
+		durable:    durable,
+		autoDelete: autoDelete,
+		internal:   internal,
+		args:       args,
+	}
+	return nil
+}
+
+func (b *loopbackBroker) deleteExchangeNamed(name string, ifUnused bool) *loopError {
+	exchange, ok := b.exchanges[name]
+	if !ok {
+		return nil
+	}
+	if name == "" {
+		return loopErrorf(amqpDriver.AccessRefused, "operation not permitted on the default exchange")
+	}
+	if ifUnused && len(exchange.bindings) > 0 {
+		return loopErrorf(amqpDriver.PreconditionFailed, "exchange '%s' in vhost '/' in use", name)
+	}
+	b.deleteExchange(exchange)
+	return nil
+}
+
+// deleteExchange removes an exchange and the bindings to it.
+func (b *loopbackBroker) deleteExchange(exchange *loopExchange) {
+	delete(b.exchanges, exchange.name)
+	for _, source := range b.exchanges {
+		source.removeBindings(func(binding *loopBinding) bool {
+			return binding.exchange == exchange
+		})
+		b.autoDeleteExchange(source)
+	}
+}
+
+// autoDeleteExchange removes an auto delete exchange which lost its last binding.
+func (b *loopbackBroker) autoDeleteExchange(exchange *loopExchange) {
+	if exchange.autoDelete && exchange.bound && len(exchange.bindings) == 0 {
+		b.deleteExchange(exchange)
+	}
+}
+
+func (exchange *loopExchange) removeBindings(remove func(binding *loopBinding) bool) {
+	kept := exchange.bindings[:0]
+	for _, binding := range exchange.bindings {
+		if !remove(binding) {
+			kept = append(kept, binding)
+		}
+	}
+	exchange.bindings = kept
+}
+
+// bind adds a binding to the exchange unless an identical one exists.
+func (exchange *loopExchange) bind(binding *loopBinding) {
+	for _, existing := range exchange.bindings {
+		if existing.queue == binding.queue && existing.exchange == binding.exchange &&
+			existing.key == binding.key && equalArgs(existing.args, binding.args) {
+			return
+		}
+	}
+	if exchange.kind == amqpDriver.ExchangeTopic {
+		binding.pattern = topicPattern(binding.key)
+	}
+	exchange.bindings = append(exchange.bindings, binding)
+	exchange.bound = true
+}
+
+// boundExchange returns an exchange queues and exchanges can be bound to.
+func (b *loopbackBroker) boundExchange(name string) (*loopExchange, *loopError) {
+	exchange, ok := b.exchanges[name]
+	if !ok {
+		return nil, loopErrorf(amqpDriver.NotFound, "no exchange '%s' in vhost '/'", name)
+	}
+	if name == "" {
+		return nil, loopErrorf(amqpDriver.AccessRefused, "operation not permitted on the default exchange")
+	}
+	return exchange, nil
+}
+
+func (b *loopbackBroker) bindExchange(destinationName string, sourceName string, key string, args amqpDriver.Table) *loopError {
+	destination, err := b.boundExchange(destinationName)
+	if err != nil {
+		return err
+	}
+	source, err := b.boundExchange(sourceName)
+	if err != nil {
+		return err
+	}
+	source.bind(&loopBinding{exchange: destination, key: key, args: args})
+	return nil
+}
+
+func (b *loopbackBroker) unbindExchange(destinationName string, sourceName string, key string, args amqpDriver.Table) {
+	source, ok := b.exchanges[sourceName]
+	if !ok {
+		return
+	}
+	source.removeBindings(func(binding *loopBinding) bool {
+		return binding.exchange != nil && binding.exchange.name == destinationName &&
+			binding.key == key && equalArgs(binding.args, args)
+	})
+	b.autoDeleteExchange(source)
+}
+
+func (b *loopbackBroker) declareQueue(conn *loopConn, name string, passive bool, durable bool,
+	exclusive bool, autoDelete bool, args amqpDriver.Table,
+) (*loopQueue, *loopError) {
+	if name == "" && !passive {
+		name = "amq.gen-" + randomHex(11)
+	}
+	q, ok := b.queues[name]
+	if ok && q.owner != nil && q.owner != conn {
+		return nil, loopErrorf(amqpDriver.ResourceLocked, "cannot obtain exclusive access to locked queue '%s' in vhost '/'", name)
+	}
+	if passive {
+		if !ok {
+			return nil, loopErrorf(amqpDriver.NotFound, "no queue '%s' in vhost '/'", name)
+		}
+		return q, nil
+	}
+	if queueType, _ := args["x-queue-type"].(string); queueType == queueTypeStream {
+		return nil, loopErrorf(amqpDriver.NotImplemented, "stream queues are not supported by the loopback broker")
+	}
+
+	if ok {
+		if q.durable != durable || q.exclusive != exclusive || q.autoDelete != autoDelete || !equalArgs(q.args, args) {
+			return nil, loopErrorf(amqpDriver.PreconditionFailed, "inequivalent arg for queue '%s' in vhost '/'", name)
+		}
+		return q, nil
+	}
+	q = &loopQueue{name: name, durable: durable, exclusive: exclusive, autoDelete: autoDelete, args: args}
+	if exclusive {
+		q.owner = conn
+	}
+	b.queues[name] = q
+	return q, nil
+}
+
+// ownedQueue returns a queue the connection may use.
+func (b *loopbackBroker) ownedQueue(conn *loopConn, name string) (*loopQueue, *loopError) {
+	q, ok := b.queues[name]
+	if !ok {
+		return nil, loopErrorf(amqpDriver.NotFound, "no queue '%s' in vhost '/'", name)
+	}
+	if q.owner != nil && q.owner != conn {
+		return nil, loopErrorf(amqpDriver.ResourceLocked, "cannot obtain exclusive access to locked queue '%s' in vhost '/'", name)
+	}
+	return q, nil
+}
+
+func (b *loopbackBroker) bindQueue(conn *loopConn, queueName string, exchangeName string, key string, args amqpDriver.Table) *loopError {
+	q, err := b.ownedQueue(conn, queueName)
+	if err != nil {
+		return err
+	}
+	exchange, err := b.boundExchange(exchangeName)
+	if err != nil {
+		return err
+	}
+	exchange.bind(&loopBinding{queue: q, key: key, args: args})
+	return nil
+}
+
+func (b *loopbackBroker) unbindQueue(queueName string, exchangeName string, key string, args amqpDriver.Table) {
+	exchange, ok := b.exchanges[exchangeName]
+	if !ok {
+		return
+	}
+	exchange.removeBindings(func(binding *loopBinding) bool {
+		return binding.queue != nil && binding.queue.name == queueName && binding.key == key && equalArgs(binding.args, args)
+	})
+	b.autoDeleteExchange(exchange)
+}
+
+func (b *loopbackBroker) deleteQueueNamed(conn *loopConn, name string, ifUnused bool, ifEmpty bool) (int, *loopError) {
+	if _, ok := b.queues[name]; !ok {
+		return 0, nil
+	}
+	q, err := b.ownedQueue(conn, name)
+	if err != nil {
+		return 0, err
+	}
+	if ifUnused && len(q.consumers) > 0 {
+		return 0, loopErrorf(amqpDriver.PreconditionFailed, "queue '%s' in vhost '/' in use", name)
+	}
+	if ifEmpty && len(q.messages) > 0 {
+		return 0, loopErrorf(amqpDriver.PreconditionFailed, "queue '%s' in vhost '/' is not empty", name)
+	}
+	count := len(q.messages)
+	b.deleteQueue(q)
+	return count, nil
+}
+
+// deleteQueue removes a queue and its bindings, notifying its consumers they were cancelled.
+func (b *loopbackBroker) deleteQueue(q *loopQueue) {
+	q.deleted = true
+	delete(b.queues, q.name)
+	for _, consumer := range q.consumers {
+		ch := consumer.channel
+		delete(ch.consumers, consumer.tag)
+		if !ch.closing {
+			ch.conn.method(ch.id, methodBasicCancel, func(w *wireWriter) {
+				w.shortstr(consumer.tag)
+				w.bits(true)
+			})
+		}
+	}
+	q.consumers = nil
+	for _, exchange := range b.exchanges {
+		exchange.removeBindings(func(binding *loopBinding) bool {
+			return binding.queue == q
+		})
+		b.autoDeleteExchange(exchange)
+	}
+}
+
+// cancel stops a consumer, removing its queue if it was the last consumer of an auto delete queue.
+func (b *loopbackBroker) cancel(consumer *loopConsumer) {
+	delete(consumer.channel.consumers, consumer.tag)
+	q := consumer.queue
+	for i, c := range q.consumers {
+		if c == consumer {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.exclusiveConsumer == consumer {
+		q.exclusiveConsumer = nil
+	}
+	if q.autoDelete && q.consumed && len(q.consumers) == 0 && !q.deleted {
+		b.deleteQueue(q)
+	}
+}
+
+// equalArgs compares the arguments of two declarations or bindings, nil and empty being equal.
+func equalArgs(a amqpDriver.Table, b amqpDriver.Table) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,251 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// dialLoopback connects the driver to a loopback broker of its own and opens a channel.
+func dialLoopback(t *testing.T) *amqpDriver.Channel {
+	t.Helper()
+
+	url, err := (&RootModule{}).loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := amqpDriver.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func publish(t *testing.T, ch *amqpDriver.Channel, exchange string, key string, publishing amqpDriver.Publishing) {
+	t.Helper()
+
+	if err := ch.PublishWithContext(context.Background(), exchange, key, false, false, publishing); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive waits for a delivery, failing the test after a second.
+func receive(t *testing.T, deliveries <-chan amqpDriver.Delivery) amqpDriver.Delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+		return amqpDriver.Delivery{}
+	}
+}
+
+// nothingReceived fails the test if a delivery arrives within 50ms.
+func nothingReceived(t *testing.T, deliveries <-chan amqpDriver.Delivery) {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery %q", d.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLoopbackRouting(t *testing.T) {
+	t.Parallel()
+
+	ch := dialLoopback(t)
+	bindings := []struct {
+		exchange string
+		kind     string
+		queue    string
+		key      string
+		args     amqpDriver.Table
+	}{
+		{"orders.direct", amqpDriver.ExchangeDirect, "direct-created", "created", nil},
+		{"orders.direct", amqpDriver.ExchangeDirect, "direct-paid", "paid", nil},
+		{"orders.topic", amqpDriver.ExchangeTopic, "topic-eu", "orders.eu.*", nil},
+		{"orders.topic", amqpDriver.ExchangeTopic, "topic-all", "orders.#", nil},
+		{"orders.topic", amqpDriver.ExchangeTopic, "topic-paid", "*.*.paid", nil},
+		{"orders.headers", amqpDriver.ExchangeHeaders, "headers-all", "", amqpDriver.Table{"x-match": "all", "region": "eu", "tier": "gold"}},
+		{"orders.headers", amqpDriver.ExchangeHeaders, "headers-any", "", amqpDriver.Table{"x-match": "any", "region": "eu", "tier": "gold"}},
+		{"orders.fanout", amqpDriver.ExchangeFanout, "fanout-a", "", nil},
+		{"orders.fanout", amqpDriver.ExchangeFanout, "fanout-b", "", nil},
+	}
+	for _, b := range bindings {
+		if err := ch.ExchangeDeclare(b.exchange, b.kind, false, false, false, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ch.QueueDeclare(b.queue, false, false, false, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.QueueBind(b.queue, b.key, b.exchange, false, b.args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		exchange string
+		key      string
+		headers  amqpDriver.Table
+		routed   []string
+	}{
+		{"direct match", "orders.direct", "created", nil, []string{"direct-created"}},
+		{"direct no match", "orders.direct", "cancelled", nil, nil},
+		{"default exchange", "", "direct-paid", nil, []string{"direct-paid"}},
+		{"topic single word", "orders.topic", "orders.eu.paid", nil, []string{"topic-eu", "topic-all", "topic-paid"}},
+		{"topic multiple words", "orders.topic", "orders.us.east.created", nil, []string{"topic-all"}},
+		{"topic zero words", "orders.topic", "orders", nil, []string{"topic-all"}},
+		{"topic no match", "orders.topic", "invoices.eu.created", nil, nil},
+		{"headers all", "orders.headers", "", amqpDriver.Table{"region": "eu", "tier": "gold"}, []string{"headers-all", "headers-any"}},
+		{"headers any", "orders.headers", "", amqpDriver.Table{"region": "eu", "tier": "silver"}, []string{"headers-any"}},
+		{"headers none", "orders.headers", "", amqpDriver.Table{"region": "us"}, nil},
+		{"fanout", "orders.fanout", "ignored", nil, []string{"fanout-a", "fanout-b"}},
+	}
+	for _, tt := range tests {
+		publish(t, ch, tt.exchange, tt.key, amqpDriver.Publishing{Headers: tt.headers, Body: []byte(tt.name)})
+
+		routed := map[string]bool{}
+		for _, name := range tt.routed {
+			routed[name] = true
+		}
+		for _, b := range bindings {
+			d, ok, err := ch.Get(b.queue, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != routed[b.queue] {
+				t.Errorf("%s: routed to %s = %v, expected %v", tt.name, b.queue, ok, routed[b.queue])
+			}
+			if ok && string(d.Body) != tt.name {
+				t.Errorf("%s: %s got %q", tt.name, b.queue, d.Body)
+			}
+			// A queue bound twice got the message twice.
+			for ok {
+				if _, ok, err = ch.Get(b.queue, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func TestLoopbackRequeue(t *testing.T) {
+	t.Parallel()
+
+	ch := dialLoopback(t)
+	if _, err := ch.QueueDeclare("requeue", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := ch.Consume("requeue", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ch, "", "requeue", amqpDriver.Publishing{Body: []byte("poison")})
+
+	d := receive(t, deliveries)
+	if d.Redelivered {
+		t.Error("first delivery flagged as redelivered")
+	}
+	if err = d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	d = receive(t, deliveries)
+	if !d.Redelivered || string(d.Body) != "poison" {
+		t.Errorf("requeued delivery %q redelivered=%v, expected poison redelivered", d.Body, d.Redelivered)
+	}
+	if err = d.Reject(false); err != nil {
+		t.Fatal(err)
+	}
+	nothingReceived(t, deliveries)
+	q, err := ch.QueueDeclarePassive("requeue", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Messages != 0 {
+		t.Errorf("%d messages left after the reject, expected 0", q.Messages)
+	}
+}
+
+func TestLoopbackPrefetch(t *testing.T) {
+	t.Parallel()
+
+	ch := dialLoopback(t)
+	if _, err := ch.QueueDeclare("prefetch", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Qos(2, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		publish(t, ch, "", "prefetch", amqpDriver.Publishing{Body: []byte{byte('0' + i)}})
+	}
+	deliveries, err := ch.Consume("prefetch", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, deliveries)
+	receive(t, deliveries)
+	nothingReceived(t, deliveries)
+
+	if err = first.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, deliveries); string(d.Body) != "2" {
+		t.Errorf("got %q once a delivery was acknowledged, expected 2", d.Body)
+	}
+	nothingReceived(t, deliveries)
+}
+
+func TestLoopbackPublisherConfirms(t *testing.T) {
+	t.Parallel()
+
+	ch := dialLoopback(t)
+	if _, err := ch.QueueDeclare("confirms", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqpDriver.Confirmation, 4))
+	returns := ch.NotifyReturn(make(chan amqpDriver.Return, 1))
+
+	publish(t, ch, "", "confirms", amqpDriver.Publishing{Body: []byte("a")})
+	publish(t, ch, "", "confirms", amqpDriver.Publishing{Body: []byte("b")})
+	err := ch.PublishWithContext(context.Background(), "", "missing", true, false, amqpDriver.Publishing{Body: []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-returns:
+		if r.ReplyCode != amqpDriver.NoRoute || string(r.Body) != "c" {
+			t.Errorf("returned %q with %d, expected c with %d", r.Body, r.ReplyCode, amqpDriver.NoRoute)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the unroutable mandatory message was not returned")
+	}
+	for tag := uint64(1); tag <= 3; tag++ {
+		select {
+		case c := <-confirms:
+			if c.DeliveryTag != tag || !c.Ack {
+				t.Errorf("confirmation %d ack=%v, expected %d acknowledged", c.DeliveryTag, c.Ack, tag)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("publish %d not confirmed", tag)
+		}
+	}
+}
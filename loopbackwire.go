@@ -0,0 +1,377 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQP 0-9-1 frame types.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+const (
+	wireProtocolHeader = "AMQP\x00\x00\x09\x01"
+	wireFrameMax       = 128 * 1024
+	wireFrameOverhead  = 8
+)
+
+// Methods of the AMQP 0-9-1 classes handled by the loopback broker, as class id << 16 | method id.
+const (
+	methodConnectionStart        = 10<<16 | 10
+	methodConnectionStartOk      = 10<<16 | 11
+	methodConnectionTune         = 10<<16 | 30
+	methodConnectionTuneOk       = 10<<16 | 31
+	methodConnectionOpen         = 10<<16 | 40
+	methodConnectionOpenOk       = 10<<16 | 41
+	methodConnectionClose        = 10<<16 | 50
+	methodConnectionCloseOk      = 10<<16 | 51
+	methodConnectionUpdateSecret = 10<<16 | 70
+	methodConnectionSecretOk     = 10<<16 | 71
+
+	methodChannelOpen    = 20<<16 | 10
+	methodChannelOpenOk  = 20<<16 | 11
+	methodChannelFlow    = 20<<16 | 20
+	methodChannelFlowOk  = 20<<16 | 21
+	methodChannelClose   = 20<<16 | 40
+	methodChannelCloseOk = 20<<16 | 41
+
+	methodExchangeDeclare   = 40<<16 | 10
+	methodExchangeDeclareOk = 40<<16 | 11
+	methodExchangeDelete    = 40<<16 | 20
+	methodExchangeDeleteOk  = 40<<16 | 21
+	methodExchangeBind      = 40<<16 | 30
+	methodExchangeBindOk    = 40<<16 | 31
+	methodExchangeUnbind    = 40<<16 | 40
+	methodExchangeUnbindOk  = 40<<16 | 51
+
+	methodQueueDeclare   = 50<<16 | 10
+	methodQueueDeclareOk = 50<<16 | 11
+	methodQueueBind      = 50<<16 | 20
+	methodQueueBindOk    = 50<<16 | 21
+	methodQueuePurge     = 50<<16 | 30
+	methodQueuePurgeOk   = 50<<16 | 31
+	methodQueueDelete    = 50<<16 | 40
+	methodQueueDeleteOk  = 50<<16 | 41
+	methodQueueUnbind    = 50<<16 | 50
+	methodQueueUnbindOk  = 50<<16 | 51
+
+	methodBasicQos          = 60<<16 | 10
+	methodBasicQosOk        = 60<<16 | 11
+	methodBasicConsume      = 60<<16 | 20
+	methodBasicConsumeOk    = 60<<16 | 21
+	methodBasicCancel       = 60<<16 | 30
+	methodBasicCancelOk     = 60<<16 | 31
+	methodBasicPublish      = 60<<16 | 40
+	methodBasicReturn       = 60<<16 | 50
+	methodBasicDeliver      = 60<<16 | 60
+	methodBasicGet          = 60<<16 | 70
+	methodBasicGetOk        = 60<<16 | 71
+	methodBasicGetEmpty     = 60<<16 | 72
+	methodBasicAck          = 60<<16 | 80
+	methodBasicReject       = 60<<16 | 90
+	methodBasicRecoverAsync = 60<<16 | 100
+	methodBasicRecover      = 60<<16 | 110
+	methodBasicRecoverOk    = 60<<16 | 111
+	methodBasicNack         = 60<<16 | 120
+
+	methodConfirmSelect   = 85<<16 | 10
+	methodConfirmSelectOk = 85<<16 | 11
+)
+
+var errShortFrame = errors.New("malformed frame")
+
+// wireFrame is a frame read from or written to the wire.
+type wireFrame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readWireFrame(r *bufio.Reader) (wireFrame, error) {
+	var head [7]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return wireFrame{}, err
+	}
+	size := binary.BigEndian.Uint32(head[3:])
+	if size > wireFrameMax {
+		return wireFrame{}, fmt.Errorf("frame of %d bytes exceeds the frame max", size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return wireFrame{}, err
+	}
+	if payload[size] != frameEnd {
+		return wireFrame{}, errShortFrame
+	}
+	return wireFrame{typ: head[0], channel: binary.BigEndian.Uint16(head[1:3]), payload: payload[:size]}, nil
+}
+
+func (f wireFrame) encode() []byte {
+	encoded := make([]byte, 7, 7+len(f.payload)+1)
+	encoded[0] = f.typ
+	binary.BigEndian.PutUint16(encoded[1:3], f.channel)
+	binary.BigEndian.PutUint32(encoded[3:7], uint32(len(f.payload)))
+	encoded = append(encoded, f.payload...)
+	return append(encoded, frameEnd)
+}
+
+// wireReader decodes the fields of a frame payload. The first error is kept and stops decoding.
+type wireReader struct {
+	buf []byte
+	err error
+}
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errShortFrame
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *wireReader) octet() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *wireReader) short() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *wireReader) long() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wireReader) longlong() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *wireReader) shortstr() string {
+	return string(r.next(int(r.octet())))
+}
+
+func (r *wireReader) longstr() []byte {
+	return r.next(int(r.long()))
+}
+
+// bits reads an octet of packed bit fields, the first field being the lowest bit.
+func (r *wireReader) bits() []bool {
+	b := r.octet()
+	bits := make([]bool, 8)
+	for i := range bits {
+		bits[i] = b&(1<<uint(i)) != 0
+	}
+	return bits
+}
+
+func (r *wireReader) table() amqpDriver.Table {
+	inner := &wireReader{buf: r.longstr()}
+	table := amqpDriver.Table{}
+	for inner.err == nil && len(inner.buf) > 0 {
+		name := inner.shortstr()
+		table[name] = inner.field()
+	}
+	if r.err == nil {
+		r.err = inner.err
+	}
+	return table
+}
+
+func (r *wireReader) field() interface{} {
+	switch typ := r.octet(); typ {
+	case 't':
+		return r.octet() != 0
+	case 'b':
+		return int8(r.octet())
+	case 'B':
+		return r.octet()
+	case 's':
+		return int16(r.short())
+	case 'u':
+		return r.short()
+	case 'I':
+		return int32(r.long())
+	case 'i':
+		return r.long()
+	case 'l':
+		return int64(r.longlong())
+	case 'f':
+		return math.Float32frombits(r.long())
+	case 'd':
+		return math.Float64frombits(r.longlong())
+	case 'D':
+		return amqpDriver.Decimal{Scale: r.octet(), Value: int32(r.long())}
+	case 'S':
+		return string(r.longstr())
+	case 'x':
+		return append([]byte{}, r.longstr()...)
+	case 'A':
+		inner := &wireReader{buf: r.longstr()}
+		var array []interface{}
+		for inner.err == nil && len(inner.buf) > 0 {
+			array = append(array, inner.field())
+		}
+		if r.err == nil {
+			r.err = inner.err
+		}
+		return array
+	case 'T':
+		return time.Unix(int64(r.longlong()), 0)
+	case 'F':
+		return r.table()
+	case 'V':
+		return nil
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unknown field type %q", typ)
+		}
+		return nil
+	}
+}
+
+// wireWriter encodes the fields of a frame payload.
+type wireWriter struct {
+	bytes.Buffer
+}
+
+func (w *wireWriter) octet(b byte) {
+	_ = w.WriteByte(b)
+}
+
+func (w *wireWriter) short(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	_, _ = w.Write(b[:])
+}
+
+func (w *wireWriter) long(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	_, _ = w.Write(b[:])
+}
+
+func (w *wireWriter) longlong(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	_, _ = w.Write(b[:])
+}
+
+func (w *wireWriter) shortstr(s string) {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	w.octet(byte(len(s)))
+	_, _ = w.WriteString(s)
+}
+
+func (w *wireWriter) longstr(b []byte) {
+	w.long(uint32(len(b)))
+	_, _ = w.Write(b)
+}
+
+// bits writes packed bit fields, the first field being the lowest bit.
+func (w *wireWriter) bits(bits ...bool) {
+	var b byte
+	for i, set := range bits {
+		if set {
+			b |= 1 << uint(i)
+		}
+	}
+	w.octet(b)
+}
+
+func (w *wireWriter) table(table amqpDriver.Table) {
+	var inner wireWriter
+	for name, value := range table {
+		inner.shortstr(name)
+		inner.field(value)
+	}
+	w.longstr(inner.Bytes())
+}
+
+func (w *wireWriter) field(v interface{}) {
+	switch value := v.(type) {
+	case bool:
+		w.octet('t')
+		w.bits(value)
+	case string:
+		w.octet('S')
+		w.longstr([]byte(value))
+	case int:
+		w.octet('l')
+		w.longlong(uint64(value))
+	case int64:
+		w.octet('l')
+		w.longlong(uint64(value))
+	case int32:
+		w.octet('I')
+		w.long(uint32(value))
+	case float64:
+		w.octet('d')
+		w.longlong(math.Float64bits(value))
+	case []byte:
+		w.octet('x')
+		w.longstr(value)
+	case amqpDriver.Table:
+		w.octet('F')
+		w.table(value)
+	case []interface{}:
+		var inner wireWriter
+		for _, item := range value {
+			inner.field(item)
+		}
+		w.octet('A')
+		w.longstr(inner.Bytes())
+	default:
+		w.octet('V')
+	}
+}
+
+// contentHeaders returns the body size and the headers of a content header frame payload.
+func contentHeaders(payload []byte) (uint64, amqpDriver.Table, error) {
+	r := &wireReader{buf: payload}
+	r.short() // class id
+	r.short() // weight
+	size := r.longlong()
+	flags := r.short()
+	if flags&(1<<15) != 0 {
+		r.shortstr() // content type
+	}
+	if flags&(1<<14) != 0 {
+		r.shortstr() // content encoding
+	}
+	var headers amqpDriver.Table
+	if flags&(1<<13) != 0 {
+		headers = r.table()
+	}
+	return size, headers, r.err
+}
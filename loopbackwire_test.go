@@ -0,0 +1,180 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+func TestWireFrameRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []wireFrame{
+		{typ: frameMethod, channel: 0, payload: []byte{0, 10, 0, 10}},
+		{typ: frameHeader, channel: 1, payload: []byte{0, 60, 0, 0}},
+		{typ: frameBody, channel: 65535, payload: bytes.Repeat([]byte("x"), 4096)},
+		{typ: frameHeartbeat, channel: 0, payload: []byte{}},
+	}
+	var stream bytes.Buffer
+	for _, frame := range tests {
+		stream.Write(frame.encode())
+	}
+	r := bufio.NewReader(&stream)
+	for _, expected := range tests {
+		frame, err := readWireFrame(r)
+		if err != nil {
+			t.Fatalf("readWireFrame: %v", err)
+		}
+		if frame.typ != expected.typ || frame.channel != expected.channel || !bytes.Equal(frame.payload, expected.payload) {
+			t.Errorf("read frame %d/%d of %d bytes, expected %d/%d of %d bytes",
+				frame.typ, frame.channel, len(frame.payload), expected.typ, expected.channel, len(expected.payload))
+		}
+	}
+}
+
+func TestReadWireFrameErrors(t *testing.T) {
+	t.Parallel()
+
+	valid := wireFrame{typ: frameMethod, channel: 1, payload: []byte{1, 2, 3}}.encode()
+	badEnd := append([]byte{}, valid...)
+	badEnd[len(badEnd)-1] = 0
+	oversized := wireFrame{typ: frameBody, payload: make([]byte, wireFrameMax+1)}.encode()
+
+	tests := map[string][]byte{
+		"truncated header":  valid[:5],
+		"truncated payload": valid[:len(valid)-2],
+		"bad frame end":     badEnd,
+		"oversized":         oversized,
+	}
+	for name, raw := range tests {
+		if _, err := readWireFrame(bufio.NewReader(bytes.NewReader(raw))); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWireFieldsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var w wireWriter
+	w.octet(0xAB)
+	w.short(0xBEEF)
+	w.long(0xDEADBEEF)
+	w.longlong(0x0123456789ABCDEF)
+	w.shortstr("amq.topic")
+	w.longstr([]byte("a long string"))
+	w.bits(true, false, true, true)
+
+	r := &wireReader{buf: w.Bytes()}
+	if v := r.octet(); v != 0xAB {
+		t.Errorf("octet = %x", v)
+	}
+	if v := r.short(); v != 0xBEEF {
+		t.Errorf("short = %x", v)
+	}
+	if v := r.long(); v != 0xDEADBEEF {
+		t.Errorf("long = %x", v)
+	}
+	if v := r.longlong(); v != 0x0123456789ABCDEF {
+		t.Errorf("longlong = %x", v)
+	}
+	if v := r.shortstr(); v != "amq.topic" {
+		t.Errorf("shortstr = %q", v)
+	}
+	if v := r.longstr(); string(v) != "a long string" {
+		t.Errorf("longstr = %q", v)
+	}
+	if v := r.bits(); !reflect.DeepEqual(v[:4], []bool{true, false, true, true}) || v[4] {
+		t.Errorf("bits = %v", v)
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		t.Errorf("%d bytes left, error %v", len(r.buf), r.err)
+	}
+
+	r.long()
+	if r.err == nil {
+		t.Error("expected an error reading past the payload")
+	}
+}
+
+func TestWireTableRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"bool", true, true},
+		{"string", "text", "text"},
+		{"int", 42, int64(42)},
+		{"int64", int64(-7), int64(-7)},
+		{"int32", int32(-3), int32(-3)},
+		{"float64", 1.5, 1.5},
+		{"bytes", []byte{0, 1, 2}, []byte{0, 1, 2}},
+		{"array", []interface{}{"a", int64(1), false}, []interface{}{"a", int64(1), false}},
+		{"table", amqpDriver.Table{"x-match": "all", "depth": amqpDriver.Table{"n": int32(1)}}, amqpDriver.Table{"x-match": "all", "depth": amqpDriver.Table{"n": int32(1)}}},
+		{"void", nil, nil},
+	}
+	table := amqpDriver.Table{}
+	for _, tt := range tests {
+		table[tt.name] = tt.value
+	}
+
+	var w wireWriter
+	w.table(table)
+	r := &wireReader{buf: w.Bytes()}
+	decoded := r.table()
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(decoded[tt.name], tt.expected) {
+			t.Errorf("%s: decoded %#v, expected %#v", tt.name, decoded[tt.name], tt.expected)
+		}
+	}
+	if len(decoded) != len(tests) {
+		t.Errorf("decoded %d fields, expected %d", len(decoded), len(tests))
+	}
+}
+
+func TestWireFieldUnknownType(t *testing.T) {
+	t.Parallel()
+
+	r := &wireReader{buf: []byte{'?', 0}}
+	r.field()
+	if r.err == nil {
+		t.Error("expected an error for an unknown field type")
+	}
+}
+
+func TestContentHeaders(t *testing.T) {
+	t.Parallel()
+
+	var w wireWriter
+	w.short(60) // class id
+	w.short(0)  // weight
+	w.longlong(1234)
+	w.short(1<<15 | 1<<14 | 1<<13)
+	w.shortstr("application/json")
+	w.shortstr("gzip")
+	w.table(amqpDriver.Table{"x-k6-sequence": int64(3)})
+
+	size, headers, err := contentHeaders(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1234 {
+		t.Errorf("size = %d, expected 1234", size)
+	}
+	if headers["x-k6-sequence"] != int64(3) {
+		t.Errorf("headers = %v", headers)
+	}
+
+	if _, _, err = contentHeaders(w.Bytes()[:10]); err == nil {
+		t.Error("expected an error for a truncated content header")
+	}
+}
@@ -0,0 +1,242 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.k6.io/k6/js/modules"
+)
+
+// Management is a client for the RabbitMQ management HTTP API.
+type Management struct {
+	Version string
+
+	vu      modules.VU
+	client  *http.Client
+	options ManagementOptions
+}
+
+// ManagementOptions defines how to reach the management HTTP API.
+type ManagementOptions struct {
+	URL        string // e.g. http://localhost:15672
+	Username   string
+	Password   string
+	TimeoutSec int    // request timeout, 10 by default
+	Vhost      string // virtual host of the policies set and deleted, / by default
+}
+
+// Policy definition keys whose values must not be negative.
+var policyCounts = []string{"message-ttl", "expires", "max-length", "max-length-bytes", "delivery-limit"}
+
+// managementError is a request answered with an error status by the management API.
+type managementError struct {
+	method  string
+	path    string
+	status  string
+	code    int
+	message string
+}
+
+// Error implements the error interface.
+func (e *managementError) Error() string {
+	return fmt.Sprintf("management API %s %s failed: %s %s", e.method, e.path, e.status, e.message)
+}
+
+// isManagementNotFound tells whether a request failed because the resource, or the plugin serving it, is missing.
+func isManagementNotFound(err error) bool {
+	var managementErr *managementError
+	return errors.As(err, &managementErr) && managementErr.code == http.StatusNotFound
+}
+
+// policyDefinition copies the definition of a policy, underscores of the keys standing for dashes.
+func policyDefinition(definition map[string]interface{}) (map[string]interface{}, error) {
+	if len(definition) == 0 {
+		return nil, errors.New("policy definition is empty")
+	}
+	policy := make(map[string]interface{}, len(definition))
+	for k, v := range definition {
+		policy[strings.ReplaceAll(k, "_", "-")] = v
+	}
+	for _, key := range policyCounts {
+		var negative bool
+		switch value := policy[key].(type) {
+		case int64:
+			negative = value < 0
+		case int:
+			negative = value < 0
+		case float64:
+			negative = value < 0
+		}
+		if negative {
+			return nil, fmt.Errorf("policy %s must not be negative", key)
+		}
+	}
+	return policy, nil
+}
+
+// managementPolicy is the policy document accepted by PUT /api/policies/{vhost}/{name}.
+type managementPolicy struct {
+	Pattern    string                 `json:"pattern"`
+	Definition map[string]interface{} `json:"definition"`
+	ApplyTo    string                 `json:"apply-to"`
+	Priority   int                    `json:"priority"`
+}
+
+// Start configures the management API endpoint and credentials.
+func (management *Management) Start(options ManagementOptions) error {
+	if options.URL == "" {
+		return errors.New("management url is required")
+	}
+	timeout := 10 * time.Second
+	if options.TimeoutSec > 0 {
+		timeout = time.Duration(options.TimeoutSec) * time.Second
+	}
+
+	options.URL = strings.TrimRight(options.URL, "/")
+	management.options = options
+	management.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// Overview returns cluster wide statistics, including message rates and churn rates.
+func (management *Management) Overview() (map[string]interface{}, error) {
+	var overview map[string]interface{}
+	err := management.do(http.MethodGet, "/api/overview", nil, &overview)
+	return overview, err
+}
+
+// ChurnRates returns the connection, channel and queue churn rates of the cluster.
+func (management *Management) ChurnRates() (map[string]interface{}, error) {
+	overview, err := management.Overview()
+	if err != nil {
+		return nil, err
+	}
+	churnRates, _ := overview["churn_rates"].(map[string]interface{})
+	return churnRates, nil
+}
+
+// Nodes returns the statistics of every cluster node.
+func (management *Management) Nodes() ([]map[string]interface{}, error) {
+	return management.list("/api/nodes")
+}
+
+// Queues returns the queues of a virtual host, or of all virtual hosts if vhost is empty.
+func (management *Management) Queues(vhost string) ([]map[string]interface{}, error) {
+	return management.list(vhostPath("/api/queues", vhost))
+}
+
+// Connections returns the open client connections.
+func (management *Management) Connections() ([]map[string]interface{}, error) {
+	return management.list("/api/connections")
+}
+
+// Policies returns the policies of a virtual host, or of all virtual hosts if vhost is empty.
+func (management *Management) Policies(vhost string) ([]map[string]interface{}, error) {
+	return management.list(vhostPath("/api/policies", vhost))
+}
+
+// SetPolicy creates or updates a policy, e.g. in setup to apply TTLs, length limits or mirroring to the
+// queues of the test instead of requiring a provisioned broker. The definition keys are those of
+// rabbitmqctl set_policy, e.g. message-ttl or ha-mode, which may be written message_ttl or ha_mode.
+// applyTo is queues, exchanges or all (default).
+func (management *Management) SetPolicy(
+	name string, pattern string, definition map[string]interface{}, applyTo string, priority int,
+) error {
+	if applyTo == "" {
+		applyTo = "all"
+	}
+	policy, err := policyDefinition(definition)
+	if err != nil {
+		return err
+	}
+	return management.do(http.MethodPut, management.policyPath(name), managementPolicy{
+		Pattern:    pattern,
+		Definition: policy,
+		ApplyTo:    applyTo,
+		Priority:   priority,
+	}, nil)
+}
+
+// DeletePolicy removes a policy set with SetPolicy.
+func (management *Management) DeletePolicy(name string) error {
+	return management.do(http.MethodDelete, management.policyPath(name), nil, nil)
+}
+
+// policyPath returns the path of a policy of the virtual host of the policies, the default one if not set.
+func (management *Management) policyPath(name string) string {
+	vhost := management.options.Vhost
+	if vhost == "" {
+		vhost = "/"
+	}
+	return vhostPath("/api/policies", vhost) + "/" + url.PathEscape(name)
+}
+
+// list performs a GET request returning a JSON array of objects.
+func (management *Management) list(path string) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	err := management.do(http.MethodGet, path, nil, &items)
+	return items, err
+}
+
+// do performs an authenticated request, encoding body and decoding the response into result when not nil.
+func (management *Management) do(method string, path string, body interface{}, result interface{}) error {
+	if management.client == nil {
+		return errors.New("management API is not configured, call start with a url first")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(management.vu.Context(), method, management.options.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(management.options.Username, management.options.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := management.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return &managementError{
+			method:  method,
+			path:    path,
+			status:  res.Status,
+			code:    res.StatusCode,
+			message: strings.TrimSpace(string(message)),
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// vhostPath appends the escaped virtual host to a collection path, if any.
+func vhostPath(path string, vhost string) string {
+	if vhost == "" {
+		return path
+	}
+	return path + "/" + url.PathEscape(vhost)
+}
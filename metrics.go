@@ -0,0 +1,243 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/metrics"
+)
+
+// Metric families which can be turned off with the DisableMetrics option.
+const (
+	metricsPublish    = "publish"
+	metricsConsume    = "consume"
+	metricsDepth      = "depth"
+	metricsWorkflow   = "workflow"
+	metricsTopology   = "topology"
+	metricsConnection = "connection"
+	metricsStomp      = "stomp"
+	metricsMqtt       = "mqtt"
+	metricsE2E        = "e2e"   // end to end latency of the tracked messages
+	metricsSizes      = "sizes" // bytes consumed
+)
+
+// amqpMetrics holds the custom metrics emitted by the extension for a VU.
+type amqpMetrics struct {
+	MessagesConsumed      *metrics.Metric
+	RetryDuplicates       *metrics.Metric
+	MessagesOutOfOrder    *metrics.Metric
+	SequenceGaps          *metrics.Metric
+	TrackedDelivered      *metrics.Metric
+	TrackedLost           *metrics.Metric
+	TrackedDuplicates     *metrics.Metric
+	TrackedLatency        *metrics.Metric
+	DuplicatesSuppressed  *metrics.Metric
+	DuplicatesDetected    *metrics.Metric
+	MessagesFiltered      *metrics.Metric
+	ConsumerCancellations *metrics.Metric
+	ConsumerStalled       *metrics.Metric
+	ConsumerStallDuration *metrics.Metric
+	ConsumeRate           *metrics.Metric
+	ConsumePacingDelay    *metrics.Metric
+	ConsumeDuration       *metrics.Metric
+	MessagesRedelivered   *metrics.Metric
+	MessagesNacked        *metrics.Metric
+	PoisonMessages        *metrics.Metric
+	DeliveriesDropped     *metrics.Metric
+	SinkBytes             *metrics.Metric
+	ValidationFailures    *metrics.Metric
+	ExpiryLateness        *metrics.Metric
+	UnackedDeliveries     *metrics.Metric
+	OldestUnackedAge      *metrics.Metric
+	PartitionLag          *metrics.Metric
+	QueueLeftover         *metrics.Metric
+	QueueDepth            *metrics.Metric
+
+	WorkflowDuration     *metrics.Metric
+	WorkflowStepDuration *metrics.Metric
+
+	TakeoverDuration    *metrics.Metric
+	SACFailoverDuration *metrics.Metric
+
+	PublishFailures  *metrics.Metric
+	MessagesReturned *metrics.Metric
+	PublishBacklog   *metrics.Metric
+	PublishDropped   *metrics.Metric
+	ShardMessages    *metrics.Metric
+	PublishRetries   *metrics.Metric
+	PublishDuration  *metrics.Metric
+
+	QueueDeclareDuration    *metrics.Metric
+	ConsumeRegisterDuration *metrics.Metric
+	BindingChurnErrors      *metrics.Metric
+	TopologyDrift           *metrics.Metric
+
+	AckModeThroughput *metrics.Metric
+	AckModeDuration   *metrics.Metric
+
+	ReconnectAttempts     *metrics.Metric
+	ReconnectDuration     *metrics.Metric
+	Reconnects            *metrics.Metric
+	ConnectDuration       *metrics.Metric
+	ChannelOpenDuration   *metrics.Metric
+	ConnectionChurnErrors *metrics.Metric
+	OperationTimeouts     *metrics.Metric
+	ChannelErrors         *metrics.Metric
+
+	StompMessagesSent     *metrics.Metric
+	StompMessagesReceived *metrics.Metric
+	StompReceiptDuration  *metrics.Metric
+
+	MqttMessagesPublished *metrics.Metric
+	MqttMessagesReceived  *metrics.Metric
+	MqttPubackDuration    *metrics.Metric
+	MqttMessagesDropped   *metrics.Metric
+
+	vu       modules.VU
+	families map[*metrics.Metric]string
+	disabled map[string]bool
+	tags     map[string]string // added to every sample, see Options.Tags
+}
+
+// metricDefinition describes a metric and the family it belongs to.
+type metricDefinition struct {
+	metric   **metrics.Metric
+	name     string
+	family   string
+	typ      metrics.MetricType
+	contains metrics.ValueType
+}
+
+// registerMetrics registers the extension metrics in the k6 registry.
+func registerMetrics(vu modules.VU) (*amqpMetrics, error) {
+	registry := vu.InitEnv().Registry
+	m := &amqpMetrics{
+		vu:       vu,
+		families: make(map[*metrics.Metric]string),
+	}
+
+	definitions := []metricDefinition{
+		{&m.MessagesConsumed, "amqp_messages_consumed", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.RetryDuplicates, "amqp_retry_duplicates", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.MessagesOutOfOrder, "amqp_messages_out_of_order", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.SequenceGaps, "amqp_sequence_gaps", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.TrackedDelivered, "amqp_tracked_delivered", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.TrackedLost, "amqp_tracked_lost", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.TrackedDuplicates, "amqp_tracked_duplicates", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.TrackedLatency, "amqp_tracked_latency", metricsE2E, metrics.Trend, metrics.Time},
+		{&m.DuplicatesSuppressed, "amqp_duplicates_suppressed", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.DuplicatesDetected, "amqp_duplicates_detected", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.MessagesFiltered, "amqp_messages_filtered", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.ConsumerCancellations, "amqp_consumer_cancellations", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.ConsumerStalled, "amqp_consumer_stalled", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.ConsumerStallDuration, "amqp_consumer_stall_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.ConsumeRate, "amqp_consume_rate", metricsConsume, metrics.Gauge, metrics.Default},
+		{&m.ConsumePacingDelay, "amqp_consume_pacing_delay", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.ConsumeDuration, "amqp_consume_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.MessagesRedelivered, "amqp_messages_redelivered", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.MessagesNacked, "amqp_messages_nacked", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.PoisonMessages, "amqp_poison_messages", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.DeliveriesDropped, "amqp_deliveries_dropped", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.SinkBytes, "amqp_sink_bytes", metricsSizes, metrics.Counter, metrics.Data},
+		{&m.ValidationFailures, "amqp_validation_failures", metricsConsume, metrics.Counter, metrics.Default},
+		{&m.ExpiryLateness, "amqp_expiry_lateness", metricsDepth, metrics.Trend, metrics.Time},
+		{&m.UnackedDeliveries, "amqp_unacked_deliveries", metricsConsume, metrics.Gauge, metrics.Default},
+		{&m.OldestUnackedAge, "amqp_oldest_unacked_age", metricsConsume, metrics.Gauge, metrics.Time},
+		{&m.PartitionLag, "amqp_partition_lag", metricsDepth, metrics.Gauge, metrics.Default},
+		{&m.QueueLeftover, "amqp_queue_leftover", metricsDepth, metrics.Gauge, metrics.Default},
+		{&m.QueueDepth, "amqp_queue_depth", metricsDepth, metrics.Gauge, metrics.Default},
+		{&m.WorkflowDuration, "amqp_workflow_duration", metricsWorkflow, metrics.Trend, metrics.Time},
+		{&m.WorkflowStepDuration, "amqp_workflow_step_duration", metricsWorkflow, metrics.Trend, metrics.Time},
+		{&m.TakeoverDuration, "amqp_takeover_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.SACFailoverDuration, "amqp_sac_failover_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.PublishFailures, "amqp_publish_failures", metricsPublish, metrics.Counter, metrics.Default},
+		{&m.MessagesReturned, "amqp_messages_returned", metricsPublish, metrics.Counter, metrics.Default},
+		{&m.PublishBacklog, "amqp_publish_backlog", metricsPublish, metrics.Gauge, metrics.Default},
+		{&m.PublishDropped, "amqp_publish_dropped", metricsPublish, metrics.Counter, metrics.Default},
+		{&m.ShardMessages, "amqp_shard_messages", metricsPublish, metrics.Counter, metrics.Default},
+		{&m.PublishRetries, "amqp_publish_retries", metricsPublish, metrics.Counter, metrics.Default},
+		{&m.PublishDuration, "amqp_publish_duration", metricsPublish, metrics.Trend, metrics.Time},
+		{&m.BindingChurnErrors, "amqp_binding_churn_errors", metricsTopology, metrics.Counter, metrics.Default},
+		{&m.TopologyDrift, "amqp_topology_drift", metricsTopology, metrics.Counter, metrics.Default},
+		{&m.QueueDeclareDuration, "amqp_queue_declare_duration", metricsTopology, metrics.Trend, metrics.Time},
+		{&m.ConsumeRegisterDuration, "amqp_consume_register_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.AckModeThroughput, "amqp_ack_mode_throughput", metricsConsume, metrics.Gauge, metrics.Default},
+		{&m.AckModeDuration, "amqp_ack_mode_duration", metricsConsume, metrics.Trend, metrics.Time},
+		{&m.ReconnectAttempts, "amqp_reconnect_attempts", metricsConnection, metrics.Counter, metrics.Default},
+		{&m.ReconnectDuration, "amqp_reconnect_duration", metricsConnection, metrics.Trend, metrics.Time},
+		{&m.Reconnects, "amqp_reconnects", metricsConnection, metrics.Counter, metrics.Default},
+		{&m.ConnectDuration, "amqp_connect_duration", metricsConnection, metrics.Trend, metrics.Time},
+		{&m.ChannelOpenDuration, "amqp_channel_open_duration", metricsConnection, metrics.Trend, metrics.Time},
+		{&m.ConnectionChurnErrors, "amqp_connection_churn_errors", metricsConnection, metrics.Counter, metrics.Default},
+		{&m.OperationTimeouts, "amqp_operation_timeouts", metricsConnection, metrics.Counter, metrics.Default},
+		{&m.ChannelErrors, "amqp_channel_errors", metricsConnection, metrics.Counter, metrics.Default},
+		{&m.StompMessagesSent, "amqp_stomp_messages_sent", metricsStomp, metrics.Counter, metrics.Default},
+		{&m.StompMessagesReceived, "amqp_stomp_messages_received", metricsStomp, metrics.Counter, metrics.Default},
+		{&m.StompReceiptDuration, "amqp_stomp_receipt_duration", metricsStomp, metrics.Trend, metrics.Time},
+		{&m.MqttMessagesPublished, "amqp_mqtt_messages_published", metricsMqtt, metrics.Counter, metrics.Default},
+		{&m.MqttMessagesReceived, "amqp_mqtt_messages_received", metricsMqtt, metrics.Counter, metrics.Default},
+		{&m.MqttPubackDuration, "amqp_mqtt_puback_duration", metricsMqtt, metrics.Trend, metrics.Time},
+		{&m.MqttMessagesDropped, "amqp_mqtt_messages_dropped", metricsMqtt, metrics.Counter, metrics.Default},
+	}
+	for _, def := range definitions {
+		metric, err := registry.NewMetric(def.name, def.typ, def.contains)
+		if err != nil {
+			return nil, err
+		}
+		*def.metric = metric
+		m.families[metric] = def.family
+	}
+	return m, nil
+}
+
+// disable turns off the given metric families.
+func (m *amqpMetrics) disable(families []string) error {
+	known := make(map[string]bool)
+	for _, family := range m.families {
+		known[family] = true
+	}
+
+	disabled := make(map[string]bool, len(families))
+	for _, family := range families {
+		if !known[family] {
+			return fmt.Errorf("unknown metric family %q", family)
+		}
+		disabled[family] = true
+	}
+	m.disabled = disabled
+	return nil
+}
+
+// push emits a single sample tagged with the current VU tags, the session tags and the extra tags given.
+// Samples are silently dropped outside of the VU context (e.g. in the init stage)
+// and for disabled metric families.
+func (m *amqpMetrics) push(metric *metrics.Metric, value float64, tags map[string]string) {
+	if m.disabled[m.families[metric]] {
+		return
+	}
+	state := m.vu.State()
+	if state == nil {
+		return
+	}
+
+	ctm := state.Tags.GetCurrentValues()
+	metrics.PushIfNotDone(m.vu.Context(), state.Samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: metric,
+			Tags:   ctm.Tags.WithTagsFromMap(m.tags).WithTagsFromMap(tags),
+		},
+		Time:     time.Now(),
+		Value:    value,
+		Metadata: ctm.Metadata,
+	})
+}
+
+// consumeTags builds the tag set for consume metrics of a queue, optionally scoped to a partition.
+func consumeTags(queueName string, partition string) map[string]string {
+	tags := map[string]string{"queue": queueName}
+	if partition != "" {
+		tags["partition"] = partition
+	}
+	return tags
+}
@@ -0,0 +1,33 @@
+package amqp
+
+import (
+	"testing"
+
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestDisableMetricFamilies(t *testing.T) {
+	t.Parallel()
+
+	m := (&RootModule{}).instance(modulestest.NewRuntime(t).VU).metrics
+	if err := m.disable([]string{metricsE2E, metricsSizes}); err != nil {
+		t.Fatal(err)
+	}
+	families := map[string]string{
+		"amqp_tracked_latency":   metricsE2E,
+		"amqp_sink_bytes":        metricsSizes,
+		"amqp_messages_consumed": metricsConsume,
+		"amqp_tracked_delivered": metricsConsume,
+	}
+	for metric, family := range m.families {
+		if expected, ok := families[metric.Name]; ok && family != expected {
+			t.Errorf("%s belongs to the %s family, expected %s", metric.Name, family, expected)
+		}
+	}
+	if !m.disabled[metricsE2E] || !m.disabled[metricsSizes] || m.disabled[metricsConsume] {
+		t.Errorf("disabled %v, expected e2e and sizes only", m.disabled)
+	}
+	if err := m.disable([]string{"latency"}); err == nil {
+		t.Error("disabled an unknown family")
+	}
+}
@@ -0,0 +1,214 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestMqttPacketRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []int{0, 1, 127, 128, 16383, 16384, 2097151, 2097152} {
+		local, remote := net.Pipe()
+		client := &MqttClient{conn: local, timeout: defaultMqttTimeout, done: make(chan struct{})}
+		body := bytes.Repeat([]byte{0xa5}, size)
+		written := make(chan error, 1)
+		go func() {
+			written <- client.write(mqttPublish<<4|0x02, body)
+		}()
+
+		header, read, err := readMqttPacket(bufio.NewReader(remote))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if err = <-written; err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if header != mqttPublish<<4|0x02 || !bytes.Equal(read, body) {
+			t.Errorf("%d bytes: read header %#x and %d bytes", size, header, len(read))
+		}
+		_ = local.Close()
+		_ = remote.Close()
+	}
+}
+
+func TestReadMqttPacketErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]byte{
+		"empty":                     {},
+		"no remaining length":       {mqttPingreq << 4},
+		"remaining length too long": {mqttPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"truncated body":            {mqttPublish << 4, 5, 0, 1},
+	}
+	for name, data := range tests {
+		if _, _, err := readMqttPacket(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: read a malformed packet", name)
+		}
+	}
+}
+
+func TestDecodeMqttPublish(t *testing.T) {
+	t.Parallel()
+
+	body := appendMqttString(nil, "sensors/1")
+	msg, id, err := decodeMqttPublish(mqttPublish<<4|0x08|0x01, append(body, "22.5"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != (MqttMessage{Topic: "sensors/1", Payload: "22.5", Retain: true, Duplicate: true}) || id != 0 {
+		t.Errorf("decoded QoS 0 %+v with id %d", msg, id)
+	}
+
+	msg, id, err = decodeMqttPublish(mqttPublish<<4|0x02, append(append(body, 0x12, 0x34), "on"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != (MqttMessage{Topic: "sensors/1", Payload: "on", Qos: 1}) || id != 0x1234 {
+		t.Errorf("decoded QoS 1 %+v with id %d", msg, id)
+	}
+
+	for _, malformed := range [][]byte{{0}, {0, 9, 'a'}, body} {
+		if _, _, err = decodeMqttPublish(mqttPublish<<4|0x02, malformed); err == nil {
+			t.Errorf("decoded the malformed PUBLISH %v", malformed)
+		}
+	}
+}
+
+// mqttBroker accepts a single client, acknowledging its packets and echoing the PUBLISH packets to it, after
+// flooding it with flood messages on its first subscription.
+func mqttBroker(t *testing.T, flood int) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveMqtt(conn, flood)
+		}
+	}()
+	return "mqtt://guest:guest@" + listener.Addr().String()
+}
+
+func serveMqtt(conn net.Conn, flood int) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := &MqttClient{conn: conn, timeout: defaultMqttTimeout, done: make(chan struct{})}
+	reader := bufio.NewReader(conn)
+	for {
+		header, body, err := readMqttPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case mqttConnect:
+			code := byte(0)
+			if !bytes.Contains(body, []byte("guest")) {
+				code = 4
+			}
+			_ = client.write(mqttConnack<<4, []byte{0, code})
+		case mqttSubscribe:
+			topic := string(body[4 : 4+binary.BigEndian.Uint16(body[2:])])
+			for ; flood > 0; flood-- {
+				_ = client.write(mqttPublish<<4, append(appendMqttString(nil, topic), "flood"...))
+			}
+			_ = client.write(mqttSuback<<4, []byte{body[0], body[1], body[len(body)-1]})
+		case mqttPublish:
+			msg, id, _ := decodeMqttPublish(header, body)
+			if msg.Qos > 0 {
+				_ = client.write(mqttPuback<<4, []byte{byte(id >> 8), byte(id)})
+			}
+			_ = client.write(header, body)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func TestMqttClient(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	mqtt := (&RootModule{}).instance(rt.VU).Mqtt
+	url := mqttBroker(t, 0)
+
+	if _, err := mqtt.Connect(MqttOptions{URL: url, Username: "intruder"}); err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("connected with wrong credentials: %v", err)
+	}
+
+	client, err := mqtt.Connect(MqttOptions{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted, err := client.Subscribe("sensors/+", 1)
+	if err != nil || granted != 1 {
+		t.Fatalf("granted %d, %v", granted, err)
+	}
+	if err = client.Publish(MqttPublishOptions{Topic: "sensors/1", Payload: "22.5", Qos: 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.Receive(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil || *msg != (MqttMessage{Topic: "sensors/1", Payload: "22.5", Qos: 1}) {
+		t.Fatalf("received %+v", msg)
+	}
+	if msg, err = client.Receive(10); msg != nil || err != nil {
+		t.Errorf("received %+v, %v from an empty subscription", msg, err)
+	}
+	if err = client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Publish(MqttPublishOptions{Topic: "sensors/1"}); err == nil {
+		t.Error("published after the disconnection")
+	}
+}
+
+func TestMqttClientDropsOverflow(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	client, err := (&RootModule{}).instance(rt.VU).Mqtt.Connect(MqttOptions{URL: mqttBroker(t, mqttMessageBuffer+10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Disconnect()
+	}()
+
+	// The SUBACK follows the flood, it is only read if the messages beyond the buffer are dropped.
+	if _, err = client.Subscribe("flood", 0); err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	for {
+		msg, err := client.Receive(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			break
+		}
+		received++
+	}
+	if received != mqttMessageBuffer {
+		t.Errorf("received %d messages, expected the %d buffered", received, mqttMessageBuffer)
+	}
+}
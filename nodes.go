@@ -0,0 +1,47 @@
+package amqp
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"sync/atomic"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// nodeBalancer spreads the sessions of the test run across the nodes resolved with ResolveNodes.
+type nodeBalancer struct {
+	sessions int64
+}
+
+// take returns the offset of a new session in the resolved nodes.
+func (b *nodeBalancer) take() int {
+	return int(atomic.AddInt64(&b.sessions, 1) - 1)
+}
+
+// resolveNodes returns the IP addresses to connect to for a connection URL, starting with the node of the
+// session, or a single empty address connecting to the host of the URL unless ResolveNodes is set. A host
+// failing to resolve is left to the dial to report.
+func (amqp *AMQP) resolveNodes(url string) []string {
+	if !amqp.options.ResolveNodes {
+		return []string{""}
+	}
+	uri, err := amqpDriver.ParseURI(url)
+	if err != nil {
+		return []string{""}
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(amqp.vu.Context(), uri.Host)
+	if err != nil || len(addrs) == 0 {
+		return []string{""}
+	}
+
+	// The resolver shuffles the records, the sessions agree on an order to be spread evenly.
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i].IP, addrs[j].IP) < 0
+	})
+	nodes := make([]string, len(addrs))
+	for i := range addrs {
+		nodes[i] = addrs[(amqp.nodeOffset+i)%len(addrs)].String()
+	}
+	return nodes
+}
@@ -0,0 +1,141 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// OAuth2Options defines the OAuth2 client credentials used to obtain a JWT sent as the connection password.
+type OAuth2Options struct {
+	TokenURL         string
+	ClientId         string
+	ClientSecret     string
+	Scope            string
+	RefreshBeforeSec int // how long before expiry the token is refreshed, 60 by default
+}
+
+// oauth2Token holds the current access token and refreshes it in the background.
+type oauth2Token struct {
+	options OAuth2Options
+	client  *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresIn time.Duration
+}
+
+// oauth2TokenResponse is the token endpoint response as defined in RFC 6749, section 5.1.
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+const (
+	defaultRefreshBefore = 60 * time.Second
+	oauth2RetryInterval  = 5 * time.Second
+)
+
+func newOAuth2Token(options OAuth2Options) *oauth2Token {
+	return &oauth2Token{
+		options: options,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// current returns the last token obtained.
+func (t *oauth2Token) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token
+}
+
+// fetch requests a new token with the client credentials grant.
+func (t *oauth2Token) fetch(ctx context.Context) error {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.options.ClientId},
+		"client_secret": {t.options.ClientSecret},
+	}
+	if t.options.Scope != "" {
+		form.Set("scope", t.options.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.options.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request to %q failed: %s", t.options.TokenURL, res.Status)
+	}
+
+	var token oauth2TokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return err
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token response from %q has no access_token", t.options.TokenURL)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token.AccessToken
+	t.expiresIn = time.Duration(token.ExpiresIn) * time.Second
+	return nil
+}
+
+// refreshDelay returns how long to wait before refreshing the current token, zero if it does not expire.
+func (t *oauth2Token) refreshDelay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expiresIn <= 0 {
+		return 0
+	}
+
+	before := defaultRefreshBefore
+	if t.options.RefreshBeforeSec > 0 {
+		before = time.Duration(t.options.RefreshBeforeSec) * time.Second
+	}
+	if delay := t.expiresIn - before; delay > 0 {
+		return delay
+	}
+	return t.expiresIn / 2
+}
+
+// refresh keeps the connection secret up to date until the connection or the context is closed.
+func (t *oauth2Token) refresh(ctx context.Context, conn *amqpDriver.Connection) {
+	closed := conn.NotifyClose(make(chan *amqpDriver.Error, 1))
+	for next := t.refreshDelay(); next > 0; {
+		select {
+		case <-time.After(next):
+		case <-closed:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		if err := t.fetch(ctx); err != nil {
+			// The broker closes the connection once the old token expires, so keep trying.
+			next = oauth2RetryInterval
+			continue
+		}
+		_ = conn.UpdateSecret(t.current(), "OAuth2 token refresh")
+		next = t.refreshDelay()
+	}
+}
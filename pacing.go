@@ -0,0 +1,78 @@
+package amqp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const consumeRateInterval = time.Second
+
+// consumerPacer limits the deliveries passed to the handler of a consumer with a token bucket, modeling
+// a downstream SLA. A nil pacer lets everything through, which is the case unless a max rate is configured.
+type consumerPacer struct {
+	amqp      *AMQP
+	limiter   *rate.Limiter
+	tags      map[string]string
+	delivered int64 // deliveries handled since the last amqp_consume_rate sample
+	done      chan struct{}
+}
+
+// paceConsumer starts pacing deliveries at maxRate per second, sampling the achieved rate every second with
+// amqp_consume_rate and the time deliveries are held back with amqp_consume_pacing_delay.
+func (amqp *AMQP) paceConsumer(maxRate float64, tags map[string]string) *consumerPacer {
+	if maxRate <= 0 {
+		return nil
+	}
+
+	p := &consumerPacer{
+		amqp:    amqp,
+		limiter: rate.NewLimiter(rate.Limit(maxRate), 1),
+		tags:    tags,
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// wait holds a delivery back until the rate allows it, false if the context is done meanwhile.
+func (p *consumerPacer) wait(ctx context.Context) bool {
+	if p == nil {
+		return true
+	}
+	start := time.Now()
+	if err := p.limiter.Wait(ctx); err != nil {
+		return false
+	}
+	if delay := time.Since(start); delay >= time.Millisecond {
+		p.amqp.metrics.push(p.amqp.metrics.ConsumePacingDelay, float64(delay)/float64(time.Millisecond), p.tags)
+	}
+	atomic.AddInt64(&p.delivered, 1)
+	return true
+}
+
+// stop ends the pacer once the consumers are gone.
+func (p *consumerPacer) stop() {
+	if p != nil {
+		close(p.done)
+	}
+}
+
+func (p *consumerPacer) run() {
+	ticker := time.NewTicker(consumeRateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			delivered := atomic.SwapInt64(&p.delivered, 0)
+			p.amqp.metrics.push(p.amqp.metrics.ConsumeRate, float64(delivered)/consumeRateInterval.Seconds(), p.tags)
+		case <-p.done:
+			return
+		case <-p.amqp.vu.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package amqp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// PayloadOptions defines a message body generated natively instead of being built in JavaScript.
+type PayloadOptions struct {
+	Size         int                    // body size in bytes; random text unless a template is set
+	Template     map[string]interface{} // JSON object used as the body, padded up to Size when set
+	CounterField string                 // template field set to an incrementing per-VU sequence number
+	PaddingField string                 // template field holding the padding, "padding" by default
+
+	Sizes []PayloadSize // weighted body sizes drawn for every message instead of Size, see amqp_publish_duration
+}
+
+// PayloadSize is a body size class of a payload size distribution.
+type PayloadSize struct {
+	Size   int     // body size in bytes
+	Weight float64 // relative frequency of the size class
+	Bucket string  // size_bucket tag of the class, derived from Size by default (e.g. 50KB)
+}
+
+// headerSizeBucket carries the size class of a generated body so consume metrics can be tagged with it.
+const headerSizeBucket = "x-k6-size-bucket"
+
+const payloadAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// payloadRand is the random source of the payloads generated by a VU, shared by its publishers.
+type payloadRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newPayloadRand() *payloadRand {
+	return &payloadRand{rand: rand.New(rand.NewSource(time.Now().UnixNano()))} //nolint:gosec
+}
+
+func (r *payloadRand) float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64()
+}
+
+// text returns size bytes of random alphanumeric text.
+func (r *payloadRand) text(size int) []byte {
+	if size <= 0 {
+		return []byte{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = payloadAlphabet[r.rand.Intn(len(payloadAlphabet))]
+	}
+	return b
+}
+
+// enabled reports whether the options request a generated payload.
+func (options PayloadOptions) enabled() bool {
+	return options.Size > 0 || options.Template != nil || len(options.Sizes) > 0
+}
+
+// drawSize picks a size class of the distribution at random, in proportion to the weights.
+func (options PayloadOptions) drawSize(r *payloadRand) (PayloadSize, error) {
+	var total float64
+	for _, size := range options.Sizes {
+		if size.Weight <= 0 || size.Size < 0 {
+			return PayloadSize{}, errors.New("payload sizes need a positive weight and a size of zero or more bytes")
+		}
+		total += size.Weight
+	}
+
+	pick := r.float64() * total
+	for _, size := range options.Sizes {
+		if pick < size.Weight {
+			return size, nil
+		}
+		pick -= size.Weight
+	}
+	// Rounding may leave the pick past the last class.
+	return options.Sizes[len(options.Sizes)-1], nil
+}
+
+// bucket returns the size_bucket tag of the size class.
+func (size PayloadSize) bucket() string {
+	switch {
+	case size.Bucket != "":
+		return size.Bucket
+	case size.Size >= 1<<20 && size.Size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size.Size>>20)
+	case size.Size >= 1<<10 && size.Size%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", size.Size>>10)
+	default:
+		return fmt.Sprintf("%dB", size.Size)
+	}
+}
+
+// GeneratePayload returns a random or templated message body.
+func (amqp *AMQP) GeneratePayload(options PayloadOptions) (string, error) {
+	body, _, err := amqp.generatePayload(options)
+	return string(body), err
+}
+
+// generatePayload returns the body along with its size bucket, empty unless drawn from Sizes.
+func (amqp *AMQP) generatePayload(options PayloadOptions) ([]byte, string, error) {
+	var bucket string
+	if len(options.Sizes) > 0 {
+		size, err := options.drawSize(amqp.random)
+		if err != nil {
+			return nil, "", err
+		}
+		options.Size, bucket = size.Size, size.bucket()
+	}
+
+	if options.Template == nil {
+		return amqp.random.text(options.Size), bucket, nil
+	}
+	body, err := amqp.templatePayload(options)
+	return body, bucket, err
+}
+
+// templatePayload renders the template padded up to the size.
+func (amqp *AMQP) templatePayload(options PayloadOptions) ([]byte, error) {
+	doc := make(map[string]interface{}, len(options.Template)+2)
+	for k, v := range options.Template {
+		doc[k] = v
+	}
+	if options.CounterField != "" {
+		doc[options.CounterField] = atomic.AddInt64(&amqp.payloadSeq, 1)
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil || len(body) >= options.Size {
+		return body, err
+	}
+
+	paddingField := options.PaddingField
+	if paddingField == "" {
+		paddingField = "padding"
+	}
+	// The padding adds its own key, quotes, colon and comma to the document.
+	overhead := len(paddingField) + 6
+	doc[paddingField] = string(amqp.random.text(options.Size - len(body) - overhead))
+	return json.Marshal(doc)
+}
+
+// withSizeBucket adds the size_bucket tag of a delivery with a body drawn from a size distribution.
+func withSizeBucket(d amqpDriver.Delivery, tags map[string]string) map[string]string {
+	bucket, ok := d.Headers[headerSizeBucket].(string)
+	if !ok {
+		return tags
+	}
+	tagged := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		tagged[k] = v
+	}
+	tagged["size_bucket"] = bucket
+	return tagged
+}
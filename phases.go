@@ -0,0 +1,235 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/time/rate"
+)
+
+// PhasesOptions defines a load shape executed by the module, one phase after the other.
+type PhasesOptions struct {
+	Publish PublishOptions   // message published during the phases with a publish rate
+	Consume ConsumersOptions // consumers started during the phases with a consumer count, Count being ignored
+	Workers int              // goroutines publishing, each on its own channel, 1 by default
+	Phases  []Phase
+}
+
+// Phase is a time-boxed step of a load shape, e.g. a ramp, a spike or a soak.
+type Phase struct {
+	Name          string  // phase tag of the metrics, phase-<index> by default
+	DurationMs    int     // how long the phase lasts
+	PublishRate   float64 // messages published per second, none when 0
+	ConsumerCount int     // consumers running during the phase, none when 0
+}
+
+// PhaseStats reports the activity of a phase.
+type PhaseStats struct {
+	Name      string
+	Published int64
+	Failed    int64
+	Consumed  int64
+}
+
+// PhaseRun is a handle to phases started with RunPhases.
+type PhaseRun struct {
+	amqp     *AMQP
+	options  PhasesOptions
+	channels []*amqpDriver.Channel
+	stats    []PhaseStats
+	current  int32 // index of the running phase, len(stats) once done
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// RunPhases executes the phases in the background with Go-side publishers and consumers, so a complex
+// load shape is expressed once instead of with several k6 scenarios. The publishers are paced at the
+// publish rate of the phase, the consumers are restarted with the consumer count of every phase and the
+// amqp_publish_duration and amqp_messages_consumed samples are tagged with the phase.
+func (amqp *AMQP) RunPhases(options PhasesOptions) (run *PhaseRun, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if len(options.Phases) == 0 {
+		return nil, errors.New("no phases to run")
+	}
+	stats := make([]PhaseStats, len(options.Phases))
+	for i, phase := range options.Phases {
+		if phase.DurationMs <= 0 || phase.PublishRate < 0 || phase.ConsumerCount < 0 {
+			return nil, fmt.Errorf("phase %d needs a positive duration and no negative rate or consumer count", i)
+		}
+		if phase.ConsumerCount > 0 && options.Consume.QueueName == "" {
+			return nil, errors.New("phases with consumers need the queue_name of the consume options")
+		}
+		stats[i].Name = phase.Name
+		if stats[i].Name == "" {
+			stats[i].Name = fmt.Sprintf("phase-%d", i)
+		}
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(amqp.vu.Context())
+	run = &PhaseRun{
+		amqp:    amqp,
+		options: options,
+		stats:   stats,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		ch, err := amqp.channel()
+		if err != nil {
+			cancel()
+			run.closeChannels()
+			return nil, err
+		}
+		run.channels = append(run.channels, ch)
+	}
+
+	go run.execute(ctx)
+	return run, nil
+}
+
+// execute runs the phases in order until they are over or the run is stopped.
+func (run *PhaseRun) execute(ctx context.Context) {
+	defer close(run.done)
+	defer run.cancel()
+	defer run.closeChannels()
+
+	for i, phase := range run.options.Phases {
+		if ctx.Err() != nil {
+			break
+		}
+		atomic.StoreInt32(&run.current, int32(i))
+		if err := run.runPhase(ctx, phase, &run.stats[i]); err != nil {
+			run.err = err
+			break
+		}
+	}
+	atomic.StoreInt32(&run.current, int32(len(run.stats)))
+}
+
+// runPhase publishes and consumes for the duration of the phase.
+func (run *PhaseRun) runPhase(ctx context.Context, phase Phase, stats *PhaseStats) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(phase.DurationMs)*time.Millisecond)
+	defer cancel()
+
+	var group *ConsumerGroup
+	if phase.ConsumerCount > 0 {
+		consume := run.options.Consume
+		consume.Count = phase.ConsumerCount
+		consume.Tags = make(map[string]string, len(consume.Tags)+1)
+		for k, v := range run.options.Consume.Tags {
+			consume.Tags[k] = v
+		}
+		consume.Tags["phase"] = stats.Name
+
+		var err error
+		if group, err = run.amqp.StartConsumers(consume); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	if phase.PublishRate > 0 {
+		limiter := rate.NewLimiter(rate.Limit(phase.PublishRate), len(run.channels))
+		options := run.options.Publish
+		options.Tags = make(map[string]string, len(run.options.Publish.Tags)+1)
+		for k, v := range run.options.Publish.Tags {
+			options.Tags[k] = v
+		}
+		options.Tags["phase"] = stats.Name
+		for i := range run.channels {
+			wg.Add(1)
+			go func(index int) {
+				defer wg.Done()
+				run.publish(ctx, index, limiter, options, stats)
+			}(i)
+		}
+	}
+	<-ctx.Done()
+	wg.Wait()
+
+	if group == nil {
+		return nil
+	}
+	err := group.Stop()
+	for _, consumed := range group.Consumed() {
+		atomic.AddInt64(&stats.Consumed, consumed)
+	}
+	return err
+}
+
+// publish sends messages on the channel of a worker at the pace of the limiter until the phase is over.
+// A channel closed by the broker is reopened for the next message.
+func (run *PhaseRun) publish(ctx context.Context, index int, limiter *rate.Limiter, options PublishOptions, stats *PhaseStats) {
+	for limiter.Wait(ctx) == nil {
+		ch := run.channels[index]
+		if ch.IsClosed() {
+			reopened, err := run.amqp.channel()
+			if err == nil {
+				ch, run.channels[index] = reopened, reopened
+			}
+		}
+
+		message := run.amqp.stampTracked(options)
+		message = run.amqp.stampSequence(message, fmt.Sprintf("/%p/%d", run, index))
+		if err := run.amqp.publish(ch, message); err != nil {
+			run.amqp.forgetTracked(message, err)
+			atomic.AddInt64(&stats.Failed, 1)
+			continue
+		}
+		atomic.AddInt64(&stats.Published, 1)
+	}
+}
+
+func (run *PhaseRun) closeChannels() {
+	for _, ch := range run.channels {
+		_ = ch.Close()
+	}
+}
+
+// Current returns the name of the running phase, empty once the phases are over.
+func (run *PhaseRun) Current() string {
+	if i := int(atomic.LoadInt32(&run.current)); i < len(run.stats) {
+		return run.stats[i].Name
+	}
+	return ""
+}
+
+// Stats returns the activity of every phase so far.
+func (run *PhaseRun) Stats() []PhaseStats {
+	stats := make([]PhaseStats, len(run.stats))
+	for i := range run.stats {
+		stats[i] = PhaseStats{
+			Name:      run.stats[i].Name,
+			Published: atomic.LoadInt64(&run.stats[i].Published),
+			Failed:    atomic.LoadInt64(&run.stats[i].Failed),
+			Consumed:  atomic.LoadInt64(&run.stats[i].Consumed),
+		}
+	}
+	return stats
+}
+
+// Wait blocks until the phases are over and returns their activity.
+func (run *PhaseRun) Wait() ([]PhaseStats, error) {
+	<-run.done
+	return run.Stats(), structuredError(run.err)
+}
+
+// Stop ends the running phase early, skips the remaining ones and returns the activity of the phases.
+func (run *PhaseRun) Stop() ([]PhaseStats, error) {
+	run.cancel()
+	return run.Wait()
+}
@@ -0,0 +1,117 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// headerDeliveryCount is set by quorum queues to the number of times a message was returned to the queue.
+const headerDeliveryCount = "x-delivery-count"
+
+// poisonWindow is how long the deliveries of a message are remembered since its last one. Messages never
+// settled, e.g. whose consumer stopped, are forgotten after it rather than piling up.
+const poisonWindow = 5 * time.Minute
+
+// poisonDetector spots the messages caught in a redelivery loop, delivered more than a threshold of times
+// because every consumer rejects them with requeue. It is safe for concurrent use.
+type poisonDetector struct {
+	threshold int
+	mu        sync.Mutex
+	seen      map[string]*poisonCount // messages not settled for good yet, by message id
+	pruned    time.Time
+}
+
+// poisonCount tracks the deliveries of a message.
+type poisonCount struct {
+	deliveries int
+	reported   bool // already counted as poison
+	last       time.Time
+}
+
+// newPoisonDetector returns the detector of a consumer, nil if the threshold is not positive.
+func newPoisonDetector(threshold int) *poisonDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &poisonDetector{
+		threshold: threshold,
+		seen:      make(map[string]*poisonCount),
+		pruned:    time.Now(),
+	}
+}
+
+// deliveryCount returns the deliveries of a message reported by a quorum queue, 0 if unknown.
+func deliveryCount(d amqpDriver.Delivery) int {
+	switch count := d.Headers[headerDeliveryCount].(type) {
+	case int64:
+		return int(count) + 1
+	case int32:
+		return int(count) + 1
+	case int:
+		return count + 1
+	}
+	return 0
+}
+
+// delivered records a delivery and reports whether its message just crossed the threshold. The messages
+// are identified by their message id, or only through the x-delivery-count header of quorum queues if they
+// have none.
+func (detector *poisonDetector) delivered(d amqpDriver.Delivery) bool {
+	if detector == nil {
+		return false
+	}
+	count := deliveryCount(d)
+	if d.MessageId == "" {
+		return count == detector.threshold+1
+	}
+
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	now := time.Now()
+	if now.Sub(detector.pruned) > poisonWindow {
+		for id, seen := range detector.seen {
+			if now.Sub(seen.last) > poisonWindow {
+				delete(detector.seen, id)
+			}
+		}
+		detector.pruned = now
+	}
+
+	seen, ok := detector.seen[d.MessageId]
+	if !ok || now.Sub(seen.last) > poisonWindow {
+		seen = &poisonCount{}
+		detector.seen[d.MessageId] = seen
+	}
+	seen.deliveries++
+	if count > seen.deliveries {
+		seen.deliveries = count
+	}
+	seen.last = now
+	if seen.deliveries <= detector.threshold || seen.reported {
+		return false
+	}
+	seen.reported = true
+	return true
+}
+
+// settled forgets a message acknowledged or discarded, which is not redelivered anymore.
+func (detector *poisonDetector) settled(d amqpDriver.Delivery) {
+	if detector == nil || d.MessageId == "" {
+		return
+	}
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	delete(detector.seen, d.MessageId)
+}
+
+// checkPoison counts a delivery whose message is caught in a redelivery loop as amqp_poison_messages, once
+// per message, and reports whether it was.
+func (amqp *AMQP) checkPoison(detector *poisonDetector, d amqpDriver.Delivery, tags map[string]string) bool {
+	if !detector.delivered(d) {
+		return false
+	}
+	amqp.metrics.push(amqp.metrics.PoisonMessages, 1, tags)
+	return true
+}
@@ -0,0 +1,54 @@
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPoisonDetector(t *testing.T) {
+	t.Parallel()
+
+	detector := newPoisonDetector(2)
+	d := amqpDriver.Delivery{MessageId: "m-1"}
+	for i, expected := range []bool{false, false, true, false} {
+		if poison := detector.delivered(d); poison != expected {
+			t.Errorf("delivery %d: poison = %v, expected %v", i+1, poison, expected)
+		}
+	}
+	detector.settled(d)
+	if len(detector.seen) != 0 {
+		t.Errorf("%d messages remembered once settled", len(detector.seen))
+	}
+
+	quorum := amqpDriver.Delivery{Headers: amqpDriver.Table{headerDeliveryCount: int64(2)}}
+	if !detector.delivered(quorum) {
+		t.Error("the third delivery of a quorum queue message is not poison")
+	}
+	if newPoisonDetector(0).delivered(d) {
+		t.Error("a disabled detector reported poison")
+	}
+}
+
+func TestPoisonDetectorForgetsStaleMessages(t *testing.T) {
+	t.Parallel()
+
+	detector := newPoisonDetector(2)
+	detector.delivered(amqpDriver.Delivery{MessageId: "abandoned"})
+	detector.delivered(amqpDriver.Delivery{MessageId: "looping"})
+	detector.delivered(amqpDriver.Delivery{MessageId: "looping"})
+
+	// Both messages were last delivered beyond the window.
+	stale := time.Now().Add(-poisonWindow - time.Second)
+	for _, seen := range detector.seen {
+		seen.last = stale
+	}
+	detector.pruned = stale
+	if detector.delivered(amqpDriver.Delivery{MessageId: "looping"}) {
+		t.Error("deliveries beyond the window were counted")
+	}
+	if _, ok := detector.seen["abandoned"]; ok || len(detector.seen) != 1 {
+		t.Errorf("remembered %d messages, expected the stale ones pruned", len(detector.seen))
+	}
+}
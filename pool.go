@@ -0,0 +1,121 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// connectionPool is a fixed number of connections shared by all the VUs starting sessions with the same
+// broker and credentials. Each session gets the next connection in turn and opens its own channels on it.
+type connectionPool struct {
+	mu    sync.Mutex
+	slots []*pooledConnection
+	next  int
+}
+
+// pooledConnection is a connection of a pool, followed by the sessions using it as it is replaced.
+type pooledConnection struct {
+	sessionConnection
+	users      int  // sessions whose iteration is not over
+	recovering bool // a session reopens the connection when lost, see recoverOnClose
+}
+
+// connectionPools keeps the shared connection pools of the test run by broker, user and size.
+type connectionPools struct {
+	mu    sync.Mutex
+	pools map[string]*connectionPool
+}
+
+func (pools *connectionPools) get(options Options) *connectionPool {
+	key := fmt.Sprintf("%s|%s|%s|%d", options.ConnectionURL, strings.Join(options.ConnectionURLs, ","),
+		options.Username, options.SharedConnections)
+
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	if pool, ok := pools.pools[key]; ok {
+		return pool
+	}
+	if pools.pools == nil {
+		pools.pools = make(map[string]*connectionPool)
+	}
+	pool := &connectionPool{slots: make([]*pooledConnection, options.SharedConnections)}
+	for i := range pool.slots {
+		pool.slots[i] = &pooledConnection{}
+	}
+	pools.pools[key] = pool
+	return pool
+}
+
+// acquire returns the next connection of the pool, opening it with dial if it was never opened or was lost,
+// used until ctx is done. The second result tells whether the connection was opened by this call. The
+// connection is closed once no session uses it anymore, at the latest when the test ends.
+func (pool *connectionPool) acquire(ctx context.Context, dial func() (*amqpDriver.Connection, error)) (*pooledConnection, bool, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	slot := pool.slots[pool.next]
+	pool.next = (pool.next + 1) % len(pool.slots)
+	opened := false
+	if conn := slot.get(); conn == nil || conn.IsClosed() {
+		conn, err := dial()
+		if err != nil {
+			return nil, false, err
+		}
+		slot.set(conn)
+		opened = true
+	}
+	slot.users++
+	go func() {
+		<-ctx.Done()
+		pool.release(slot)
+	}()
+	return slot, opened, nil
+}
+
+// release closes the connection once the last session using it is done.
+func (pool *connectionPool) release(slot *pooledConnection) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	slot.users--
+	if slot.users > 0 {
+		return
+	}
+	if conn := slot.get(); conn != nil {
+		_ = conn.Close()
+	}
+	slot.set(nil)
+}
+
+// claimRecovery tells whether the caller is in charge of reopening the connection when lost, no other
+// session doing it. The caller calls endRecovery once it stops.
+func (pool *connectionPool) claimRecovery(slot *pooledConnection) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if slot.recovering {
+		return false
+	}
+	slot.recovering = true
+	return true
+}
+
+func (pool *connectionPool) endRecovery(slot *pooledConnection) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	slot.recovering = false
+}
+
+// replace hands the connection reopened by the recovery to the sessions, unless a session reopened the
+// connection in the meantime or none uses it anymore.
+func (pool *connectionPool) replace(slot *pooledConnection, conn *amqpDriver.Connection) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if current := slot.get(); slot.users == 0 || (current != nil && !current.IsClosed()) {
+		_ = conn.Close()
+		return
+	}
+	slot.set(conn)
+}
@@ -0,0 +1,130 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modulestest"
+)
+
+// eventually polls the condition for up to two seconds.
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSharedConnectionRecoveredAndClosed(t *testing.T) {
+	t.Parallel()
+
+	root := &RootModule{}
+	brokerURL, err := root.loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := newDropProxy(t, brokerURL[len("amqp://guest:guest@"):len(brokerURL)-1])
+	options := Options{
+		ConnectionURL:     "amqp://guest:guest@" + proxy.listener.Addr().String() + "/",
+		SharedConnections: 1,
+		Reconnect:         ReconnectOptions{Enabled: true, IntervalMs: 10},
+	}
+
+	sessions := make([]*AMQP, 2)
+	ends := make([]context.CancelFunc, 2)
+	for i := range sessions {
+		rt := modulestest.NewRuntime(t)
+		var ctx context.Context
+		ctx, ends[i] = context.WithCancel(context.Background())
+		defer ends[i]()
+		rt.VU.CtxField = ctx
+		sessions[i] = root.instance(rt.VU)
+		if err = sessions[i].Start(options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lost := sessions[0].connection.get()
+	if sessions[1].connection.get() != lost {
+		t.Fatal("the sessions do not share the connection")
+	}
+
+	proxy.drop()
+	var recovered *amqpDriver.Connection
+	if !eventually(func() bool {
+		recovered = sessions[1].connection.get()
+		return recovered != lost && recovered != nil && !recovered.IsClosed()
+	}) {
+		t.Fatal("the shared connection was not recovered")
+	}
+	if sessions[0].connection.get() != recovered || sessions[0].Queue.connection.get() != recovered {
+		t.Error("a session does not follow the recovered shared connection")
+	}
+
+	// The recovering session is done, the other one still uses the connection.
+	ends[0]()
+	time.Sleep(20 * time.Millisecond)
+	if recovered.IsClosed() {
+		t.Fatal("the shared connection was closed while in use")
+	}
+	ends[1]()
+	if !eventually(recovered.IsClosed) {
+		t.Error("the shared connection was not closed once unused")
+	}
+}
+
+func TestConsumerGroupStoppedWithIteration(t *testing.T) {
+	t.Parallel()
+
+	root := &RootModule{}
+	brokerURL, err := root.loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{ConnectionURL: brokerURL, SharedConnections: 1}
+
+	rt := modulestest.NewRuntime(t)
+	ctx, end := context.WithCancel(context.Background())
+	defer end()
+	rt.VU.CtxField = ctx
+	consuming := root.instance(rt.VU)
+	if err = consuming.Start(options); err != nil {
+		t.Fatal(err)
+	}
+	// The next iteration keeps the shared connection open.
+	publishing := root.instance(modulestest.NewRuntime(t).VU)
+	if err = publishing.Start(options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = publishing.Queue.Declare(DeclareOptions{Name: "group-iteration"}); err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := consuming.StartConsumers(ConsumersOptions{QueueName: "group-iteration", Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	end()
+	if !eventually(func() bool {
+		q, err := publishing.Queue.Inspect("group-iteration")
+		return err == nil && q.Consumers == 0
+	}) {
+		t.Fatal("the consumers outlived the iteration")
+	}
+
+	if _, err = publishing.Publish(PublishOptions{QueueName: "group-iteration", Body: "next"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if consumed := group.Consumed(); consumed[0]+consumed[1] != 0 {
+		t.Errorf("consumed %v after the iteration ended", consumed)
+	}
+	if q, err := publishing.Queue.Inspect("group-iteration"); err != nil || q.Messages != 1 {
+		t.Errorf("got %+v, %v, expected the message left in the queue", q, err)
+	}
+}
@@ -0,0 +1,67 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Distributions of the simulated processing times.
+const (
+	delayFixed   = "fixed"
+	delayUniform = "uniform"
+	delayNormal  = "normal"
+)
+
+// ProcessingDelay simulates the service time of a consumer, spent in Go before each delivery is
+// acknowledged, so that the depth of a queue builds up as it would behind a real consumer without sleeping
+// in the script.
+type ProcessingDelay struct {
+	Distribution string // fixed (default), uniform or normal
+	MinMs        int    // delay with the fixed distribution, lower bound with the others
+	MaxMs        int    // upper bound of the uniform distribution, optional cap of the normal one
+	MeanMs       int    // mean of the normal distribution
+	StddevMs     int    // standard deviation of the normal distribution
+}
+
+// enabled reports whether a processing time is simulated.
+func (delay ProcessingDelay) enabled() bool {
+	return delay != ProcessingDelay{}
+}
+
+// generator returns a function drawing the processing time of each delivery, nil if none is simulated.
+func (delay ProcessingDelay) generator() (func() time.Duration, error) {
+	if !delay.enabled() {
+		return nil, nil
+	}
+	minMs, maxMs := delay.MinMs, delay.MaxMs
+	if minMs < 0 || maxMs < 0 || (delay.Distribution == delayUniform && maxMs < minMs) {
+		return nil, fmt.Errorf("invalid processing delay bounds %d-%d", minMs, maxMs)
+	}
+
+	switch delay.Distribution {
+	case "", delayFixed:
+		return func() time.Duration { return time.Duration(minMs) * time.Millisecond }, nil
+	case delayUniform:
+		return func() time.Duration {
+			return time.Duration(minMs+rand.Intn(maxMs-minMs+1)) * time.Millisecond //nolint:gosec
+		}, nil
+	case delayNormal:
+		if delay.MeanMs <= 0 || delay.StddevMs < 0 {
+			return nil, errors.New("the normal distribution needs a positive mean_ms and a non negative stddev_ms")
+		}
+		return func() time.Duration {
+			ms := float64(delay.MeanMs) + rand.NormFloat64()*float64(delay.StddevMs) //nolint:gosec
+			if ms < float64(minMs) {
+				ms = float64(minMs)
+			}
+			if maxMs > 0 && ms > float64(maxMs) {
+				ms = float64(maxMs)
+			}
+			return time.Duration(ms * float64(time.Millisecond))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown processing delay distribution %q, expected fixed, uniform or normal", delay.Distribution)
+	}
+}
@@ -0,0 +1,131 @@
+package amqp
+
+import (
+	"fmt"
+	"strings"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Broker profiles selected with the Broker option.
+const (
+	brokerRabbitMQ = "rabbitmq"
+	brokerLavinMQ  = "lavinmq"
+	brokerQpid     = "qpid"
+	brokerLoopback = "loopback"
+)
+
+// brokerProfile holds what an AMQP 0-9-1 implementation supports beyond the base protocol.
+// A nil profile, before the session is started, supports everything.
+type brokerProfile struct {
+	name       string
+	queueTypes map[string]bool // supported x-queue-type values
+	nack       bool            // basic.nack, basic.reject is used instead when unsupported
+	confirms   bool            // publisher confirms
+}
+
+//nolint:gochecknoglobals
+var brokerProfiles = map[string]brokerProfile{
+	brokerRabbitMQ: {
+		name:       brokerRabbitMQ,
+		queueTypes: map[string]bool{queueTypeClassic: true, queueTypeQuorum: true, queueTypeStream: true},
+		nack:       true,
+		confirms:   true,
+	},
+	brokerLavinMQ: {
+		name:       brokerLavinMQ,
+		queueTypes: map[string]bool{queueTypeClassic: true, queueTypeStream: true},
+		nack:       true,
+		confirms:   true,
+	},
+	brokerQpid: {
+		name:       brokerQpid,
+		queueTypes: map[string]bool{queueTypeClassic: true},
+		confirms:   true,
+	},
+	brokerLoopback: {
+		name:       brokerLoopback,
+		queueTypes: map[string]bool{queueTypeClassic: true, queueTypeQuorum: true},
+		nack:       true,
+		confirms:   true,
+	},
+}
+
+// newBrokerProfile returns the profile of the Broker option, or the one detected from the product advertised
+// by the broker when empty. The capabilities advertised by the broker take precedence over the profile ones.
+func newBrokerProfile(broker string, server amqpDriver.Table) (*brokerProfile, error) {
+	if broker == "" {
+		broker = detectBroker(server)
+	}
+	profile, ok := brokerProfiles[strings.ToLower(broker)]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %q, expected one of rabbitmq, lavinmq, qpid, loopback", broker)
+	}
+
+	if capabilities, ok := server["capabilities"].(amqpDriver.Table); ok {
+		if nack, ok := capabilities["basic.nack"].(bool); ok {
+			profile.nack = nack
+		}
+		if confirms, ok := capabilities["publisher_confirms"].(bool); ok {
+			profile.confirms = confirms
+		}
+	}
+	return &profile, nil
+}
+
+// detectBroker tells the broker from its product server property, RabbitMQ if unknown.
+func detectBroker(server amqpDriver.Table) string {
+	product := strings.ToLower(fmt.Sprint(server["product"]))
+	switch {
+	case strings.Contains(product, brokerLoopback):
+		return brokerLoopback
+	case strings.Contains(product, brokerLavinMQ):
+		return brokerLavinMQ
+	case strings.Contains(product, brokerQpid):
+		return brokerQpid
+	default:
+		return brokerRabbitMQ
+	}
+}
+
+// checkQueueType fails if the broker does not support the queue type, classic if empty.
+func (profile *brokerProfile) checkQueueType(queueType string) error {
+	if profile == nil || queueType == "" || profile.queueTypes[queueType] {
+		return nil
+	}
+	return fmt.Errorf("%s queues are not supported by %s", queueType, profile.name)
+}
+
+// checkConfirms fails if the broker does not support publisher confirms.
+func (profile *brokerProfile) checkConfirms() error {
+	if profile == nil || profile.confirms {
+		return nil
+	}
+	return fmt.Errorf("publisher confirms are not supported by %s", profile.name)
+}
+
+// reject negatively acknowledges a delivery, with basic.reject on brokers lacking basic.nack.
+func (profile *brokerProfile) reject(d amqpDriver.Delivery, requeue bool) error {
+	if profile != nil && !profile.nack {
+		return d.Reject(requeue)
+	}
+	return d.Nack(false, requeue)
+}
+
+// BrokerProfile returns the broker profile of the session: rabbitmq, lavinmq, qpid or loopback.
+func (amqp *AMQP) BrokerProfile() string {
+	if amqp.profile == nil {
+		return ""
+	}
+	return amqp.profile.name
+}
+
+// consumerArgs builds the arguments of a consumer, checking the broker supports them.
+func (amqp *AMQP) consumerArgs(args ConsumerArgs, base amqpDriver.Table, queueName string, autoAck bool) (amqpDriver.Table, error) {
+	if args.stream() {
+		if err := amqp.profile.checkQueueType(queueTypeStream); err != nil {
+			return nil, err
+		}
+	}
+	return args.table(base, amqp.Queue.declaredType(queueName), autoAck)
+}
@@ -0,0 +1,172 @@
+package amqp
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+// protoTypes holds the protobuf messages of the descriptor sets loaded by the VUs, so that bodies are
+// encoded and decoded natively instead of with a JS protobuf library on every iteration.
+type protoTypes struct {
+	mu     sync.RWMutex
+	files  *protoregistry.Files
+	types  *protoregistry.Types
+	loaded map[string][]string // descriptor set path -> message names
+}
+
+// LoadProtoDescriptors registers the messages of a descriptor set generated with
+// protoc --include_imports --descriptor_set_out and returns their full names. Meant for the init context,
+// the set is read once for all the VUs.
+func (amqp *AMQP) LoadProtoDescriptors(path string) ([]string, error) {
+	return amqp.protos.load(path)
+}
+
+func (p *protoTypes) load(path string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if names, ok := p.loaded[path]; ok {
+		return names, nil
+	}
+	if p.files == nil {
+		p.files = new(protoregistry.Files)
+		p.types = new(protoregistry.Types)
+		p.loaded = make(map[string][]string)
+	}
+
+	raw, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+	}
+
+	var names []string
+	for _, fd := range set.File {
+		if _, err = p.files.FindFileByPath(fd.GetName()); err == nil {
+			continue // shared with a set loaded before
+		}
+		for _, dependency := range fd.Dependency {
+			p.registerWellKnown(dependency)
+		}
+		file, err := protodesc.NewFile(fd, p.files)
+		if err != nil {
+			return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+		}
+		if err = p.files.RegisterFile(file); err != nil {
+			return nil, err
+		}
+		names = p.registerMessages(file.Messages(), names)
+	}
+	sort.Strings(names)
+	p.loaded[path] = names
+	return names, nil
+}
+
+// registerWellKnown registers a dependency linked in the binary, such as google/protobuf/timestamp.proto,
+// so that descriptor sets generated without --include_imports still resolve it.
+func (p *protoTypes) registerWellKnown(path string) {
+	if _, err := p.files.FindFileByPath(path); err == nil {
+		return
+	}
+	if file, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		_ = p.files.RegisterFile(file)
+		p.registerMessages(file.Messages(), nil)
+	}
+}
+
+func (p *protoTypes) registerMessages(messages protoreflect.MessageDescriptors, names []string) []string {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		_ = p.types.RegisterMessage(dynamicpb.NewMessageType(md))
+		names = append(names, string(md.FullName()))
+		names = p.registerMessages(md.Messages(), names)
+	}
+	return names
+}
+
+func (p *protoTypes) message(name string) (protoreflect.MessageType, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.types == nil {
+		return nil, fmt.Errorf("unknown protobuf message %q, no descriptor set loaded", name)
+	}
+	mt, err := p.types.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown protobuf message %q", name)
+	}
+	return mt, nil
+}
+
+// known reports whether a message was loaded from a descriptor set.
+func (p *protoTypes) known(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := p.message(name)
+	return err == nil
+}
+
+// encode converts a JSON body, in the protobuf JSON mapping, to the binary encoding of the message.
+func (p *protoTypes) encode(name string, body []byte) ([]byte, error) {
+	mt, err := p.message(name)
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err = (protojson.UnmarshalOptions{Resolver: p.types}).Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", name, err)
+	}
+	return proto.Marshal(msg)
+}
+
+// decode converts the binary encoding of a message to JSON, in the protobuf JSON mapping.
+func (p *protoTypes) decode(name string, body []byte) ([]byte, error) {
+	mt, err := p.message(name)
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err = proto.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", name, err)
+	}
+	return protojson.MarshalOptions{Resolver: p.types}.Marshal(msg)
+}
+
+// decodeBody decompresses a delivery body and decodes it to JSON if it is Avro or protobuf, as told by the
+// consumer options or the content type of the message. The protobuf message defaults to the type of the message
+// if a loaded descriptor set has it, and Avro content types are only decoded with a schema registry configured,
+// so that scripts consuming such bodies raw keep getting them unchanged. Other bodies are returned decompressed.
+func (amqp *AMQP) decodeBody(d amqpDriver.Delivery, protoMessage string, avro bool) ([]byte, error) {
+	body, err := decompress(d.Body, d.ContentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	if avro || (d.ContentType == avroContentType && amqp.options.SchemaRegistry.Url != "") {
+		return amqp.decodeAvro(body)
+	}
+	if protoMessage == "" && d.ContentType == protobufContentType && amqp.protos.known(d.Type) {
+		protoMessage = d.Type
+	}
+	if protoMessage == "" {
+		return body, nil
+	}
+	return amqp.protos.decode(protoMessage, body)
+}
@@ -0,0 +1,83 @@
+package amqp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// writeTimestampDescriptors writes the descriptor set of google/protobuf/timestamp.proto to a temporary file.
+func writeTimestampDescriptors(t *testing.T) string {
+	t.Helper()
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto)},
+	}
+	raw, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "timestamp.pb")
+	if err = os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecodeBodyProtobufWithoutDescriptors(t *testing.T) {
+	t.Parallel()
+
+	amqp := &AMQP{protos: &protoTypes{}, registry: &schemaRegistry{}}
+	d := amqpDriver.Delivery{ContentType: protobufContentType, Type: "google.protobuf.Timestamp", Body: []byte{0x08, 0x01}}
+
+	body, err := amqp.decodeBody(d, "", false)
+	if err != nil || string(body) != string(d.Body) {
+		t.Errorf("decodeBody() = %v, %v, expected the raw body", body, err)
+	}
+	if _, err = amqp.decodeBody(d, "google.protobuf.Timestamp", false); err == nil {
+		t.Error("expected an error decoding an explicit message without descriptor set")
+	}
+}
+
+func TestDecodeBodyProtobufType(t *testing.T) {
+	t.Parallel()
+
+	amqp := &AMQP{protos: &protoTypes{}, registry: &schemaRegistry{}}
+	names, err := amqp.protos.load(writeTimestampDescriptors(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "google.protobuf.Timestamp" {
+		t.Fatalf("loaded %v, expected google.protobuf.Timestamp", names)
+	}
+
+	encoded, err := amqp.protos.encode("google.protobuf.Timestamp", []byte(`"1970-01-01T00:00:01Z"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		delivery amqpDriver.Delivery
+		expected string
+	}{
+		{"known type", amqpDriver.Delivery{ContentType: protobufContentType, Type: "google.protobuf.Timestamp", Body: encoded}, `"1970-01-01T00:00:01Z"`},
+		{"unknown type", amqpDriver.Delivery{ContentType: protobufContentType, Type: "acme.Order", Body: encoded}, string(encoded)},
+		{"no type", amqpDriver.Delivery{ContentType: protobufContentType, Body: encoded}, string(encoded)},
+	}
+	for _, tt := range tests {
+		body, err := amqp.decodeBody(tt.delivery, "", false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(body) != tt.expected {
+			t.Errorf("%s: decoded %q, expected %q", tt.name, body, tt.expected)
+		}
+	}
+}
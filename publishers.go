@@ -0,0 +1,188 @@
+package amqp
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishersOptions defines a pool of background publishers fed by the script.
+type PublishersOptions struct {
+	Workers   int // number of goroutines publishing, each on its own channel, 1 by default
+	QueueSize int // messages buffered in memory before Enqueue drops them, 10000 by default
+}
+
+// PublisherStats reports the activity of a publisher pool.
+type PublisherStats struct {
+	Enqueued  int64
+	Published int64
+	Failed    int64
+	Dropped   int64
+	Backlog   int
+}
+
+// PublisherPool is a handle to publishers started with StartPublishers.
+type PublisherPool struct {
+	amqp     *AMQP
+	channels []*amqpDriver.Channel
+	messages chan PublishOptions
+	wg       sync.WaitGroup
+	done     chan struct{}
+
+	mu      sync.RWMutex
+	stopped bool
+
+	enqueued  int64
+	published int64
+	failed    int64
+	dropped   int64
+}
+
+const (
+	defaultPublisherQueueSize = 10000
+	publisherBacklogInterval  = time.Second
+)
+
+// StartPublishers spins up a pool of publishers delivering the enqueued messages in the background,
+// taking the cost of the JS calls and channel round trips off the VU. Publisher confirms are not
+// requested; failures and drops are only reported by the pool statistics and the
+// amqp_publish_failures and amqp_publish_dropped metrics.
+func (amqp *AMQP) StartPublishers(options PublishersOptions) (pool *PublisherPool, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	size := options.QueueSize
+	if size <= 0 {
+		size = defaultPublisherQueueSize
+	}
+
+	pool = &PublisherPool{
+		amqp:     amqp,
+		messages: make(chan PublishOptions, size),
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		ch, err := amqp.channel()
+		if err != nil {
+			for _, opened := range pool.channels {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		pool.channels = append(pool.channels, ch)
+	}
+
+	for i := range pool.channels {
+		pool.wg.Add(1)
+		go pool.work(i)
+	}
+	go pool.reportBacklog()
+	return pool, nil
+}
+
+// work publishes the enqueued messages on the channel of the worker until the pool is stopped
+// and its backlog drained. A channel closed by the broker is reopened for the next message.
+func (pool *PublisherPool) work(index int) {
+	defer pool.wg.Done()
+	for options := range pool.messages {
+		ch := pool.channels[index]
+		if ch.IsClosed() {
+			reopened, err := pool.amqp.channel()
+			if err == nil {
+				ch, pool.channels[index] = reopened, reopened
+			}
+		}
+
+		options.Confirm = false
+		options = pool.amqp.stampTracked(options)
+		options = pool.amqp.stampSequence(options, fmt.Sprintf("/%p/%d", pool, index))
+		if err := pool.amqp.publish(ch, options); err != nil {
+			pool.amqp.forgetTracked(options, err)
+			atomic.AddInt64(&pool.failed, 1)
+			if ch.IsClosed() {
+				failure := pool.amqp.closeFailure(nil)
+				pool.amqp.metrics.push(pool.amqp.metrics.PublishFailures, 1, map[string]string{"class": failure.Class})
+			}
+			continue
+		}
+		atomic.AddInt64(&pool.published, 1)
+	}
+}
+
+// reportBacklog emits the number of buffered messages as the amqp_publish_backlog gauge.
+func (pool *PublisherPool) reportBacklog() {
+	ticker := time.NewTicker(publisherBacklogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pool.amqp.metrics.push(pool.amqp.metrics.PublishBacklog, float64(len(pool.messages)), nil)
+		case <-pool.done:
+			return
+		}
+	}
+}
+
+// Enqueue buffers a message for the publishers and returns immediately.
+// False is returned when the message is dropped because the buffer is full or the pool stopped.
+func (pool *PublisherPool) Enqueue(options PublishOptions) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if !pool.stopped {
+		select {
+		case pool.messages <- options:
+			atomic.AddInt64(&pool.enqueued, 1)
+			return true
+		default:
+		}
+	}
+	atomic.AddInt64(&pool.dropped, 1)
+	pool.amqp.metrics.push(pool.amqp.metrics.PublishDropped, 1, nil)
+	return false
+}
+
+// Stats returns the activity of the pool so far.
+func (pool *PublisherPool) Stats() PublisherStats {
+	return PublisherStats{
+		Enqueued:  atomic.LoadInt64(&pool.enqueued),
+		Published: atomic.LoadInt64(&pool.published),
+		Failed:    atomic.LoadInt64(&pool.failed),
+		Dropped:   atomic.LoadInt64(&pool.dropped),
+		Backlog:   len(pool.messages),
+	}
+}
+
+// Stop waits for the publishers to deliver the buffered messages and closes their channels.
+func (pool *PublisherPool) Stop() (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	pool.mu.Lock()
+	if pool.stopped {
+		pool.mu.Unlock()
+		return nil
+	}
+	pool.stopped = true
+	close(pool.messages)
+	pool.mu.Unlock()
+
+	pool.wg.Wait()
+	close(pool.done)
+
+	for _, ch := range pool.channels {
+		if closeErr := ch.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -1,13 +1,30 @@
 package amqp
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modules"
 )
 
+const waitForMessagesInterval = 100 * time.Millisecond
+
 // Queue defines a connection to a point-to-point destination.
 type Queue struct {
-	Version    string
-	Connection *amqpDriver.Connection
+	Version string
+
+	vu         modules.VU
+	connection *sessionConnection
+	metrics    *amqpMetrics
+	tracer     *tracer
+	events     *eventLog
+	profile    *brokerProfile
+	timeouts   TimeoutOptions
+	types      sync.Map // queue name -> type given when declared by the VU
 }
 
 // QueueOptions defines configuration settings for accessing a queue.
@@ -23,6 +40,20 @@ type DeclareOptions struct {
 	Exclusive        bool
 	NoWait           bool
 	Args             amqpDriver.Table
+	Passive          bool // only check the queue exists, failing with NOT_FOUND otherwise, see Exists
+
+	Type                 string // classic, quorum or stream, sets x-queue-type
+	SingleActiveConsumer bool   // sets x-single-active-consumer, not available on streams
+	ConsumerTimeoutMs    int    // sets x-consumer-timeout, not available on streams
+	MessageTTL           int    // milliseconds messages are kept in the queue, sets x-message-ttl
+	QueueTTL             int    // milliseconds the queue is kept while unused before it auto expires, sets x-expires
+	MaxLength            int    // ready messages kept before overflowing, sets x-max-length, not available on streams
+	MaxLengthBytes       int64  // ready message bytes kept before overflowing, sets x-max-length-bytes
+	Overflow             string // drop-head (default), reject-publish or reject-publish-dlx, sets x-overflow
+	Lazy                 bool   // keeps the messages on disk, sets x-queue-mode to lazy, classic queues only
+	Deduplication        bool   // drops the messages with the x-deduplication-header of a queued one, sets x-message-deduplication
+
+	OnDrift string // report or recreate an existing queue with different properties instead of failing
 }
 
 // QueueBindOptions provides options when binding a queue to an exchange in order to receive message(s).
@@ -32,6 +63,9 @@ type QueueBindOptions struct {
 	RoutingKey   string
 	NoWait       bool
 	Args         amqpDriver.Table
+	HeadersMatch string           // x-match mode for headers exchanges: all (default) or any
+	Headers      amqpDriver.Table // header values to match against when binding to a headers exchange
+	Weight       int              // share of the messages of an x-consistent-hash exchange, sets the routing key
 }
 
 // QueueUnbindOptions provides options when unbinding a queue from an exchange to stop receiving message(s).
@@ -40,30 +74,161 @@ type QueueUnbindOptions struct {
 	ExchangeName string
 	RoutingKey   string
 	Args         amqpDriver.Table
+	HeadersMatch string           // x-match mode for headers exchanges: all (default) or any
+	Headers      amqpDriver.Table // header values of the headers exchange binding to remove
+}
+
+// QueueDeleteOptions provides the conditions a queue must meet to be deleted.
+type QueueDeleteOptions struct {
+	IfUnused bool // fail instead of deleting a queue with consumers
+	IfEmpty  bool // fail instead of deleting a queue with ready messages
+	NoWait   bool
 }
 
 // Declare creates a new queue given the provided options.
-func (queue *Queue) Declare(options DeclareOptions) (amqpDriver.Queue, error) {
-	ch, err := queue.Connection.Channel()
+func (queue *Queue) Declare(options DeclareOptions) (q amqpDriver.Queue, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	span := queue.tracer.start(options.Name+" declare", spanClient, "", map[string]string{
+		"messaging.system":           "rabbitmq",
+		"messaging.destination.name": options.Name,
+	})
+	defer func() {
+		span.end(err)
+		queue.events.emit("declare", map[string]interface{}{"kind": "queue", "name": options.Name, "error": err})
+	}()
+
+	if options.Passive {
+		return queue.declarePassive(options.Name)
+	}
+
+	args, queueType, err := options.queueTypeArgs()
+	if err != nil {
+		return amqpDriver.Queue{}, err
+	}
+	if err = queue.profile.checkQueueType(queueType); err != nil {
+		return amqpDriver.Queue{}, err
+	}
+	if err = checkDriftMode(options.OnDrift); err != nil {
+		return amqpDriver.Queue{}, err
+	}
+
+	ch, err := queue.channel()
 	if err != nil {
 		return amqpDriver.Queue{}, err
 	}
 	defer func() {
 		_ = ch.Close()
 	}()
-	return ch.QueueDeclare(
-		options.Name,
-		options.Durable,
-		options.DeleteWhenUnused,
-		options.Exclusive,
-		options.NoWait,
-		options.Args,
+	declare := func() (amqpDriver.Queue, error) {
+		return ch.QueueDeclare(
+			options.Name,
+			options.Durable,
+			options.DeleteWhenUnused,
+			options.Exclusive,
+			options.NoWait,
+			args,
+		)
+	}
+
+	q, err = declare()
+	if isDrift(err) && options.OnDrift != driftFail {
+		queue.metrics.reportDrift("queue", options.Name, err)
+
+		// The failed declare closed the channel.
+		reopened, reopenErr := queue.channel()
+		if reopenErr != nil {
+			return amqpDriver.Queue{}, reopenErr
+		}
+		ch = reopened
+		if options.OnDrift == driftReport {
+			return ch.QueueDeclarePassive(options.Name, false, false, false, false, nil)
+		}
+		if _, err = ch.QueueDelete(options.Name, false, false, false); err != nil {
+			return amqpDriver.Queue{}, err
+		}
+		q, err = declare()
+	}
+	if err == nil && queueType != "" {
+		queue.types.Store(q.Name, queueType)
+	}
+	return q, err
+}
+
+// declarePassive returns the message and consumer counts of an existing queue without creating it.
+func (queue *Queue) declarePassive(name string) (amqpDriver.Queue, error) {
+	ch, err := queue.channel()
+	if err != nil {
+		return amqpDriver.Queue{}, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	return ch.QueueDeclarePassive(name, false, false, false, false, nil)
+}
+
+// Exists tells whether a queue exists with a passive declare, for preflight checks in setup.
+func (queue *Queue) Exists(name string) (exists bool, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if _, err = queue.declarePassive(name); isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WaitDeleted waits up to timeoutMs for a queue to be removed by the broker, telling whether it was, to assert
+// that a queue declared with a queue TTL (x-expires) or auto-delete goes away once unused.
+func (queue *Queue) WaitDeleted(name string, timeoutMs int) (deleted bool, err error) {
+	deleted, err = waitDeleted(queue.vu.Context(), timeoutMs, func() (bool, error) {
+		return queue.Exists(name)
+	})
+	return deleted, structuredError(err)
+}
+
+// declaredType returns the type of a queue declared by the VU, empty if unknown.
+func (queue *Queue) declaredType(name string) string {
+	queueType, _ := queue.types.Load(name)
+	s, _ := queueType.(string)
+	return s
+}
+
+// DeclareTemporary creates a server-named, exclusive and auto-delete queue and returns its generated name.
+// The queue is removed by the broker once the connection is closed.
+func (queue *Queue) DeclareTemporary() (name string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	q, err := ch.QueueDeclare(
+		"",    // name, generated by the server
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // noWait
+		nil,
 	)
+	return q.Name, err
 }
 
 // Inspect provides queue metadata given queue name.
-func (queue *Queue) Inspect(name string) (amqpDriver.Queue, error) {
-	ch, err := queue.Connection.Channel()
+func (queue *Queue) Inspect(name string) (q amqpDriver.Queue, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
 	if err != nil {
 		return amqpDriver.Queue{}, err
 	}
@@ -74,26 +239,45 @@ func (queue *Queue) Inspect(name string) (amqpDriver.Queue, error) {
 }
 
 // Delete removes a queue from the remote server given the queue name.
-func (queue *Queue) Delete(name string) error {
-	ch, err := queue.Connection.Channel()
+// The options are optional, by default the queue is deleted along with its messages and consumers.
+func (queue *Queue) Delete(name string, options QueueDeleteOptions) (count int, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		_ = ch.Close()
 	}()
-	_, err = ch.QueueDelete(
+	return ch.QueueDelete(
 		name,
-		false, // ifUnused
-		false, // ifEmpty
-		false, // noWait
+		options.IfUnused,
+		options.IfEmpty,
+		options.NoWait,
 	)
-	return err
 }
 
 // Bind subscribes a queue to an exchange in order to receive message(s).
-func (queue *Queue) Bind(options QueueBindOptions) error {
-	ch, err := queue.Connection.Channel()
+func (queue *Queue) Bind(options QueueBindOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := headersBindArgs(options.Args, options.HeadersMatch, options.Headers)
+	if err != nil {
+		return err
+	}
+	if options.Weight < 0 {
+		return fmt.Errorf("binding weight must be positive, got %d", options.Weight)
+	}
+	if options.Weight > 0 {
+		options.RoutingKey = strconv.Itoa(options.Weight)
+	}
+
+	ch, err := queue.channel()
 	if err != nil {
 		return err
 	}
@@ -105,13 +289,22 @@ func (queue *Queue) Bind(options QueueBindOptions) error {
 		options.RoutingKey,
 		options.ExchangeName,
 		options.NoWait,
-		options.Args,
+		args,
 	)
 }
 
 // Unbind removes a queue subscription from an exchange to discontinue receiving message(s).
-func (queue *Queue) Unbind(options QueueUnbindOptions) error {
-	ch, err := queue.Connection.Channel()
+func (queue *Queue) Unbind(options QueueUnbindOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := headersBindArgs(options.Args, options.HeadersMatch, options.Headers)
+	if err != nil {
+		return err
+	}
+
+	ch, err := queue.channel()
 	if err != nil {
 		return err
 	}
@@ -122,13 +315,17 @@ func (queue *Queue) Unbind(options QueueUnbindOptions) error {
 		options.QueueName,
 		options.RoutingKey,
 		options.ExchangeName,
-		options.Args,
+		args,
 	)
 }
 
 // Purge removes all non-consumed message(s) from the specified queue.
-func (queue *Queue) Purge(name string, noWait bool) (int, error) {
-	ch, err := queue.Connection.Channel()
+func (queue *Queue) Purge(name string, noWait bool) (count int, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
 	if err != nil {
 		return 0, err
 	}
@@ -137,3 +334,111 @@ func (queue *Queue) Purge(name string, noWait bool) (int, error) {
 	}()
 	return ch.QueuePurge(name, noWait)
 }
+
+// PartitionLag reports the number of ready messages in each partition queue of a super stream or sharded queue.
+// Each depth is emitted as the amqp_partition_lag gauge tagged with the queue name and its partition index.
+func (queue *Queue) PartitionLag(names []string) (lags []int, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	lags = make([]int, 0, len(names))
+	for i, name := range names {
+		q, err := ch.QueueInspect(name)
+		if err != nil {
+			return lags, err
+		}
+		lags = append(lags, q.Messages)
+		queue.metrics.push(queue.metrics.PartitionLag, float64(q.Messages), map[string]string{
+			"queue":     name,
+			"partition": strconv.Itoa(i),
+		})
+	}
+	return lags, nil
+}
+
+// BindMany binds a queue to an exchange with every routing key given, reusing a single channel.
+// All keys are attempted; failures are reported together once every binding was tried.
+func (queue *Queue) BindMany(queueName string, exchangeName string, keys []string) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	var failures []string
+	for _, key := range keys {
+		if err = ch.QueueBind(queueName, key, exchangeName, false, nil); err == nil {
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%q: %s", key, err))
+
+		// A failed bind closes the channel, so continue on a fresh one.
+		if ch, err = queue.channel(); err != nil {
+			return fmt.Errorf("binding %q to %q failed for %d of %d keys (%s), aborted: %w",
+				queueName, exchangeName, len(failures), len(keys), strings.Join(failures, "; "), err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("binding %q to %q failed for %d of %d keys: %s",
+			queueName, exchangeName, len(failures), len(keys), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// WaitForMessages polls a queue until it holds at least count ready messages, or drains down to count
+// when it currently holds more. The last observed number of messages is returned.
+func (queue *Queue) WaitForMessages(name string, count int, timeoutSec int) (messages int, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	ch, err := queue.channel()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	q, err := ch.QueueInspect(name)
+	if err != nil {
+		return 0, err
+	}
+	draining := q.Messages > count
+	reached := func(messages int) bool {
+		if draining {
+			return messages <= count
+		}
+		return messages >= count
+	}
+
+	deadline := time.Now().Add(time.Duration(timeoutSec) * time.Second)
+	for !reached(q.Messages) {
+		if time.Now().After(deadline) {
+			return q.Messages, fmt.Errorf("queue %q holds %d messages after %ds, expected %d", name, q.Messages, timeoutSec, count)
+		}
+		if err = sleepContext(queue.vu.Context(), waitForMessagesInterval); err != nil {
+			return q.Messages, err
+		}
+		if q, err = ch.QueueInspect(name); err != nil {
+			return 0, err
+		}
+	}
+	return q.Messages, nil
+}
@@ -0,0 +1,94 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultQueueDepthInterval = time.Second
+
+// queueWatches holds the queues of interest listed at Start, shared by the VUs so that the depth of a queue
+// is sampled by a single VU at a time, whatever the number of VUs listing it.
+type queueWatches struct {
+	mu      sync.Mutex
+	sampled map[string]context.Context // context of the iteration sampling the depth, by queue
+}
+
+// claim tells whether the depth of the queue is not sampled yet, or only by an iteration that is over, the
+// caller being in charge of it until ctx is done if so.
+func (w *queueWatches) claim(ctx context.Context, queue string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if owner, ok := w.sampled[queue]; ok && owner.Err() == nil {
+		return false
+	}
+	if w.sampled == nil {
+		w.sampled = make(map[string]context.Context)
+	}
+	w.sampled[queue] = ctx
+	return true
+}
+
+// release forgets the queues sampled until ctx was done, unless claimed by another iteration since.
+func (w *queueWatches) release(ctx context.Context, queues []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, queue := range queues {
+		if w.sampled[queue] == ctx {
+			delete(w.sampled, queue)
+		}
+	}
+}
+
+// watchQueues samples the depth of the queues of interest as amqp_queue_depth every intervalMs. The per
+// queue submetrics such as amqp_queue_depth{queue:orders} are not registered here, k6 only allows it before
+// the test starts: the thresholds on them create them, and break them down in the end of test summary.
+func (amqp *AMQP) watchQueues(queues []string, intervalMs int) error {
+	for _, queue := range queues {
+		if queue == "" {
+			return errors.New("the depth of a queue without a name cannot be sampled")
+		}
+	}
+
+	interval := time.Duration(intervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultQueueDepthInterval
+	}
+	ctx := amqp.vu.Context()
+	var claimed []string
+	for _, queue := range queues {
+		if amqp.watches.claim(ctx, queue) {
+			claimed = append(claimed, queue)
+		}
+	}
+	if len(claimed) > 0 {
+		go amqp.sampleDepth(ctx, claimed, interval)
+	}
+	return nil
+}
+
+// sampleDepth emits the number of ready messages of the queues until the iteration is done, the queues
+// being claimed by the next iteration listing them. A queue missing or failing to be inspected is skipped
+// until the next sample.
+func (amqp *AMQP) sampleDepth(ctx context.Context, queues []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer amqp.watches.release(ctx, queues)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, queue := range queues {
+			q, err := amqp.Queue.Inspect(queue)
+			if err != nil {
+				continue
+			}
+			amqp.metrics.push(amqp.metrics.QueueDepth, float64(q.Messages), map[string]string{"queue": queue})
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueueWatchesClaim(t *testing.T) {
+	t.Parallel()
+
+	var watches queueWatches
+	first, endFirst := context.WithCancel(context.Background())
+	second, endSecond := context.WithCancel(context.Background())
+	defer endSecond()
+
+	if !watches.claim(first, "orders") {
+		t.Fatal("the first iteration did not claim the queue")
+	}
+	if watches.claim(second, "orders") {
+		t.Fatal("the queue was claimed twice")
+	}
+	endFirst()
+	// The next iteration takes over even before the sampling of the previous one returned.
+	if !watches.claim(second, "orders") {
+		t.Fatal("the queue of an iteration over was not claimed")
+	}
+	watches.release(first, []string{"orders"})
+	if watches.claim(context.Background(), "orders") {
+		t.Fatal("the late release of the previous iteration freed the queue")
+	}
+	watches.release(second, []string{"orders"})
+	if !watches.claim(context.Background(), "orders") {
+		t.Fatal("the released queue was not claimed")
+	}
+}
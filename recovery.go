@@ -0,0 +1,99 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// ReconnectOptions configures the automatic recovery of a connection closed by the broker or the network.
+type ReconnectOptions struct {
+	Enabled     bool
+	MaxAttempts int // attempts before giving up, 0 (unlimited) by default
+	IntervalMs  int // pause between attempts, 1000 by default
+}
+
+const defaultReconnectInterval = time.Second
+
+// recoverOnClose reopens the connection each time it is lost until the VU context is done, handing the
+// new connection to replace. Channels and consumers of the lost connection are not restored, only the
+// connection used by subsequent calls is replaced.
+func (amqp *AMQP) recoverOnClose(conn *amqpDriver.Connection, replace func(*amqpDriver.Connection)) {
+	options := amqp.options.Reconnect
+	interval := defaultReconnectInterval
+	if options.IntervalMs > 0 {
+		interval = time.Duration(options.IntervalMs) * time.Millisecond
+	}
+	ctx := amqp.vu.Context()
+	tags := map[string]string{}
+
+	for {
+		closeErr, ok := <-conn.NotifyClose(make(chan *amqpDriver.Error, 1))
+		if !ok || closeErr == nil || ctx.Err() != nil {
+			// Closed by the script or at the end of the test.
+			return
+		}
+
+		lost := time.Now()
+		var err error
+		var url string
+		for attempt := 1; options.MaxAttempts <= 0 || attempt <= options.MaxAttempts; attempt++ {
+			amqp.metrics.push(amqp.metrics.ReconnectAttempts, 1, tags)
+			if conn, url, err = amqp.dialNodes(); err == nil {
+				break
+			}
+			if sleepContext(ctx, interval) != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		amqp.metrics.push(amqp.metrics.ReconnectDuration, sinceMs(lost), tags)
+		amqp.metrics.push(amqp.metrics.Reconnects, 1, tags)
+		amqp.spendBudget(func(status *ErrorBudgetStatus) {
+			status.Reconnects++
+		})
+		amqp.events.emit("reconnect", map[string]interface{}{"host": brokerAddress(url), "duration_ms": sinceMs(lost)})
+		amqp.events.watchConnection(conn)
+		if amqp.token != nil {
+			go amqp.token.refresh(ctx, conn)
+		}
+		replace(conn)
+	}
+}
+
+// sessionConnection is the connection of a session, shared by the module and its submodules. The recovery
+// replaces it while the VU uses it.
+type sessionConnection struct {
+	mu     sync.RWMutex
+	conn   *amqpDriver.Connection
+	shared *sessionConnection // connection of a shared pool followed instead of conn
+}
+
+func (c *sessionConnection) get() *amqpDriver.Connection {
+	c.mu.RLock()
+	conn, shared := c.conn, c.shared
+	c.mu.RUnlock()
+	if shared != nil {
+		return shared.get()
+	}
+	return conn
+}
+
+func (c *sessionConnection) set(conn *amqpDriver.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
+	c.shared = nil
+}
+
+// share makes the session follow a connection of a shared pool, replaced as the pool recovers it.
+func (c *sessionConnection) share(shared *sessionConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	c.shared = shared
+}
@@ -0,0 +1,122 @@
+package amqp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.k6.io/k6/js/modulestest"
+)
+
+// dropProxy forwards TCP connections to a broker and drops them all on demand, as a network failure would.
+type dropProxy struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newDropProxy(t *testing.T, target string) *dropProxy {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &dropProxy{listener: listener, target: target}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		p.drop()
+	})
+	go p.accept()
+	return p
+}
+
+func (p *dropProxy) accept() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			_ = client.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, client, server)
+		p.mu.Unlock()
+		go func() {
+			_, _ = io.Copy(server, client)
+			_ = server.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(client, server)
+			_ = client.Close()
+		}()
+	}
+}
+
+func (p *dropProxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func TestRecoveryReplacesConnectionInUse(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	root := &RootModule{}
+	amqp := root.instance(rt.VU)
+	brokerURL, err := root.loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := brokerURL[len("amqp://guest:guest@") : len(brokerURL)-1]
+	proxy := newDropProxy(t, target)
+
+	err = amqp.Start(Options{
+		ConnectionURL: "amqp://guest:guest@" + proxy.listener.Addr().String() + "/",
+		Reconnect:     ReconnectOptions{Enabled: true, IntervalMs: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost := amqp.connection.get()
+
+	// The VU keeps using the connection while the recovery replaces it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if conn := amqp.connection.get(); conn != lost && !conn.IsClosed() {
+				return
+			}
+			_, _ = amqp.Queue.channel()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	proxy.drop()
+	<-done
+
+	recovered := amqp.connection.get()
+	if recovered == lost || recovered.IsClosed() {
+		t.Fatal("the connection was not recovered")
+	}
+	if amqp.Exchange.connection.get() != recovered {
+		t.Error("the exchange submodule does not use the recovered connection")
+	}
+	ch, err := amqp.Queue.channel()
+	if err != nil {
+		t.Fatalf("opening a channel on the recovered connection: %v", err)
+	}
+	_ = ch.Close()
+}
@@ -0,0 +1,196 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	avroContentType = "avro/binary"
+	avroMagicByte   = 0
+	avroHeaderSize  = 5 // magic byte and big endian schema id
+)
+
+// SchemaRegistryOptions defines a Confluent compatible schema registry holding the Avro schemas of the messages.
+type SchemaRegistryOptions struct {
+	Url      string
+	Username string // basic authentication, e.g. the API key of Confluent Cloud
+	Password string
+}
+
+// schemaRegistry caches the schemas looked up by all the VUs, each schema is fetched once per test run.
+type schemaRegistry struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	subjects map[string]registrySchema // url|subject|version -> schema
+	ids      map[string]*avroSchema    // url|id -> schema
+}
+
+type registrySchema struct {
+	id     int
+	schema *avroSchema
+}
+
+// registryResponse is the schema returned by GET /subjects/{subject}/versions/{version} and /schemas/ids/{id}.
+type registryResponse struct {
+	ID         int    `json:"id"`
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+// subject returns the schema registered under a subject, its latest version if version is not positive.
+func (r *schemaRegistry) subject(ctx context.Context, options SchemaRegistryOptions, subject string, version int) (registrySchema, error) {
+	v := "latest"
+	if version > 0 {
+		v = strconv.Itoa(version)
+	}
+	key := options.Url + "|" + subject + "|" + v
+
+	r.mu.Lock()
+	cached, ok := r.subjects[key]
+	r.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	res, err := r.get(ctx, options, "/subjects/"+url.PathEscape(subject)+"/versions/"+v)
+	if err != nil {
+		return registrySchema{}, err
+	}
+	schema, err := parseAvroSchema(res.Schema)
+	if err != nil {
+		return registrySchema{}, fmt.Errorf("subject %s version %s: %w", subject, v, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subjects == nil {
+		r.subjects = make(map[string]registrySchema)
+	}
+	r.subjects[key] = registrySchema{id: res.ID, schema: schema}
+	return r.subjects[key], nil
+}
+
+// id returns the schema registered with an id.
+func (r *schemaRegistry) id(ctx context.Context, options SchemaRegistryOptions, id int) (*avroSchema, error) {
+	key := options.Url + "|" + strconv.Itoa(id)
+
+	r.mu.Lock()
+	cached, ok := r.ids[key]
+	r.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	res, err := r.get(ctx, options, "/schemas/ids/"+strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+	schema, err := parseAvroSchema(res.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.ids = make(map[string]*avroSchema)
+	}
+	r.ids[key] = schema
+	return schema, nil
+}
+
+func (r *schemaRegistry) get(ctx context.Context, options SchemaRegistryOptions, path string) (registryResponse, error) {
+	if options.Url == "" {
+		return registryResponse{}, fmt.Errorf("no schema registry, set schema_registry.url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(options.Url, "/")+path, nil)
+	if err != nil {
+		return registryResponse{}, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if options.Username != "" {
+		req.SetBasicAuth(options.Username, options.Password)
+	}
+
+	r.mu.Lock()
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	client := r.client
+	r.mu.Unlock()
+
+	res, err := client.Do(req)
+	if err != nil {
+		return registryResponse{}, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return registryResponse{}, fmt.Errorf("schema registry request %s failed: %s", path, res.Status)
+	}
+
+	var schema registryResponse
+	if err = json.NewDecoder(res.Body).Decode(&schema); err != nil {
+		return registryResponse{}, err
+	}
+	if schema.SchemaType != "" && schema.SchemaType != "AVRO" {
+		return registryResponse{}, fmt.Errorf("schema registry request %s returned a %s schema, only AVRO is supported", path, schema.SchemaType)
+	}
+	return schema, nil
+}
+
+// encodeAvro encodes a JSON body with the schema of the subject, framed with the magic byte and the schema id.
+func (amqp *AMQP) encodeAvro(subject string, version int, body []byte) ([]byte, error) {
+	registered, err := amqp.registry.subject(amqp.vu.Context(), amqp.options.SchemaRegistry, subject, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(avroMagicByte)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], uint32(registered.id))
+	buf.Write(id[:])
+	if err = registered.schema.encode(&buf, value); err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", subject, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeAvro decodes a framed Avro body to JSON with the schema of the id it carries.
+func (amqp *AMQP) decodeAvro(body []byte) ([]byte, error) {
+	if len(body) < avroHeaderSize || body[0] != avroMagicByte {
+		return nil, fmt.Errorf("avro body without the schema registry framing")
+	}
+	id := int(binary.BigEndian.Uint32(body[1:avroHeaderSize]))
+	schema, err := amqp.registry.id(amqp.vu.Context(), amqp.options.SchemaRegistry, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &avroReader{buf: body[avroHeaderSize:]}
+	value := schema.decode(r)
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid avro body for schema %d: %w", id, r.err)
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,112 @@
+package amqp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of replication links.
+const (
+	linkFederation = "federation"
+	linkShovel     = "shovel"
+)
+
+// linkRunning is the state of a federation link or a shovel moving messages.
+const linkRunning = "running"
+
+const linkPollInterval = 500 * time.Millisecond
+
+// ReplicationLink is a federation link or a shovel moving messages between brokers, e.g. across datacenters.
+type ReplicationLink struct {
+	Kind    string // federation or shovel
+	Name    string // upstream and federated exchange or queue of a federation link, name of a shovel
+	Vhost   string
+	Node    string // node running the link
+	State   string // running, starting, error, shutdown or terminated
+	Running bool
+	Error   string // why the link is not running, if reported
+}
+
+// FederationLinks returns the federation links of a virtual host, or of all virtual hosts if vhost is empty.
+// The federation management plugin must be enabled.
+func (management *Management) FederationLinks(vhost string) ([]ReplicationLink, error) {
+	items, err := management.list(vhostPath("/api/federation-links", vhost))
+	if err != nil {
+		return nil, err
+	}
+	links := make([]ReplicationLink, 0, len(items))
+	for _, item := range items {
+		target, _ := item["exchange"].(string)
+		if queue, ok := item["queue"].(string); ok {
+			target = queue
+		}
+		link := replicationLink(linkFederation, item, "status", "error")
+		link.Name = fmt.Sprintf("%v/%s", item["upstream"], target)
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// Shovels returns the shovels of a virtual host, or of all virtual hosts if vhost is empty.
+// The shovel management plugin must be enabled.
+func (management *Management) Shovels(vhost string) ([]ReplicationLink, error) {
+	items, err := management.list(vhostPath("/api/shovels", vhost))
+	if err != nil {
+		return nil, err
+	}
+	links := make([]ReplicationLink, 0, len(items))
+	for _, item := range items {
+		link := replicationLink(linkShovel, item, "state", "reason")
+		link.Name, _ = item["name"].(string)
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// replicationLink reads the fields shared by federation links and shovels, which report their state and
+// failure under different keys.
+func replicationLink(kind string, item map[string]interface{}, stateKey string, errorKey string) ReplicationLink {
+	link := ReplicationLink{Kind: kind}
+	link.Vhost, _ = item["vhost"].(string)
+	link.Node, _ = item["node"].(string)
+	link.State, _ = item[stateKey].(string)
+	link.Running = link.State == linkRunning
+	if reason, ok := item[errorKey]; ok && reason != nil {
+		link.Error = fmt.Sprint(reason)
+	}
+	return link
+}
+
+// WaitForLinks polls the federation links and the shovels of a virtual host, or of all virtual hosts if vhost
+// is empty, until every one of them is running, failing with the links still down after the timeout. The
+// links of a plugin which is not enabled are ignored. Called after a load phase, it asserts that replication
+// kept up or recovered from the load.
+func (management *Management) WaitForLinks(vhost string, timeoutSec int) error {
+	deadline := time.Now().Add(time.Duration(timeoutSec) * time.Second)
+	for {
+		federation, err := management.FederationLinks(vhost)
+		if err != nil && !isManagementNotFound(err) {
+			return err
+		}
+		shovels, err := management.Shovels(vhost)
+		if err != nil && !isManagementNotFound(err) {
+			return err
+		}
+		var down []string
+		for _, link := range append(federation, shovels...) {
+			if !link.Running {
+				down = append(down, fmt.Sprintf("%s %s (%s)", link.Kind, link.Name, link.State))
+			}
+		}
+		if len(down) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("links not running within %ds: %s", timeoutSec, strings.Join(down, ", "))
+		}
+		if err = sleepContext(management.vu.Context(), linkPollInterval); err != nil {
+			return err
+		}
+	}
+}
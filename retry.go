@@ -0,0 +1,156 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// headerIdempotencyKey identifies every copy of a message republished by the retry policy.
+const headerIdempotencyKey = "x-k6-idempotency-key"
+
+const (
+	defaultRetryInterval = 100 * time.Millisecond
+	retryReconcileWindow = 5 * time.Minute
+)
+
+// PublishRetry defines how a publish is retried after a failure. By default only ambiguous failures are
+// retried, when it is unknown whether the broker got the message: confirm or publish timeout, or channel or
+// connection closed. Each attempt carries the same x-k6-idempotency-key header, so consumers can tell a
+// retried message from a new one.
+type PublishRetry struct {
+	MaxAttempts   int      // attempts including the first one, retries are disabled below 2
+	IntervalMs    int      // pause before the first retry, 100 by default
+	Backoff       float64  // factor applied to the pause after every retry, 1 (constant pause) by default
+	MaxIntervalMs int      // upper bound of the backed off pause, none by default
+	On            []string // failure classes retried: timeout, channel_close, connection_close (the default), nack or return
+}
+
+//nolint:gochecknoglobals
+var retryClasses = map[string]bool{
+	publishFailureTimeout:         true,
+	publishFailureChannelClose:    true,
+	publishFailureConnectionClose: true,
+	publishFailureNack:            true,
+	publishFailureReturn:          true,
+}
+
+// check fails for unknown failure classes and backoff factors below 1.
+func (retry PublishRetry) check() error {
+	for _, class := range retry.On {
+		if !retryClasses[class] {
+			return fmt.Errorf("unknown retried failure %q, expected timeout, channel_close, connection_close, nack or return", class)
+		}
+	}
+	if retry.Backoff != 0 && retry.Backoff < 1 {
+		return fmt.Errorf("retry backoff %v must be at least 1", retry.Backoff)
+	}
+	return nil
+}
+
+// retried returns the class of a failure and whether the policy retries it.
+func (retry PublishRetry) retried(err error) (string, bool) {
+	class, ambiguous := ambiguousFailure(err)
+	if len(retry.On) == 0 || class == "" {
+		return class, ambiguous
+	}
+	for _, retriedClass := range retry.On {
+		if class == retriedClass {
+			return class, true
+		}
+	}
+	return class, false
+}
+
+// next returns the pause following the given one.
+func (retry PublishRetry) next(interval time.Duration) time.Duration {
+	if retry.Backoff > 1 {
+		interval = time.Duration(float64(interval) * retry.Backoff)
+	}
+	if maxInterval := time.Duration(retry.MaxIntervalMs) * time.Millisecond; maxInterval > 0 && interval > maxInterval {
+		interval = maxInterval
+	}
+	return interval
+}
+
+// publishRetrying publishes the message, republishing it on a fresh channel after a retried failure.
+// A channel failing to open, e.g. while the session reconnects, counts as a failed attempt. The channel
+// eventually used is returned so that the caller closes it.
+func (amqp *AMQP) publishRetrying(ch *amqpDriver.Channel, options PublishOptions) (*amqpDriver.Channel, error) {
+	if _, ok := options.Headers[headerIdempotencyKey]; !ok {
+		options.Headers = withField(options.Headers, headerIdempotencyKey, newUUID())
+	}
+	interval := defaultRetryInterval
+	if options.Retry.IntervalMs > 0 {
+		interval = time.Duration(options.Retry.IntervalMs) * time.Millisecond
+	}
+
+	var openErr error
+	for attempt := 1; ; attempt++ {
+		err := openErr
+		if err == nil {
+			err = amqp.publish(ch, options)
+		}
+		class, retried := options.Retry.retried(err)
+		if !retried || attempt >= options.Retry.MaxAttempts {
+			return ch, err
+		}
+		amqp.metrics.push(amqp.metrics.PublishRetries, 1, map[string]string{"class": class})
+		if sleepContext(amqp.vu.Context(), interval) != nil {
+			return ch, err
+		}
+		interval = options.Retry.next(interval)
+
+		// Confirms of the failed attempt may still arrive on the previous channel.
+		var reopened *amqpDriver.Channel
+		if reopened, openErr = amqp.channel(); openErr != nil {
+			openErr = fmt.Errorf("%w, reopening the channel: %s", err, openErr)
+			continue
+		}
+		_ = ch.Close()
+		ch = reopened
+	}
+}
+
+// ambiguousFailure returns the class of a failure leaving unknown whether the broker got the message.
+func ambiguousFailure(err error) (string, bool) {
+	var publishErr *PublishError
+	if errors.As(err, &publishErr) {
+		switch publishErr.Class {
+		case publishFailureTimeout, publishFailureChannelClose, publishFailureConnectionClose:
+			return publishErr.Class, true
+		}
+		return publishErr.Class, false
+	}
+	if errors.Is(err, amqpDriver.ErrClosed) {
+		return publishFailureChannelClose, true
+	}
+	if isTimeout(err, operationPublish) {
+		return publishFailureTimeout, true
+	}
+	return "", false
+}
+
+// retryTracker remembers the idempotency keys consumed by all the VUs, so that the copies of a message
+// delivered more than once because of a retry are not counted as throughput.
+type retryTracker struct {
+	mu     sync.Mutex
+	window *dedupWindow
+}
+
+// duplicate reports whether a copy of the delivery was already consumed, false for messages without key.
+func (tracker *retryTracker) duplicate(d amqpDriver.Delivery) bool {
+	if _, ok := d.Headers[headerIdempotencyKey]; !ok {
+		return false
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.window == nil {
+		tracker.window = newDedupWindow(retryReconcileWindow, headerIdempotencyKey)
+	}
+	return tracker.window.duplicate(d)
+}
@@ -0,0 +1,51 @@
+package amqp
+
+import (
+	"sync"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/lib"
+)
+
+// Headers stamped on published messages when the RunMetadata option is set.
+const (
+	headerTestRunID = "x-k6-test-run-id"
+	headerScenario  = "x-k6-scenario"
+	headerVU        = "x-k6-vu"
+	headerIteration = "x-k6-iteration"
+)
+
+//nolint:gochecknoglobals
+var (
+	processTestRunID     string
+	processTestRunIDOnce sync.Once
+)
+
+// testRunID returns the configured test run id, or one generated once per k6 process.
+func (amqp *AMQP) testRunID() string {
+	if amqp.options.TestRunId != "" {
+		return amqp.options.TestRunId
+	}
+	processTestRunIDOnce.Do(func() {
+		processTestRunID = newUUID()
+	})
+	return processTestRunID
+}
+
+// withRunMetadata returns the headers stamped with the test run metadata of the current VU.
+func (amqp *AMQP) withRunMetadata(headers amqpDriver.Table) amqpDriver.Table {
+	stamped := make(amqpDriver.Table, len(headers)+4)
+	for k, v := range headers {
+		stamped[k] = v
+	}
+
+	stamped[headerTestRunID] = amqp.testRunID()
+	if state := amqp.vu.State(); state != nil {
+		stamped[headerVU] = int64(state.VUID)
+		stamped[headerIteration] = state.Iteration
+	}
+	if scenario := lib.GetScenarioState(amqp.vu.Context()); scenario != nil {
+		stamped[headerScenario] = scenario.Name
+	}
+	return stamped
+}
@@ -0,0 +1,69 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+)
+
+// activated records the consumer of the group a delivery went to. On a single active consumer queue, only
+// the active consumer gets deliveries, so the first delivery to another consumer after the active one was
+// lost completes a failover, whose duration is returned.
+func (group *ConsumerGroup) activated(consumer string) (failoverMs float64, failover bool) {
+	group.activeMu.Lock()
+	defer group.activeMu.Unlock()
+
+	if consumer == group.active || group.cancelledConsumers[consumer] {
+		// Deliveries buffered before a cancel do not make the consumer active again.
+		return 0, false
+	}
+	group.active = consumer
+	if group.lostAt.IsZero() {
+		return 0, false
+	}
+	failoverMs = sinceMs(group.lostAt)
+	group.lostAt = time.Time{}
+	return failoverMs, true
+}
+
+// deactivated records a consumer of the group cancelled by the script or the broker, starting a failover if
+// it was the active one.
+func (group *ConsumerGroup) deactivated(consumer string) {
+	group.activeMu.Lock()
+	defer group.activeMu.Unlock()
+
+	if group.cancelledConsumers == nil {
+		group.cancelledConsumers = make(map[string]bool)
+	}
+	group.cancelledConsumers[consumer] = true
+	if consumer == group.active {
+		group.active = ""
+		group.lostAt = time.Now()
+	}
+}
+
+// Active returns the tag of the consumer of the group which got the latest delivery, the active consumer
+// of a single active consumer queue. It is empty before the first delivery and once the active consumer
+// is cancelled, until the next one gets a delivery.
+func (group *ConsumerGroup) Active() string {
+	group.activeMu.Lock()
+	defer group.activeMu.Unlock()
+	return group.active
+}
+
+// Cancel stops a single consumer of the group with basic.cancel, e.g. the active consumer to trigger a single
+// active consumer failover. Its buffered deliveries are still processed.
+func (group *ConsumerGroup) Cancel(consumer string) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	for i, tag := range group.consumers {
+		if tag != consumer {
+			continue
+		}
+		// Deactivate first so that the failover includes the cancel round trip.
+		group.deactivated(consumer)
+		return group.channels[i%len(group.channels)].Cancel(consumer, false)
+	}
+	return fmt.Errorf("unknown consumer %q", consumer)
+}
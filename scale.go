@@ -0,0 +1,216 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/time/rate"
+)
+
+// scaleBuckets is the number of buckets latencies are averaged in to show degradation over a scale run.
+const scaleBuckets = 10
+
+// QueueScaleOptions defines a run declaring a large number of queues.
+type QueueScaleOptions struct {
+	Prefix       string  // queues are named <prefix>-<index>, "k6-scale" by default
+	Count        int     // number of queues to declare
+	Rate         float64 // declarations per second, unlimited if 0
+	ExchangeName string  // when set, every queue is bound to this exchange
+	RoutingKey   string  // routing key used for the bindings, the queue name if empty
+	Durable      bool
+	Args         amqpDriver.Table
+	Cleanup      bool // delete the queues once the run completes
+}
+
+// ScaleResult summarises a scalability run.
+type ScaleResult struct {
+	Count     int       // operations completed
+	Duration  float64   // total run time in milliseconds
+	Rate      float64   // operations completed per second
+	Latencies []float64 // average operation latency in milliseconds for each tenth of the run
+}
+
+// DeclareScale declares queues (and bindings) at a controlled rate, emitting the
+// amqp_queue_declare_duration metric so latency degradation can be observed as the broker fills up.
+func (queue *Queue) DeclareScale(options QueueScaleOptions) (result ScaleResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = "k6-scale"
+	}
+
+	ch, err := queue.channel()
+	if err != nil {
+		return ScaleResult{}, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	names := make([]string, 0, options.Count)
+	latencies := make([]float64, 0, options.Count)
+	limiter := scaleLimiter(options.Rate)
+	start := time.Now()
+	for i := 0; i < options.Count; i++ {
+		if err = limiter.Wait(queue.vu.Context()); err != nil {
+			break
+		}
+
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		opStart := time.Now()
+		if _, err = ch.QueueDeclare(name, options.Durable, false, false, false, options.Args); err != nil {
+			break
+		}
+		names = append(names, name)
+		if options.ExchangeName != "" {
+			key := options.RoutingKey
+			if key == "" {
+				key = name
+			}
+			if err = ch.QueueBind(name, key, options.ExchangeName, false, nil); err != nil {
+				break
+			}
+		}
+
+		latency := sinceMs(opStart)
+		latencies = append(latencies, latency)
+		queue.metrics.push(queue.metrics.QueueDeclareDuration, latency, nil)
+	}
+	result = scaleResult(latencies, start)
+
+	if options.Cleanup {
+		if cleanupErr := queue.deleteAll(names); err == nil {
+			err = cleanupErr
+		}
+	}
+	return result, err
+}
+
+// deleteAll removes the given queues on a dedicated channel.
+func (queue *Queue) deleteAll(names []string) error {
+	ch, err := queue.channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	for _, name := range names {
+		if _, err = ch.QueueDelete(name, false, false, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleLimiter returns a limiter for the given operations per second, unlimited if rate is not positive.
+func scaleLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// scaleResult averages operation latencies into buckets covering each tenth of the run.
+func scaleResult(latencies []float64, start time.Time) ScaleResult {
+	result := ScaleResult{
+		Count:    len(latencies),
+		Duration: sinceMs(start),
+	}
+	if len(latencies) == 0 {
+		return result
+	}
+	if result.Duration > 0 {
+		result.Rate = float64(result.Count) / result.Duration * 1000
+	}
+
+	buckets := scaleBuckets
+	if len(latencies) < buckets {
+		buckets = len(latencies)
+	}
+	for b := 0; b < buckets; b++ {
+		from, to := b*len(latencies)/buckets, (b+1)*len(latencies)/buckets
+		sum := 0.0
+		for _, latency := range latencies[from:to] {
+			sum += latency
+		}
+		result.Latencies = append(result.Latencies, sum/float64(to-from))
+	}
+	return result
+}
+
+// ConsumerScaleOptions defines a run attaching a large number of consumers.
+type ConsumerScaleOptions struct {
+	QueueName   string
+	Count       int     // number of consumers to attach
+	Rate        float64 // basic.consume calls per second, unlimited if 0
+	Connections int     // connections the consumers are spread across, 1 by default
+	Channels    int     // channels opened on each connection, 1 by default
+	HoldSec     int     // how long to keep the consumers attached before closing them
+}
+
+// ConsumeScale attaches consumers across pooled connections and channels at a controlled rate,
+// emitting the amqp_consume_register_duration metric for every basic.consume call.
+func (amqp *AMQP) ConsumeScale(options ConsumerScaleOptions) (result ScaleResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	connections := options.Connections
+	if connections <= 0 {
+		connections = 1
+	}
+	channelsPerConnection := options.Channels
+	if channelsPerConnection <= 0 {
+		channelsPerConnection = 1
+	}
+
+	var channels []*amqpDriver.Channel
+	for i := 0; i < connections; i++ {
+		conn, err := amqp.dial()
+		if err != nil {
+			return ScaleResult{}, err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		for j := 0; j < channelsPerConnection; j++ {
+			ch, err := conn.Channel()
+			if err != nil {
+				return ScaleResult{}, err
+			}
+			channels = append(channels, ch)
+		}
+	}
+
+	latencies := make([]float64, 0, options.Count)
+	limiter := scaleLimiter(options.Rate)
+	start := time.Now()
+	for i := 0; i < options.Count; i++ {
+		if err = limiter.Wait(amqp.vu.Context()); err != nil {
+			break
+		}
+
+		opStart := time.Now()
+		ch := channels[i%len(channels)]
+		if _, err = ch.Consume(options.QueueName, fmt.Sprintf("k6-scale-%d", i), false, false, false, false, nil); err != nil {
+			break
+		}
+
+		latency := sinceMs(opStart)
+		latencies = append(latencies, latency)
+		amqp.metrics.push(amqp.metrics.ConsumeRegisterDuration, latency, map[string]string{"queue": options.QueueName})
+	}
+	result = scaleResult(latencies, start)
+
+	if err == nil && options.HoldSec > 0 {
+		err = sleepContext(amqp.vu.Context(), time.Duration(options.HoldSec)*time.Second)
+	}
+	return result, err
+}
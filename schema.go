@@ -0,0 +1,237 @@
+package amqp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema supported to validate consumed bodies: type, enum, const,
+// required, properties, additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// minimum, maximum and pattern. Other keywords are ignored.
+type jsonSchema struct {
+	Type                 interface{}            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                interface{}            `json:"const"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Pattern              string                 `json:"pattern"`
+
+	types   []string
+	pattern *regexp.Regexp
+}
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// compileSchema parses a JSON Schema given as a JS object once, so validating a body does not involve the JS runtime.
+func compileSchema(definition map[string]interface{}) (*jsonSchema, error) {
+	raw, err := json.Marshal(definition)
+	if err != nil {
+		return nil, err
+	}
+	schema := &jsonSchema{}
+	if err = json.Unmarshal(raw, schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err = schema.compile("#"); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (s *jsonSchema) compile(path string) error {
+	switch t := s.Type.(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, _ := v.(string)
+			s.types = append(s.types, name)
+		}
+	default:
+		return fmt.Errorf("invalid schema type at %s", path)
+	}
+	for _, name := range s.types {
+		if !schemaTypes[name] {
+			return fmt.Errorf("unknown schema type %q at %s", name, path)
+		}
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern at %s: %w", path, err)
+		}
+		s.pattern = pattern
+	}
+	for name, property := range s.Properties {
+		if err := property.compile(path + "/properties/" + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "/items")
+	}
+	return nil
+}
+
+// validateBody checks a JSON body against the schema.
+func (s *jsonSchema) validateBody(body []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+	return s.validate(value, "$")
+}
+
+func (s *jsonSchema) validate(value interface{}, path string) error {
+	if len(s.types) > 0 && !s.hasType(value) {
+		return fmt.Errorf("%s: expected %s", path, strings.Join(s.types, " or "))
+	}
+	if s.Enum != nil && !containsValue(s.Enum, value) {
+		return fmt.Errorf("%s: value not in enum", path)
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, value) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s: expected at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s: expected at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: expected at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: expected at most %d characters", path, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: does not match pattern %s", path, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: expected a minimum of %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: expected a maximum of %v", path, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(object map[string]interface{}, path string) error {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same violation for the same body
+	for _, name := range names {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%s: unexpected property %q", path, name)
+			}
+			continue
+		}
+		if err := property.validate(object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSchema validates a consumed body, counting violations with the amqp_validation_failures metric.
+// A nil schema accepts every body.
+func (amqp *AMQP) checkSchema(schema *jsonSchema, body []byte, tags map[string]string) {
+	if schema == nil {
+		return
+	}
+	if err := schema.validateBody(body); err != nil {
+		amqp.metrics.push(amqp.metrics.ValidationFailures, 1, tags)
+	}
+}
+
+// compileOptionalSchema compiles the schema of consume options if one is given.
+func compileOptionalSchema(definition map[string]interface{}) (*jsonSchema, error) {
+	if definition == nil {
+		return nil, nil
+	}
+	return compileSchema(definition)
+}
+
+func (s *jsonSchema) hasType(value interface{}) bool {
+	for _, name := range s.types {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
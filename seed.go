@@ -0,0 +1,142 @@
+package amqp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// SeedManifest describes a dataset published by Seed, it can be returned from setup to verify it in another phase.
+type SeedManifest struct {
+	QueueName string
+	Messages  []SeedEntry
+}
+
+// SeedEntry identifies a seeded message and the checksum of its body.
+type SeedEntry struct {
+	MessageId string
+	Checksum  string // hex encoded SHA-256 of the body
+}
+
+// SeedVerification reports how a seeded dataset was consumed.
+type SeedVerification struct {
+	Expected   int
+	Received   int
+	Missing    []string // ids of the messages not received within the timeout
+	Corrupted  []string // ids of the messages received with a different body
+	Duplicates int      // seeded messages received more than once
+	Unexpected int      // messages received that are not part of the dataset, left unacknowledged
+}
+
+// Seed publishes the messages to a queue through the default exchange, waits for the broker to confirm
+// them and returns the manifest of the dataset. Message ids are derived from the queue name and the
+// position of the message, so seeding the same dataset twice produces the same manifest.
+func (amqp *AMQP) Seed(queueName string, messages []string) (manifest SeedManifest, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	manifest = SeedManifest{QueueName: queueName, Messages: make([]SeedEntry, 0, len(messages))}
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return manifest, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	listeners, err := listenConfirms(ch)
+	if err != nil {
+		return manifest, err
+	}
+
+	for i, body := range messages {
+		entry := SeedEntry{MessageId: fmt.Sprintf("seed-%s-%d", queueName, i), Checksum: checksum([]byte(body))}
+		pending := pendingConfirm{tag: ch.GetNextPublishSeqNo(), messageID: entry.MessageId}
+		err = amqp.publish(ch, PublishOptions{
+			QueueName: queueName,
+			Body:      body,
+			MessageId: entry.MessageId,
+		})
+		if err != nil {
+			return manifest, err
+		}
+		if err = amqp.awaitConfirm(listeners, pending, 0, consumeTags(queueName, "")); err != nil {
+			return manifest, err
+		}
+		manifest.Messages = append(manifest.Messages, entry)
+	}
+	return manifest, nil
+}
+
+// VerifySeed consumes the queue of a seeded dataset until every message was received or the timeout
+// elapsed, acknowledging the seeded messages and checking their bodies against the manifest.
+func (amqp *AMQP) VerifySeed(manifest SeedManifest, timeoutSec int) (result SeedVerification, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	result = SeedVerification{Expected: len(manifest.Messages), Missing: []string{}, Corrupted: []string{}}
+	pending := make(map[string]string, len(manifest.Messages))
+	for _, entry := range manifest.Messages {
+		pending[entry.MessageId] = entry.Checksum
+	}
+	received := make(map[string]bool, len(manifest.Messages))
+
+	ch, err := amqp.channel()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	msgs, err := ch.Consume(manifest.QueueName, "", false, false, false, false, nil)
+	if err != nil {
+		return result, err
+	}
+
+	timeout := time.NewTimer(time.Duration(timeoutSec) * time.Second)
+	defer timeout.Stop()
+	for len(pending) > 0 {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return result, amqp.closeFailure(nil)
+			}
+			sum, seeded := pending[d.MessageId]
+			switch {
+			case seeded:
+				delete(pending, d.MessageId)
+				received[d.MessageId] = true
+				result.Received++
+				if body, decodeErr := decompress(d.Body, d.ContentEncoding); decodeErr != nil || checksum(body) != sum {
+					result.Corrupted = append(result.Corrupted, d.MessageId)
+				}
+			case received[d.MessageId]:
+				result.Duplicates++
+			default:
+				result.Unexpected++
+				continue
+			}
+			if err = d.Ack(false); err != nil {
+				return result, err
+			}
+		case <-timeout.C:
+			for _, entry := range manifest.Messages {
+				if _, missing := pending[entry.MessageId]; missing {
+					result.Missing = append(result.Missing, entry.MessageId)
+				}
+			}
+			return result, nil
+		case <-amqp.vu.Context().Done():
+			return result, amqp.vu.Context().Err()
+		}
+	}
+	return result, nil
+}
+
+func checksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
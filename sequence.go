@@ -0,0 +1,93 @@
+package amqp
+
+import (
+	"sync"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers of the messages published with the Sequence option.
+const (
+	headerSequence       = "x-k6-sequence"
+	headerSequenceSource = "x-k6-sequence-source"
+)
+
+// publishSequences numbers the messages published by a VU, per exchange and routing key.
+type publishSequences struct {
+	mu     sync.Mutex
+	source string
+	next   map[string]int64
+}
+
+// stamp returns the next sequence number of the destination along with the source of the numbers.
+func (s *publishSequences) stamp(stream string, exchange string, routingKey string) (string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == nil {
+		s.source = newUUID()
+		s.next = make(map[string]int64)
+	}
+	key := stream + "\x00" + exchange + "\x00" + routingKey
+	s.next[key]++
+	return s.source + stream, s.next[key]
+}
+
+// stampSequence sets the sequence headers of a message published with the Sequence option, unless it already
+// carries them. Messages published on different channels are not ordered by the broker, so every publisher
+// of a pool numbers its messages as a separate stream.
+func (amqp *AMQP) stampSequence(options PublishOptions, stream string) PublishOptions {
+	if !options.Sequence {
+		return options
+	}
+	if _, ok := options.Headers[headerSequence]; ok {
+		return options
+	}
+	source, seq := amqp.sequences.stamp(stream, options.Exchange, options.routingKey())
+	options.Headers = withField(options.Headers, headerSequenceSource, source)
+	options.Headers = withField(options.Headers, headerSequence, seq)
+	return options
+}
+
+// orderingVerifier is shared by the VUs of the test run: it keeps the last sequence number received from
+// every source and destination, whichever VU consumes the messages.
+type orderingVerifier struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+// receive records a sequence number, returning how many numbers were skipped and whether it arrived out of order.
+func (v *orderingVerifier) receive(key string, seq int64) (gap int64, outOfOrder bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.last == nil {
+		v.last = make(map[string]int64)
+	}
+	last := v.last[key]
+	if seq <= last {
+		return 0, true
+	}
+	v.last[key] = seq
+	return seq - last - 1, false
+}
+
+// verifyOrder checks the sequence number of a delivery against the previous one of the same publisher and
+// destination, counting skipped numbers as amqp_sequence_gaps and late ones as amqp_messages_out_of_order.
+// Redeliveries are not checked since requeued messages are expected to come back out of order.
+func (amqp *AMQP) verifyOrder(d amqpDriver.Delivery, tags map[string]string) {
+	source, ok := d.Headers[headerSequenceSource].(string)
+	if !ok || d.Redelivered {
+		return
+	}
+	seq, ok := d.Headers[headerSequence].(int64)
+	if !ok {
+		return
+	}
+
+	gap, outOfOrder := amqp.ordering.receive(source+"\x00"+d.Exchange+"\x00"+d.RoutingKey, seq)
+	if outOfOrder {
+		amqp.metrics.push(amqp.metrics.MessagesOutOfOrder, 1, tags)
+	}
+	if gap > 0 {
+		amqp.metrics.push(amqp.metrics.SequenceGaps, float64(gap), tags)
+	}
+}
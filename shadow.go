@@ -0,0 +1,60 @@
+package amqp
+
+import (
+	"fmt"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Values of the traffic tag added to consume metrics when a shadow header is configured.
+const (
+	trafficShadow = "shadow"
+	trafficReal   = "real"
+)
+
+// isShadow reports whether a delivery carries the synthetic traffic header.
+func isShadow(d amqpDriver.Delivery, header string) bool {
+	value, ok := d.Headers[header]
+	if !ok {
+		return false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return fmt.Sprint(value) != "0"
+}
+
+// countConsumed emits the amqp_messages_consumed metric for a delivery. When a shadow header is
+// configured, the sample is tagged to tell synthetic traffic from real traffic. Copies of a message
+// republished by the retry policy are counted once, the others as amqp_retry_duplicates. Bodies drawn from
+// a payload size distribution are tagged with their size_bucket, sequence numbers are checked, see verifyOrder,
+// and duplicates let through by the deduplication plugin are counted, see detectDuplicate.
+func (amqp *AMQP) countConsumed(d amqpDriver.Delivery, tags map[string]string) {
+	tags = withSizeBucket(d, tags)
+	amqp.receiveTracked(d, tags)
+	if amqp.retries.duplicate(d) {
+		amqp.metrics.push(amqp.metrics.RetryDuplicates, 1, tags)
+		return
+	}
+	amqp.detectDuplicate(d, tags)
+	amqp.verifyOrder(d, tags)
+
+	header := amqp.options.ShadowHeader
+	if header == "" {
+		amqp.metrics.push(amqp.metrics.MessagesConsumed, 1, tags)
+		return
+	}
+
+	tagged := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		tagged[k] = v
+	}
+	tagged["traffic"] = trafficReal
+	if isShadow(d, header) {
+		tagged["traffic"] = trafficShadow
+	}
+	amqp.metrics.push(amqp.metrics.MessagesConsumed, 1, tagged)
+}
@@ -0,0 +1,122 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+)
+
+// Strategies selecting the target of a sharded publish.
+const (
+	shardRoundRobin = "round-robin"
+	shardRandom     = "random"
+	shardWeighted   = "weighted"
+	shardKeyHash    = "key-hash"
+)
+
+// ShardOptions defines the targets a sharded publisher spreads messages across.
+type ShardOptions struct {
+	Targets  []string  // routing keys of the shards, queue names when publishing to the default exchange
+	Strategy string    // round-robin (default), random, weighted or key-hash
+	Weights  []float64 // relative weight of each target with the weighted strategy
+}
+
+// ShardedPublisher publishes each message to one of its targets, see Amqp.shardedPublisher.
+type ShardedPublisher struct {
+	amqp    *AMQP
+	options ShardOptions
+
+	mu         sync.Mutex
+	next       int
+	cumulative []float64 // running sum of the weights
+}
+
+// ShardedPublisher returns a publisher selecting the target of every message with the given strategy.
+// Messages sent to each target are counted with the amqp_shard_messages metric tagged by shard.
+func (amqp *AMQP) ShardedPublisher(options ShardOptions) (publisher *ShardedPublisher, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if len(options.Targets) == 0 {
+		return nil, errors.New("at least one shard target is required")
+	}
+
+	publisher = &ShardedPublisher{amqp: amqp, options: options}
+	switch options.Strategy {
+	case "":
+		publisher.options.Strategy = shardRoundRobin
+	case shardRoundRobin, shardRandom, shardKeyHash:
+	case shardWeighted:
+		if len(options.Weights) != len(options.Targets) {
+			return nil, fmt.Errorf("expected %d weights, one per target, got %d", len(options.Targets), len(options.Weights))
+		}
+		total := 0.0
+		for _, weight := range options.Weights {
+			if weight < 0 {
+				return nil, errors.New("shard weights must not be negative")
+			}
+			total += weight
+			publisher.cumulative = append(publisher.cumulative, total)
+		}
+		if total == 0 {
+			return nil, errors.New("at least one shard weight must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unknown shard strategy %q, expected round-robin, random, weighted or key-hash", options.Strategy)
+	}
+	return publisher, nil
+}
+
+// Publish sends the message to the target selected for it and returns the target.
+// The key is required by the key-hash strategy, messages with the same key always go to the same target.
+func (publisher *ShardedPublisher) Publish(options PublishOptions, key string) (correlationID string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	target, err := publisher.target(key)
+	if err != nil {
+		return "", err
+	}
+
+	options.RoutingKey = target
+	if _, err = publisher.amqp.Publish(options); err != nil {
+		return target, err
+	}
+	publisher.amqp.metrics.push(publisher.amqp.metrics.ShardMessages, 1, map[string]string{"shard": target})
+	return target, nil
+}
+
+// target selects the target of the next message.
+func (publisher *ShardedPublisher) target(key string) (string, error) {
+	targets := publisher.options.Targets
+	switch publisher.options.Strategy {
+	case shardRandom:
+		return targets[rand.Intn(len(targets))], nil //nolint:gosec
+	case shardWeighted:
+		total := publisher.cumulative[len(publisher.cumulative)-1]
+		pick := rand.Float64() * total //nolint:gosec
+		for i, limit := range publisher.cumulative {
+			if pick < limit {
+				return targets[i], nil
+			}
+		}
+		return targets[len(targets)-1], nil
+	case shardKeyHash:
+		if key == "" {
+			return "", errors.New("a key is required by the key-hash strategy")
+		}
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(key))
+		return targets[hash.Sum32()%uint32(len(targets))], nil
+	default:
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		target := targets[publisher.next]
+		publisher.next = (publisher.next + 1) % len(targets)
+		return target, nil
+	}
+}
@@ -0,0 +1,180 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultSinkPrefetch = 100
+
+// SinkOptions defines the consumers of a queue drained with Amqp.drain.
+type SinkOptions struct {
+	Consumers    int  // consumers, each on its own channel, 1 by default
+	Prefetch     int  // unacknowledged deliveries allowed per consumer, 100 by default
+	AutoAck      bool // let the broker consider the messages acknowledged once sent
+	Exclusive    bool
+	Args         amqpDriver.Table
+	ConsumerArgs ConsumerArgs      // typed stream offset and filter or priority arguments, merged into Args
+	Tags         map[string]string // extra tags of the consume metrics
+
+	ProcessingDelay ProcessingDelay // simulated service time of every delivery, spent before acknowledging it
+}
+
+// SinkStats reports the messages discarded by a sink since it was started.
+type SinkStats struct {
+	Consumed       int64
+	Bytes          int64
+	DurationMs     float64
+	RatePerSecond  float64 // average messages per second
+	BytesPerSecond float64
+}
+
+// Sink is a handle to a queue drained with Amqp.drain.
+type Sink struct {
+	amqp     *AMQP
+	channels []*amqpDriver.Channel
+	started  time.Time
+	consumed int64
+	bytes    int64
+	interval int64 // deliveries since the last amqp_consume_rate sample
+
+	wg       sync.WaitGroup
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	stopped  int64 // unix nanoseconds at which the sink stopped, 0 while running
+}
+
+// Drain consumes and discards the messages of a queue in the background, without calling back into the
+// script, so that a producer focused test keeps the queue from building up with a realistic consumer. Every
+// delivery is counted as amqp_messages_consumed and its body as amqp_sink_bytes, the throughput is sampled
+// every second as amqp_consume_rate, and the messages published with the Track option report their end to
+// end latency as amqp_tracked_latency. The sink runs until it is stopped or the iteration ends.
+func (amqp *AMQP) Drain(queueName string, options SinkOptions) (sink *Sink, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := amqp.consumerArgs(options.ConsumerArgs, options.Args, queueName, options.AutoAck)
+	if err != nil {
+		return nil, err
+	}
+	processing, err := options.ProcessingDelay.generator()
+	if err != nil {
+		return nil, err
+	}
+	consumers := options.Consumers
+	if consumers <= 0 {
+		consumers = 1
+	}
+	prefetch := options.Prefetch
+	if prefetch <= 0 {
+		prefetch = defaultSinkPrefetch
+	}
+	tags := consumeTags(queueName, "")
+	for k, v := range options.Tags {
+		tags[k] = v
+	}
+
+	ctx, cancel := context.WithCancel(amqp.vu.Context())
+	sink = &Sink{amqp: amqp, started: time.Now(), cancel: cancel}
+	for i := 0; i < consumers; i++ {
+		ch, err := amqp.channel()
+		if err != nil {
+			_, _ = sink.Stop()
+			return nil, err
+		}
+		sink.channels = append(sink.channels, ch)
+		if !options.AutoAck {
+			if err := ch.Qos(prefetch, 0, false); err != nil {
+				_, _ = sink.Stop()
+				return nil, err
+			}
+		}
+		consumer := fmt.Sprintf("k6-sink-%s-%d", newUUID(), i)
+		msgs, err := amqp.metrics.consume(ch, amqp.options.Timeouts, queueName, consumer, options.AutoAck, options.Exclusive, false, false, args)
+		if err != nil {
+			_, _ = sink.Stop()
+			return nil, err
+		}
+
+		sink.wg.Add(1)
+		go func() {
+			defer sink.wg.Done()
+			for d := range msgs {
+				if processing != nil {
+					// Each consumer processes its deliveries one at a time, the prefetch bounds those waiting.
+					_ = sleepContext(ctx, processing())
+				}
+				if !options.AutoAck {
+					_ = d.Ack(false)
+				}
+				atomic.AddInt64(&sink.consumed, 1)
+				atomic.AddInt64(&sink.bytes, int64(len(d.Body)))
+				atomic.AddInt64(&sink.interval, 1)
+				amqp.metrics.push(amqp.metrics.SinkBytes, float64(len(d.Body)), tags)
+				amqp.countConsumed(d, tags)
+			}
+		}()
+	}
+
+	go sink.sampleRate(ctx, tags)
+	return sink, nil
+}
+
+// sampleRate emits the deliveries of the last second as amqp_consume_rate until the sink stops, which it
+// does once the iteration ends as well.
+func (sink *Sink) sampleRate(ctx context.Context, tags map[string]string) {
+	ticker := time.NewTicker(consumeRateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			delivered := atomic.SwapInt64(&sink.interval, 0)
+			sink.amqp.metrics.push(sink.amqp.metrics.ConsumeRate, float64(delivered)/consumeRateInterval.Seconds(), tags)
+		case <-ctx.Done():
+			_, _ = sink.Stop()
+			return
+		}
+	}
+}
+
+// Stats returns the messages discarded so far and the average throughput.
+func (sink *Sink) Stats() SinkStats {
+	end := time.Now()
+	if stopped := atomic.LoadInt64(&sink.stopped); stopped != 0 {
+		end = time.Unix(0, stopped)
+	}
+	stats := SinkStats{
+		Consumed:   atomic.LoadInt64(&sink.consumed),
+		Bytes:      atomic.LoadInt64(&sink.bytes),
+		DurationMs: float64(end.Sub(sink.started)) / float64(time.Millisecond),
+	}
+	if seconds := end.Sub(sink.started).Seconds(); seconds > 0 {
+		stats.RatePerSecond = float64(stats.Consumed) / seconds
+		stats.BytesPerSecond = float64(stats.Bytes) / seconds
+	}
+	return stats
+}
+
+// Stop closes the channels of the sink, the unacknowledged deliveries being requeued by the broker, and
+// returns the messages discarded.
+func (sink *Sink) Stop() (SinkStats, error) {
+	var err error
+	sink.stopOnce.Do(func() {
+		sink.cancel()
+		for _, ch := range sink.channels {
+			if closeErr := ch.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		sink.wg.Wait()
+		atomic.StoreInt64(&sink.stopped, time.Now().UnixNano())
+	})
+	return sink.Stats(), structuredError(err)
+}
@@ -0,0 +1,519 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.k6.io/k6/js/modules"
+)
+
+const (
+	defaultStompTimeout = 5 * time.Second
+	stompMessageBuffer  = 1024
+)
+
+// STOMP acknowledgement modes of a subscription.
+const (
+	stompAckAuto             = "auto"
+	stompAckClient           = "client"
+	stompAckClientIndividual = "client-individual"
+)
+
+// WebStomp is a STOMP over WebSocket client for the Web STOMP plugin, so that the browser facing endpoints
+// of the broker are tested along with the AMQP clients.
+type WebStomp struct {
+	Version string
+
+	vu      modules.VU
+	metrics *amqpMetrics
+}
+
+// StompOptions defines how to reach a Web STOMP endpoint.
+type StompOptions struct {
+	URL       string // e.g. ws://localhost:15674/ws
+	Login     string
+	Passcode  string
+	Vhost     string            // virtual host, sent as the host header, / by default
+	Headers   map[string]string // extra headers of the WebSocket handshake
+	TimeoutMs int               // timeout of the handshake and of the receipts, 5000 by default
+}
+
+// StompSendOptions defines a message sent to a STOMP destination.
+type StompSendOptions struct {
+	Destination string // e.g. /queue/orders, /exchange/amq.topic/orders or /topic/orders
+	Body        string
+	ContentType string
+	Headers     map[string]string
+	Receipt     bool // wait for the broker to acknowledge the frame, see amqp_stomp_receipt_duration
+}
+
+// StompSubscribeOptions defines a subscription to a STOMP destination.
+type StompSubscribeOptions struct {
+	Destination string
+	Id          string // subscription id, generated if empty
+	Ack         string // auto (default), client or client-individual
+	Prefetch    int    // unacknowledged messages allowed, sets the prefetch-count header of RabbitMQ
+	Headers     map[string]string
+}
+
+// StompMessage is a message received by a subscription.
+type StompMessage struct {
+	Destination  string
+	Subscription string
+	MessageId    string
+	Ack          string // id acknowledging the message in the client ack modes
+	Headers      map[string]string
+	Body         string
+}
+
+// StompSession is a connection to a Web STOMP endpoint, closed by Disconnect or at the end of the iteration.
+type StompSession struct {
+	StompVersion string // STOMP version negotiated with the broker
+	Server       string
+
+	stomp    *WebStomp
+	conn     *websocket.Conn
+	timeout  time.Duration
+	writeMu  sync.Mutex
+	messages chan StompMessage
+	nextID   int64
+
+	mu       sync.Mutex
+	receipts map[string]chan struct{}
+	err      error         // ERROR frame or read failure ending the session
+	done     chan struct{} // closed once the session ends
+	stopOnce sync.Once
+}
+
+// stompFrame is a STOMP frame.
+type stompFrame struct {
+	command string
+	headers map[string]string
+	body    []byte
+}
+
+// Connect opens a WebSocket to the endpoint and sends the CONNECT frame, returning once the broker answered.
+func (stomp *WebStomp) Connect(options StompOptions) (session *StompSession, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if options.URL == "" {
+		return nil, errors.New("stomp url is required")
+	}
+	timeout := defaultStompTimeout
+	if options.TimeoutMs > 0 {
+		timeout = time.Duration(options.TimeoutMs) * time.Millisecond
+	}
+	vhost := options.Vhost
+	if vhost == "" {
+		vhost = "/"
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: timeout,
+		Subprotocols:     []string{"v12.stomp", "v11.stomp", "v10.stomp"},
+		Proxy:            http.ProxyFromEnvironment,
+	}
+	if state := stomp.vu.State(); state != nil {
+		dialer.NetDialContext = state.Dialer.DialContext
+		dialer.TLSClientConfig = state.TLSConfig
+	}
+	header := http.Header{}
+	for k, v := range options.Headers {
+		header.Set(k, v)
+	}
+	ctx := stomp.vu.Context()
+	conn, res, err := dialer.DialContext(ctx, options.URL, header)
+	if res != nil && res.Body != nil {
+		_ = res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session = &StompSession{
+		stomp:    stomp,
+		conn:     conn,
+		timeout:  timeout,
+		messages: make(chan StompMessage, stompMessageBuffer),
+		receipts: make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+	}
+	connect := stompFrame{command: "CONNECT", headers: map[string]string{
+		"accept-version": "1.2,1.1,1.0",
+		"host":           vhost,
+		"heart-beat":     "0,0",
+	}}
+	if options.Login != "" {
+		connect.headers["login"] = options.Login
+		connect.headers["passcode"] = options.Passcode
+	}
+	if err = session.write(connect); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := session.read()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if frame.command != "CONNECTED" {
+		_ = conn.Close()
+		return nil, stompError(frame)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	session.StompVersion = frame.headers["version"]
+	if session.StompVersion == "" {
+		session.StompVersion = "1.0"
+	}
+	session.Server = frame.headers["server"]
+
+	go session.receive()
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.stop(ctx.Err())
+		case <-session.done:
+		}
+	}()
+	return session, nil
+}
+
+// Send sends a message to a destination, waiting for its receipt if asked to.
+func (session *StompSession) Send(options StompSendOptions) (err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if options.Destination == "" {
+		return errors.New("stomp destination is required")
+	}
+	frame := stompFrame{command: "SEND", headers: map[string]string{}, body: []byte(options.Body)}
+	for k, v := range options.Headers {
+		frame.headers[k] = v
+	}
+	frame.headers["destination"] = options.Destination
+	frame.headers["content-length"] = strconv.Itoa(len(frame.body))
+	if options.ContentType != "" {
+		frame.headers["content-type"] = options.ContentType
+	}
+
+	tags := map[string]string{"destination": options.Destination}
+	if options.Receipt {
+		started := time.Now()
+		if err = session.withReceipt(frame); err != nil {
+			return err
+		}
+		session.stomp.metrics.push(session.stomp.metrics.StompReceiptDuration, sinceMs(started), tags)
+	} else if err = session.write(frame); err != nil {
+		return err
+	}
+	session.stomp.metrics.push(session.stomp.metrics.StompMessagesSent, 1, tags)
+	return nil
+}
+
+// Subscribe subscribes to a destination and returns the subscription id, the messages being read with Receive.
+func (session *StompSession) Subscribe(options StompSubscribeOptions) (id string, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	if options.Destination == "" {
+		return "", errors.New("stomp destination is required")
+	}
+	ack := options.Ack
+	switch ack {
+	case "":
+		ack = stompAckAuto
+	case stompAckAuto, stompAckClient, stompAckClientIndividual:
+	default:
+		return "", fmt.Errorf("unknown stomp ack mode %q, expected auto, client or client-individual", ack)
+	}
+	id = options.Id
+	if id == "" {
+		id = "sub-" + session.newID()
+	}
+
+	frame := stompFrame{command: "SUBSCRIBE", headers: map[string]string{}}
+	for k, v := range options.Headers {
+		frame.headers[k] = v
+	}
+	frame.headers["destination"] = options.Destination
+	frame.headers["id"] = id
+	frame.headers["ack"] = ack
+	if options.Prefetch > 0 {
+		frame.headers["prefetch-count"] = strconv.Itoa(options.Prefetch)
+	}
+	return id, session.withReceipt(frame)
+}
+
+// Unsubscribe ends a subscription.
+func (session *StompSession) Unsubscribe(id string) error {
+	return structuredError(session.withReceipt(stompFrame{command: "UNSUBSCRIBE", headers: map[string]string{"id": id}}))
+}
+
+// Receive returns the next message of the subscriptions of the session, waiting up to timeoutMs, or null
+// once the timeout elapsed.
+func (session *StompSession) Receive(timeoutMs int) (*StompMessage, error) {
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case msg := <-session.messages:
+		return &msg, nil
+	case <-timer.C:
+		return nil, nil
+	case <-session.done:
+		// Messages received before the session ended are still returned.
+		select {
+		case msg := <-session.messages:
+			return &msg, nil
+		default:
+			return nil, structuredError(session.failure())
+		}
+	}
+}
+
+// Ack acknowledges a message received by a subscription in a client ack mode.
+func (session *StompSession) Ack(message StompMessage) error {
+	return structuredError(session.write(session.ackFrame("ACK", message)))
+}
+
+// Nack rejects a message received by a subscription in a client ack mode, RabbitMQ requeueing it.
+func (session *StompSession) Nack(message StompMessage) error {
+	return structuredError(session.write(session.ackFrame("NACK", message)))
+}
+
+// ackFrame builds the ACK or NACK frame of a message, identified as the negotiated version expects.
+func (session *StompSession) ackFrame(command string, message StompMessage) stompFrame {
+	frame := stompFrame{command: command, headers: map[string]string{}}
+	if session.StompVersion == "1.2" {
+		frame.headers["id"] = message.Ack
+		return frame
+	}
+	frame.headers["message-id"] = message.MessageId
+	frame.headers["subscription"] = message.Subscription
+	return frame
+}
+
+// Disconnect sends the DISCONNECT frame, waiting for its receipt so that the frames sent before are
+// processed, and closes the WebSocket.
+func (session *StompSession) Disconnect() error {
+	err := session.withReceipt(stompFrame{command: "DISCONNECT", headers: map[string]string{}})
+	session.stop(nil)
+	return structuredError(err)
+}
+
+// withReceipt sends a frame with a receipt header and waits for the RECEIPT frame.
+func (session *StompSession) withReceipt(frame stompFrame) error {
+	id := session.newID()
+	received := make(chan struct{})
+	session.mu.Lock()
+	session.receipts[id] = received
+	session.mu.Unlock()
+	defer func() {
+		session.mu.Lock()
+		delete(session.receipts, id)
+		session.mu.Unlock()
+	}()
+
+	frame.headers["receipt"] = id
+	if err := session.write(frame); err != nil {
+		return err
+	}
+	timer := time.NewTimer(session.timeout)
+	defer timer.Stop()
+	select {
+	case <-received:
+		return nil
+	case <-session.done:
+		return session.failure()
+	case <-timer.C:
+		return fmt.Errorf("no receipt of the %s frame within %s", frame.command, session.timeout)
+	}
+}
+
+// receive reads the frames of the broker until the session ends.
+func (session *StompSession) receive() {
+	for {
+		frame, err := session.read()
+		if err != nil {
+			session.stop(err)
+			return
+		}
+		switch frame.command {
+		case "MESSAGE":
+			msg := StompMessage{
+				Destination:  frame.headers["destination"],
+				Subscription: frame.headers["subscription"],
+				MessageId:    frame.headers["message-id"],
+				Ack:          frame.headers["ack"],
+				Headers:      frame.headers,
+				Body:         string(frame.body),
+			}
+			session.stomp.metrics.push(session.stomp.metrics.StompMessagesReceived, 1, map[string]string{"destination": msg.Destination})
+			select {
+			case session.messages <- msg:
+			case <-session.done:
+				return
+			}
+		case "RECEIPT":
+			session.mu.Lock()
+			if received, ok := session.receipts[frame.headers["receipt-id"]]; ok {
+				close(received)
+				delete(session.receipts, frame.headers["receipt-id"])
+			}
+			session.mu.Unlock()
+		case "ERROR":
+			session.stop(stompError(frame))
+			return
+		}
+	}
+}
+
+// stop records why the session ended and closes the WebSocket.
+func (session *StompSession) stop(err error) {
+	session.stopOnce.Do(func() {
+		session.mu.Lock()
+		session.err = err
+		session.mu.Unlock()
+		close(session.done)
+		_ = session.conn.Close()
+	})
+}
+
+// failure returns why the session ended.
+func (session *StompSession) failure() error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.err == nil {
+		return errors.New("stomp session is closed")
+	}
+	return session.err
+}
+
+func (session *StompSession) newID() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.nextID++
+	return strconv.FormatInt(session.nextID, 10)
+}
+
+// write sends a frame as a WebSocket text message.
+func (session *StompSession) write(frame stompFrame) error {
+	select {
+	case <-session.done:
+		return session.failure()
+	default:
+	}
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	_ = session.conn.SetWriteDeadline(time.Now().Add(session.timeout))
+	return session.conn.WriteMessage(websocket.TextMessage, frame.encode())
+}
+
+// read returns the next frame of the broker, skipping heart beats.
+func (session *StompSession) read() (stompFrame, error) {
+	for {
+		_, data, err := session.conn.ReadMessage()
+		if err != nil {
+			return stompFrame{}, err
+		}
+		if len(bytes.Trim(data, "\r\n")) == 0 {
+			continue
+		}
+		return decodeStompFrame(data)
+	}
+}
+
+// encode serializes the frame, escaping the headers as STOMP 1.2 expects except in CONNECT frames.
+func (frame stompFrame) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(frame.command)
+	buf.WriteByte('\n')
+	escaper := stompEscaper
+	if frame.command == "CONNECT" {
+		escaper = strings.NewReplacer()
+	}
+	for k, v := range frame.headers {
+		buf.WriteString(escaper.Replace(k))
+		buf.WriteByte(':')
+		buf.WriteString(escaper.Replace(v))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(frame.body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+//nolint:gochecknoglobals
+var (
+	stompEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// decodeStompFrame parses a frame, its body ending at the content length if given or at the first NUL.
+func decodeStompFrame(data []byte) (stompFrame, error) {
+	reader := bufio.NewReader(bytes.NewReader(bytes.TrimLeft(data, "\r\n")))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return stompFrame{}, fmt.Errorf("malformed stomp frame: %w", err)
+	}
+	frame := stompFrame{command: strings.TrimRight(line, "\r\n"), headers: map[string]string{}}
+	for {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return stompFrame{}, fmt.Errorf("malformed stomp %s frame: %w", frame.command, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return stompFrame{}, fmt.Errorf("malformed stomp header %q", line)
+		}
+		key := stompUnescaper.Replace(line[:i])
+		// The first occurrence of a repeated header is the one in effect.
+		if _, ok := frame.headers[key]; !ok {
+			frame.headers[key] = stompUnescaper.Replace(line[i+1:])
+		}
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return stompFrame{}, err
+	}
+	if length, err := strconv.Atoi(frame.headers["content-length"]); err == nil && length >= 0 && length <= len(body) {
+		body = body[:length]
+	} else if i := bytes.IndexByte(body, 0); i >= 0 {
+		body = body[:i]
+	}
+	frame.body = body
+	return frame, nil
+}
+
+// stompError returns the error reported by an ERROR frame, or the unexpected frame received.
+func stompError(frame stompFrame) error {
+	if frame.command != "ERROR" {
+		return fmt.Errorf("unexpected stomp %s frame", frame.command)
+	}
+	message := frame.headers["message"]
+	if details := strings.TrimSpace(string(frame.body)); details != "" {
+		message += ": " + details
+	}
+	return fmt.Errorf("stomp error: %s", message)
+}
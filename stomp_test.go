@@ -0,0 +1,197 @@
+package amqp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestStompFrameRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		frame stompFrame
+	}{
+		{"no headers", stompFrame{command: "DISCONNECT", headers: map[string]string{}}},
+		{"body", stompFrame{command: "SEND", headers: map[string]string{"destination": "/queue/orders"}, body: []byte(`{"id":1}`)}},
+		{"escaped headers", stompFrame{command: "SEND", headers: map[string]string{"a:b": "line\nbreak\r", "back\\slash": "x:y:z"}}},
+		{"NUL in body", stompFrame{command: "MESSAGE", headers: map[string]string{"content-length": "3"}, body: []byte{'a', 0, 'b'}}},
+	}
+	for _, tt := range tests {
+		decoded, err := decodeStompFrame(tt.frame.encode())
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if decoded.command != tt.frame.command || !reflect.DeepEqual(decoded.headers, tt.frame.headers) || string(decoded.body) != string(tt.frame.body) {
+			t.Errorf("%s: decoded %+v, expected %+v", tt.name, decoded, tt.frame)
+		}
+	}
+}
+
+func TestStompConnectFrameNotEscaped(t *testing.T) {
+	t.Parallel()
+
+	frame := stompFrame{command: "CONNECT", headers: map[string]string{"passcode": "p:w"}}
+	if encoded := string(frame.encode()); encoded != "CONNECT\npasscode:p:w\n\n\x00" {
+		t.Errorf("encoded %q", encoded)
+	}
+}
+
+func TestDecodeStompFrame(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		headers map[string]string
+		body    string
+	}{
+		{"leading heart beats", "\n\r\nRECEIPT\nreceipt-id:1\n\n\x00", map[string]string{"receipt-id": "1"}, ""},
+		{"crlf lines", "MESSAGE\r\ndestination:/queue/a\r\n\r\nhello\x00", map[string]string{"destination": "/queue/a"}, "hello"},
+		{"first repeated header wins", "MESSAGE\nfoo:1\nfoo:2\n\n\x00", map[string]string{"foo": "1"}, ""},
+		{"body up to NUL", "MESSAGE\n\nhello\x00\n\n", map[string]string{}, "hello"},
+		{"content length beyond body", "MESSAGE\ncontent-length:99\n\nhi\x00", map[string]string{"content-length": "99"}, "hi"},
+	}
+	for _, tt := range tests {
+		frame, err := decodeStompFrame([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(frame.headers, tt.headers) || string(frame.body) != tt.body {
+			t.Errorf("%s: decoded headers %v and body %q, expected %v and %q", tt.name, frame.headers, frame.body, tt.headers, tt.body)
+		}
+	}
+}
+
+func TestDecodeStompFrameErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"MESSAGE", "MESSAGE\ndestination:/queue/a", "MESSAGE\nno-colon\n\n\x00"} {
+		if _, err := decodeStompFrame([]byte(data)); err == nil {
+			t.Errorf("decoded the malformed frame %q", data)
+		}
+	}
+}
+
+func TestStompError(t *testing.T) {
+	t.Parallel()
+
+	err := stompError(stompFrame{command: "ERROR", headers: map[string]string{"message": "access refused"}, body: []byte(" no vhost \n")})
+	if err == nil || err.Error() != "stomp error: access refused: no vhost" {
+		t.Errorf("got %v", err)
+	}
+	if err = stompError(stompFrame{command: "RECEIPT"}); err == nil || !strings.Contains(err.Error(), "unexpected stomp RECEIPT frame") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// stompBroker is a Web STOMP endpoint echoing the SEND frames to the subscription of their destination.
+func stompBroker(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"v12.stomp"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		subscriptions := map[string]string{}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frame, err := decodeStompFrame(data)
+			if err != nil {
+				return
+			}
+			var replies []stompFrame
+			switch frame.command {
+			case "CONNECT":
+				if frame.headers["login"] != "guest" {
+					replies = append(replies, stompFrame{command: "ERROR", headers: map[string]string{"message": "access refused"}})
+					break
+				}
+				replies = append(replies, stompFrame{command: "CONNECTED", headers: map[string]string{"version": "1.2", "server": "test"}})
+			case "SUBSCRIBE":
+				subscriptions[frame.headers["destination"]] = frame.headers["id"]
+			case "SEND":
+				if id, ok := subscriptions[frame.headers["destination"]]; ok {
+					replies = append(replies, stompFrame{command: "MESSAGE", body: frame.body, headers: map[string]string{
+						"destination":  frame.headers["destination"],
+						"subscription": id,
+						"message-id":   "m-1",
+						"ack":          "a-1",
+					}})
+				}
+			}
+			if receipt, ok := frame.headers["receipt"]; ok {
+				replies = append(replies, stompFrame{command: "RECEIPT", headers: map[string]string{"receipt-id": receipt}})
+			}
+			for _, reply := range replies {
+				if err = conn.WriteMessage(websocket.TextMessage, reply.encode()); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStompSession(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t)
+	stomp := (&RootModule{}).instance(rt.VU).Stomp
+	url := "ws" + strings.TrimPrefix(stompBroker(t).URL, "http")
+
+	if _, err := stomp.Connect(StompOptions{URL: url, Login: "intruder"}); err == nil || !strings.Contains(err.Error(), "access refused") {
+		t.Errorf("connected with wrong credentials: %v", err)
+	}
+
+	session, err := stomp.Connect(StompOptions{URL: url, Login: "guest", Passcode: "guest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.StompVersion != "1.2" || session.Server != "test" {
+		t.Errorf("negotiated version %q with server %q", session.StompVersion, session.Server)
+	}
+	id, err := session.Subscribe(StompSubscribeOptions{Destination: "/queue/orders", Ack: stompAckClientIndividual})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Send(StompSendOptions{Destination: "/queue/orders", Body: "hello", Receipt: true}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := session.Receive(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil || msg.Body != "hello" || msg.Subscription != id || msg.Ack != "a-1" {
+		t.Fatalf("received %+v", msg)
+	}
+	if frame := session.ackFrame("ACK", *msg); !reflect.DeepEqual(frame.headers, map[string]string{"id": "a-1"}) {
+		t.Errorf("STOMP 1.2 ack headers %v", frame.headers)
+	}
+	if msg, err = session.Receive(10); msg != nil || err != nil {
+		t.Errorf("received %+v, %v from an empty subscription", msg, err)
+	}
+	if err = session.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Send(StompSendOptions{Destination: "/queue/orders"}); err == nil {
+		t.Error("sent a frame after the disconnection")
+	}
+}
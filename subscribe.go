@@ -0,0 +1,235 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultSubscribePrefetch    = 10
+	defaultSubscribeIdleTimeout = time.Second
+)
+
+// SubscribeOptions defines a subscription iterating over the messages of a queue.
+type SubscribeOptions struct {
+	QueueName     string
+	Partition     string // partition label or index of a super stream / sharded queue, added as a metric tag
+	Consumer      string
+	Exclusive     bool
+	Args          amqpDriver.Table
+	ConsumerArgs  ConsumerArgs // typed stream offset and filter or priority arguments, merged into Args
+	Prefetch      int          // deliveries sent ahead of the script, 10 by default
+	IdleTimeoutMs int          // the iteration ends once no message arrives for this long, 1000 by default
+	MaxMessages   int          // the iteration ends after this many messages, unlimited by default
+	ProtoMessage  string       // protobuf message the bodies are decoded from to JSON, if empty the type of protobuf messages when loaded
+	Avro          bool         // decode schema registry framed Avro bodies to JSON, also done for the avro/binary content type with a schema registry
+}
+
+// Subscription is a consumer whose messages are pulled by the script one at a time, each message being
+// acknowledged once handed over.
+type Subscription struct {
+	amqp     *AMQP
+	options  SubscribeOptions
+	ch       *amqpDriver.Channel
+	consumer string
+	msgs     <-chan amqpDriver.Delivery
+	tags     map[string]string
+
+	mu       sync.Mutex // promises of the async iteration may be pending at once
+	received int
+	done     bool
+}
+
+// Subscribe starts a consumer whose messages are iterated over with for...of, a more natural model than
+// the listener for sequential verification. The iteration ends once the subscription is idle for the idle
+// timeout, after MaxMessages or when the loop is left. Where the runtime supports it, the subscription is
+// async iterable as well, for await...of getting the messages without blocking the event loop.
+func (amqp *AMQP) Subscribe(options SubscribeOptions) (iterator *goja.Object, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	args, err := amqp.consumerArgs(options.ConsumerArgs, options.Args, options.QueueName, false)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, err
+	}
+	prefetch := options.Prefetch
+	if prefetch <= 0 {
+		prefetch = defaultSubscribePrefetch
+	}
+	if err = ch.Qos(prefetch, 0, false); err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	consumer := options.Consumer
+	if consumer == "" {
+		consumer = "k6-" + newUUID()
+	}
+	msgs, err := amqp.metrics.consume(
+		ch,
+		amqp.options.Timeouts,
+		options.QueueName,
+		consumer,
+		false,
+		options.Exclusive,
+		false,
+		false,
+		args,
+	)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	sub := &Subscription{
+		amqp:     amqp,
+		options:  options,
+		ch:       ch,
+		consumer: consumer,
+		msgs:     msgs,
+		tags:     consumeTags(options.QueueName, options.Partition),
+	}
+	return sub.iterator(), nil
+}
+
+// iterator returns the JS object iterating over the subscription.
+func (sub *Subscription) iterator() *goja.Object {
+	rt := sub.amqp.vu.Runtime()
+	it := rt.NewObject()
+	_ = it.Set("next", sub.Next)
+	_ = it.Set("return", sub.Return)
+	_ = it.Set("cancel", sub.Cancel)
+	self := func(call goja.FunctionCall) goja.Value {
+		return call.This
+	}
+	_ = it.SetSymbol(goja.SymIterator, self)
+
+	if symbol, ok := rt.Get("Symbol").ToObject(rt).Get("asyncIterator").(*goja.Symbol); ok {
+		async := rt.NewObject()
+		_ = async.Set("next", sub.nextAsync)
+		_ = async.Set("return", sub.returnAsync)
+		_ = it.SetSymbol(symbol, func() *goja.Object {
+			return async
+		})
+	}
+	return it
+}
+
+// Next waits for the next message of the subscription, following the iterator protocol.
+func (sub *Subscription) Next() (map[string]interface{}, error) {
+	msg, err := sub.receive()
+	if err != nil {
+		return nil, structuredError(err)
+	}
+	if msg == nil {
+		return map[string]interface{}{"done": true}, nil
+	}
+	return map[string]interface{}{"value": msg, "done": false}, nil
+}
+
+// receive returns the next message, nil once the iteration is over.
+func (sub *Subscription) receive() (*Message, error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.done {
+		return nil, nil
+	}
+	if sub.options.MaxMessages > 0 && sub.received >= sub.options.MaxMessages {
+		return nil, sub.stop()
+	}
+
+	idle := defaultSubscribeIdleTimeout
+	if sub.options.IdleTimeoutMs > 0 {
+		idle = time.Duration(sub.options.IdleTimeoutMs) * time.Millisecond
+	}
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+
+	var d amqpDriver.Delivery
+	select {
+	case delivery, ok := <-sub.msgs:
+		if !ok {
+			// Cancelled by the broker or lost with the channel.
+			sub.done = true
+			return nil, nil
+		}
+		d = delivery
+	case <-timer.C:
+		return nil, sub.stop()
+	case <-sub.amqp.vu.Context().Done():
+		_ = sub.stop()
+		return nil, sub.amqp.vu.Context().Err()
+	}
+
+	sub.received++
+	sub.amqp.countConsumed(d, sub.tags)
+	msg, err := sub.amqp.newMessage(d, sub.options.ProtoMessage, sub.options.Avro)
+	if err != nil {
+		// A body that cannot be decoded would fail again on redelivery.
+		_ = d.Reject(false)
+		return nil, err
+	}
+	return msg, d.Ack(false)
+}
+
+// stop cancels the consumer and closes its channel, the lock being held.
+func (sub *Subscription) stop() error {
+	if sub.done {
+		return nil
+	}
+	sub.done = true
+	err := sub.ch.Cancel(sub.consumer, false)
+	if closeErr := sub.ch.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Return ends the iteration, called by for...of when the loop is left early.
+func (sub *Subscription) Return() map[string]interface{} {
+	_ = sub.Cancel()
+	return map[string]interface{}{"done": true}
+}
+
+// Cancel stops the subscription, the messages sent ahead being requeued by the broker.
+func (sub *Subscription) Cancel() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return structuredError(sub.stop())
+}
+
+// nextAsync returns a promise of the next message, resolved on the event loop.
+func (sub *Subscription) nextAsync() *goja.Promise {
+	promise, resolve, reject := sub.amqp.vu.Runtime().NewPromise()
+	enqueue := sub.amqp.vu.RegisterCallback()
+	go func() {
+		msg, err := sub.receive()
+		enqueue(func() error {
+			switch {
+			case err != nil:
+				reject(structuredError(err))
+			case msg == nil:
+				resolve(map[string]interface{}{"done": true})
+			default:
+				resolve(map[string]interface{}{"value": msg, "done": false})
+			}
+			return nil
+		})
+	}()
+	return promise
+}
+
+// returnAsync ends the async iteration.
+func (sub *Subscription) returnAsync() *goja.Promise {
+	promise, resolve, _ := sub.amqp.vu.Runtime().NewPromise()
+	resolve(sub.Return())
+	return promise
+}
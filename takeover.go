@@ -0,0 +1,92 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// TakeoverOptions defines how an exclusive consumer takeover is measured.
+type TakeoverOptions struct {
+	QueueName       string
+	KillPrimary     bool // close the primary connection to trigger the takeover instead of waiting for it to die
+	RetryIntervalMs int  // how often the standby consumer retries attaching, 100 by default
+	TimeoutMs       int  // how long to wait for the primary to die and the standby to attach, 30000 by default
+}
+
+// MeasureTakeover attaches an exclusive primary consumer and a standby consumer on separate connections
+// and measures how long it takes the standby to attach once the primary connection is gone.
+// The takeover duration (milliseconds) is returned and emitted as the amqp_takeover_duration metric.
+func (amqp *AMQP) MeasureTakeover(options TakeoverOptions) (takeoverMs float64, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	retryInterval := 100 * time.Millisecond
+	if options.RetryIntervalMs > 0 {
+		retryInterval = time.Duration(options.RetryIntervalMs) * time.Millisecond
+	}
+	timeout := 30 * time.Second
+	if options.TimeoutMs > 0 {
+		timeout = time.Duration(options.TimeoutMs) * time.Millisecond
+	}
+
+	primary, err := amqp.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = primary.Close()
+	}()
+	primaryCh, err := primary.Channel()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = primaryCh.Consume(options.QueueName, "k6-primary", false, true, false, false, nil); err != nil {
+		return 0, err
+	}
+
+	standby, err := amqp.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = standby.Close()
+	}()
+
+	deadline := time.After(timeout)
+	closed := primary.NotifyClose(make(chan *amqpDriver.Error, 1))
+	if options.KillPrimary {
+		_ = primary.Close()
+	}
+	select {
+	case <-closed:
+	case <-amqp.vu.Context().Done():
+		return 0, amqp.vu.Context().Err()
+	case <-deadline:
+		return 0, fmt.Errorf("primary consumer of %q is still alive after %s", options.QueueName, timeout)
+	}
+
+	start := time.Now()
+	for {
+		var ch *amqpDriver.Channel
+		if ch, err = standby.Channel(); err != nil {
+			return 0, err
+		}
+		if _, err = ch.Consume(options.QueueName, "k6-standby", false, true, false, false, nil); err == nil {
+			duration := sinceMs(start)
+			amqp.metrics.push(amqp.metrics.TakeoverDuration, duration, map[string]string{"queue": options.QueueName})
+			_ = ch.Close()
+			return duration, nil
+		}
+
+		select {
+		case <-time.After(retryInterval):
+		case <-amqp.vu.Context().Done():
+			return 0, amqp.vu.Context().Err()
+		case <-deadline:
+			return 0, fmt.Errorf("standby consumer could not attach to %q within %s: %w", options.QueueName, timeout, err)
+		}
+	}
+}
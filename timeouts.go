@@ -0,0 +1,122 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// Operations bounded by TimeoutOptions, used as the operation tag of amqp_operation_timeouts.
+const (
+	operationChannelOpen  = "channel_open"
+	operationConsumeStart = "consume_start"
+	operationPublish      = "publish"
+)
+
+// TimeoutOptions bounds the operations a wedged broker would otherwise block forever, hanging the VU and
+// skewing iteration durations. Timeouts are counted with amqp_operation_timeouts. The client library cannot
+// abort a call, so a timed out operation completes in the background and a channel opened late is closed.
+type TimeoutOptions struct {
+	ChannelOpenMs  int // channel.open, unbounded by default
+	ConsumeStartMs int // basic.consume of listen, getMessage, startConsumers and workflow replies, unbounded by default
+	PublishMs      int // writing a message, blocked while the broker throttles the connection, unbounded by default
+	ConfirmMs      int // confirm wait of publish when confirm_timeout_ms is not set, 5000 by default
+}
+
+// TimeoutError reports an operation the broker did not complete in time.
+type TimeoutError struct {
+	Operation string // channel_open, consume_start or publish
+	Timeout   time.Duration
+}
+
+// Error implements the error interface.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Operation, e.Timeout)
+}
+
+// isTimeout tells whether an operation failed because of one of the TimeoutOptions.
+func isTimeout(err error, operation string) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr) && timeoutErr.Operation == operation
+}
+
+// withTimeout runs an operation, giving up once the timeout elapsed. Unbounded when the timeout is not positive.
+func (m *amqpMetrics) withTimeout(operation string, timeoutMs int, fn func() error) error {
+	if timeoutMs <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		m.push(m.OperationTimeouts, 1, map[string]string{"operation": operation})
+		return &TimeoutError{Operation: operation, Timeout: timeout}
+	}
+}
+
+// openChannel opens a channel on the connection within the channel open timeout.
+func (m *amqpMetrics) openChannel(conn *amqpDriver.Connection, timeouts TimeoutOptions) (*amqpDriver.Channel, error) {
+	if timeouts.ChannelOpenMs <= 0 {
+		return conn.Channel()
+	}
+	opened := make(chan *amqpDriver.Channel, 1)
+	err := m.withTimeout(operationChannelOpen, timeouts.ChannelOpenMs, func() error {
+		ch, err := conn.Channel()
+		opened <- ch
+		return err
+	})
+	if isTimeout(err, operationChannelOpen) {
+		go func() {
+			if ch := <-opened; ch != nil {
+				_ = ch.Close()
+			}
+		}()
+		return nil, err
+	}
+	return <-opened, err
+}
+
+// consume starts a consumer within the consume start timeout.
+func (m *amqpMetrics) consume(ch *amqpDriver.Channel, timeouts TimeoutOptions, queueName string, consumer string,
+	autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqpDriver.Table,
+) (<-chan amqpDriver.Delivery, error) {
+	started := make(chan (<-chan amqpDriver.Delivery), 1)
+	err := m.withTimeout(operationConsumeStart, timeouts.ConsumeStartMs, func() error {
+		msgs, err := ch.Consume(queueName, consumer, autoAck, exclusive, noLocal, noWait, args)
+		started <- msgs
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-started, nil
+}
+
+// channel opens a channel on the session connection, watched for closures by the broker.
+func (amqp *AMQP) channel() (*amqpDriver.Channel, error) {
+	ch, err := amqp.metrics.openChannel(amqp.connection.get(), amqp.options.Timeouts)
+	if err == nil {
+		amqp.watchChannel(ch)
+	}
+	return ch, err
+}
+
+// channel opens a channel on the queue connection.
+func (queue *Queue) channel() (*amqpDriver.Channel, error) {
+	return queue.metrics.openChannel(queue.connection.get(), queue.timeouts)
+}
+
+// channel opens a channel on the exchange connection.
+func (exchange *Exchange) channel() (*amqpDriver.Channel, error) {
+	return exchange.metrics.openChannel(exchange.connection.get(), exchange.timeouts)
+}
@@ -0,0 +1,102 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TopologyOptions describes the queues, exchanges and bindings of a test, declared once in setup.
+type TopologyOptions struct {
+	Exchanges        []ExchangeDeclareOptions
+	Queues           []DeclareOptions // queues without a name get a generated one
+	Bindings         []QueueBindOptions
+	ExchangeBindings []ExchangeBindOptions
+}
+
+// Topology is the handle returned by DeclareTopology. Returned from setup, it is handed over to the VUs
+// as plain data so they use the declared names instead of declaring the topology again.
+type Topology struct {
+	Queues    []string // queue names, in the order of TopologyOptions.Queues
+	Exchanges []string
+}
+
+// topologyQueueRef is the prefix of a binding queue name referring to a declared queue by its position,
+// e.g. "#0" for a queue whose name is generated.
+const topologyQueueRef = "#"
+
+// DeclareTopology declares the exchanges, then the queues and finally the bindings of a topology.
+// Queues are shared by the VUs over their own connections, so exclusive queues are refused.
+func (amqp *AMQP) DeclareTopology(options TopologyOptions) (Topology, error) {
+	topology := Topology{Queues: []string{}, Exchanges: []string{}}
+
+	for _, exchange := range options.Exchanges {
+		if err := amqp.Exchange.Declare(exchange); err != nil {
+			return topology, err
+		}
+		topology.Exchanges = append(topology.Exchanges, exchange.Name)
+	}
+
+	for _, queue := range options.Queues {
+		if queue.Exclusive {
+			return topology, fmt.Errorf("queue %q cannot be shared with the VUs, it is exclusive", queue.Name)
+		}
+		if queue.Name == "" {
+			queue.Name = "k6-" + newUUID()
+		}
+		q, err := amqp.Queue.Declare(queue)
+		if err != nil {
+			return topology, err
+		}
+		topology.Queues = append(topology.Queues, q.Name)
+	}
+
+	for _, binding := range options.Bindings {
+		name, err := topology.resolve(binding.QueueName)
+		if err != nil {
+			return topology, err
+		}
+		binding.QueueName = name
+		if err = amqp.Queue.Bind(binding); err != nil {
+			return topology, err
+		}
+	}
+	for _, binding := range options.ExchangeBindings {
+		if err := amqp.Exchange.Bind(binding); err != nil {
+			return topology, err
+		}
+	}
+	return topology, nil
+}
+
+// DeleteTopology deletes the queues and exchanges of a topology, e.g. in teardown.
+func (amqp *AMQP) DeleteTopology(topology Topology) error {
+	var failures []string
+	for _, name := range topology.Queues {
+		if _, err := amqp.Queue.Delete(name, QueueDeleteOptions{}); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	for _, name := range topology.Exchanges {
+		if err := amqp.Exchange.Delete(name, ExchangeDeleteOptions{}); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// resolve returns the queue name a binding refers to, "#<index>" standing for the declared queue at index.
+func (topology Topology) resolve(name string) (string, error) {
+	if !strings.HasPrefix(name, topologyQueueRef) {
+		return name, nil
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(name, topologyQueueRef))
+	if err != nil || index < 0 || index >= len(topology.Queues) {
+		return "", fmt.Errorf("binding refers to an unknown queue %q", name)
+	}
+	return topology.Queues[index], nil
+}
@@ -0,0 +1,227 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// TracingOptions configures the export of OpenTelemetry spans for the AMQP operations of the VU.
+type TracingOptions struct {
+	Endpoint    string            // OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces
+	ServiceName string            // service.name resource attribute, "k6" by default
+	Headers     map[string]string // extra HTTP headers sent to the collector, e.g. for authentication
+}
+
+// Span kinds and status codes as defined by the OTLP protocol.
+const (
+	spanClient   = 3
+	spanProducer = 4
+	spanConsumer = 5
+
+	statusOk    = 1
+	statusError = 2
+)
+
+const (
+	traceparentHeader    = "traceparent"
+	defaultServiceName   = "k6"
+	tracingFlushInterval = 5 * time.Second
+)
+
+// tracer buffers finished spans and exports them to an OTLP/HTTP collector using the JSON encoding.
+// A nil tracer records nothing, which is the case unless a tracing endpoint is configured.
+type tracer struct {
+	options TracingOptions
+	client  *http.Client
+
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+// span is an operation being traced.
+type span struct {
+	tracer *tracer
+	data   otlpSpan
+	start  time.Time
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func newTracer(options TracingOptions) *tracer {
+	if options.ServiceName == "" {
+		options.ServiceName = defaultServiceName
+	}
+	return &tracer{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// start begins a span, continuing the trace of the W3C traceparent given if it is valid.
+func (t *tracer) start(name string, kind int, traceparent string, attributes map[string]string) *span {
+	if t == nil {
+		return nil
+	}
+
+	s := &span{
+		tracer: t,
+		start:  time.Now(),
+		data: otlpSpan{
+			Name:   name,
+			Kind:   kind,
+			SpanID: randomHex(8),
+		},
+	}
+	if parts := strings.Split(traceparent, "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		s.data.TraceID, s.data.ParentSpanID = parts[1], parts[2]
+	} else {
+		s.data.TraceID = randomHex(16)
+	}
+	for key, value := range attributes {
+		if value != "" {
+			s.data.Attributes = append(s.data.Attributes, otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}})
+		}
+	}
+	return s
+}
+
+// traceparent returns the W3C trace context propagating the span to the consumers of a message.
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID)
+}
+
+// end finishes the span, marking it as failed if the operation returned an error.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+
+	s.data.StartTimeUnixNano = strconv.FormatInt(s.start.UnixNano(), 10)
+	s.data.EndTimeUnixNano = strconv.FormatInt(time.Now().UnixNano(), 10)
+	s.data.Status = otlpStatus{Code: statusOk}
+	if err != nil {
+		s.data.Status = otlpStatus{Code: statusError, Message: err.Error()}
+	}
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s.data)
+}
+
+// run exports the finished spans periodically until the context is done, then exports the remaining ones.
+func (t *tracer) run(ctx context.Context) {
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = t.flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), t.client.Timeout)
+			_ = t.flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush sends the finished spans to the collector. Spans failing to be exported are dropped.
+func (t *tracer) flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{
+					{Key: "service.name", Value: map[string]string{"stringValue": t.options.ServiceName}},
+				},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "xk6-amqp", "version": version},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.options.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.options.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting spans to %s failed with status %s", t.options.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// traceparentOf returns the W3C trace context carried by the message headers, if any.
+func traceparentOf(headers amqpDriver.Table) string {
+	traceparent, _ := headers[traceparentHeader].(string)
+	return traceparent
+}
+
+// messagingAttributes returns the OpenTelemetry messaging semantic attributes of an operation.
+func messagingAttributes(operation string, exchange string, routingKey string) map[string]string {
+	return map[string]string{
+		"messaging.system":                           "rabbitmq",
+		"messaging.operation":                        operation,
+		"messaging.destination.name":                 exchange,
+		"messaging.rabbitmq.destination.routing_key": routingKey,
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
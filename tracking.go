@@ -0,0 +1,156 @@
+package amqp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// headerTrackID identifies a message registered with the correlation tracker.
+const headerTrackID = "x-k6-track-id"
+
+const (
+	maxLostIds           = 100
+	trackingPollInterval = 100 * time.Millisecond
+)
+
+// TrackingReport reconciles the tracked messages published and consumed by all the VUs.
+type TrackingReport struct {
+	Published  int64
+	Delivered  int64    // tracked messages received at least once
+	Lost       int64    // tracked messages never received
+	Duplicates int64    // extra copies received
+	LostIds    []string // the first 100 lost track ids
+}
+
+// trackedMessage is the state of a message registered by a publisher.
+type trackedMessage struct {
+	published time.Time
+	received  int
+}
+
+// correlationTracker is shared by the VUs of the test run: publishers register the messages, consumers
+// mark them received, whichever VU they run in.
+type correlationTracker struct {
+	mu         sync.Mutex
+	messages   map[string]*trackedMessage
+	delivered  int64
+	duplicates int64
+}
+
+func (tracker *correlationTracker) register(id string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.messages == nil {
+		tracker.messages = make(map[string]*trackedMessage)
+	}
+	tracker.messages[id] = &trackedMessage{published: time.Now()}
+}
+
+// forget unregisters a message the broker certainly did not get.
+func (tracker *correlationTracker) forget(id string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.messages, id)
+}
+
+// receive marks a message received, returning its latency and whether it was already received.
+// The last result is false for unknown messages.
+func (tracker *correlationTracker) receive(id string) (time.Duration, bool, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	message, ok := tracker.messages[id]
+	if !ok {
+		return 0, false, false
+	}
+	message.received++
+	if message.received > 1 {
+		tracker.duplicates++
+		return time.Since(message.published), true, true
+	}
+	tracker.delivered++
+	return time.Since(message.published), false, true
+}
+
+func (tracker *correlationTracker) report() TrackingReport {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	report := TrackingReport{
+		Published:  int64(len(tracker.messages)),
+		Delivered:  tracker.delivered,
+		Duplicates: tracker.duplicates,
+	}
+	report.Lost = report.Published - report.Delivered
+	for id, message := range tracker.messages {
+		if message.received == 0 {
+			report.LostIds = append(report.LostIds, id)
+		}
+	}
+	sort.Strings(report.LostIds)
+	if len(report.LostIds) > maxLostIds {
+		report.LostIds = report.LostIds[:maxLostIds]
+	}
+	return report
+}
+
+// stampTracked registers a message published with the Track option, unless it already carries a track id.
+func (amqp *AMQP) stampTracked(options PublishOptions) PublishOptions {
+	if !options.Track {
+		return options
+	}
+	if _, ok := options.Headers[headerTrackID]; ok {
+		return options
+	}
+	id := newUUID()
+	options.Headers = withField(options.Headers, headerTrackID, id)
+	amqp.tracked.register(id)
+	return options
+}
+
+// forgetTracked unregisters a tracked message once its publish failed for sure.
+func (amqp *AMQP) forgetTracked(options PublishOptions, err error) {
+	if _, ambiguous := ambiguousFailure(err); err == nil || ambiguous {
+		return
+	}
+	if id, ok := options.Headers[headerTrackID]; ok {
+		amqp.tracked.forget(fmt.Sprint(id))
+	}
+}
+
+// receiveTracked marks a tracked delivery received, emitting its end to end latency or counting a duplicate.
+func (amqp *AMQP) receiveTracked(d amqpDriver.Delivery, tags map[string]string) {
+	id, ok := d.Headers[headerTrackID]
+	if !ok {
+		return
+	}
+	latency, duplicate, known := amqp.tracked.receive(fmt.Sprint(id))
+	switch {
+	case !known:
+	case duplicate:
+		amqp.metrics.push(amqp.metrics.TrackedDuplicates, 1, tags)
+	default:
+		amqp.metrics.push(amqp.metrics.TrackedLatency, float64(latency)/float64(time.Millisecond), tags)
+	}
+}
+
+// VerifyTracked waits up to timeoutMs for every tracked message to be received, then reports the messages
+// delivered and lost as the amqp_tracked_delivered and amqp_tracked_lost counters. Call it once at the end
+// of the test, e.g. from teardown.
+func (amqp *AMQP) VerifyTracked(timeoutMs int) (TrackingReport, error) {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	report := amqp.tracked.report()
+	for report.Lost > 0 && time.Now().Before(deadline) {
+		if err := sleepContext(amqp.vu.Context(), trackingPollInterval); err != nil {
+			return report, err
+		}
+		report = amqp.tracked.report()
+	}
+
+	tags := map[string]string{}
+	amqp.metrics.push(amqp.metrics.TrackedDelivered, float64(report.Delivered), tags)
+	amqp.metrics.push(amqp.metrics.TrackedLost, float64(report.Lost), tags)
+	return report, nil
+}
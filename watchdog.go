@@ -0,0 +1,84 @@
+package amqp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const minStallCheckInterval = 100 * time.Millisecond
+
+// stallWatchdog flags a consumer receiving nothing for longer than a threshold.
+// A nil watchdog ignores every call, which is the case unless a stall threshold is configured.
+type stallWatchdog struct {
+	amqp      *AMQP
+	threshold time.Duration
+	tags      map[string]string
+	last      int64 // unix nanoseconds of the last delivery
+	done      chan struct{}
+}
+
+// watchStalls starts the watchdog of a consumer, counting every silence longer than the threshold with
+// amqp_consumer_stalled once detected and its duration with amqp_consumer_stall_duration once over.
+func (amqp *AMQP) watchStalls(thresholdMs int, queueName string, consumer string) *stallWatchdog {
+	if thresholdMs <= 0 {
+		return nil
+	}
+
+	w := &stallWatchdog{
+		amqp:      amqp,
+		threshold: time.Duration(thresholdMs) * time.Millisecond,
+		tags:      map[string]string{"queue": queueName, "consumer": consumer},
+		last:      time.Now().UnixNano(),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// received resets the silence of the consumer.
+func (w *stallWatchdog) received() {
+	if w == nil {
+		return
+	}
+	last := atomic.SwapInt64(&w.last, time.Now().UnixNano())
+	if silence := time.Duration(time.Now().UnixNano() - last); silence >= w.threshold {
+		w.amqp.metrics.push(w.amqp.metrics.ConsumerStallDuration, float64(silence)/float64(time.Millisecond), w.tags)
+	}
+}
+
+// stop ends the watchdog once the consumer is gone.
+func (w *stallWatchdog) stop() {
+	if w != nil {
+		close(w.done)
+	}
+}
+
+func (w *stallWatchdog) run() {
+	interval := w.threshold / 4
+	if interval < minStallCheckInterval {
+		interval = minStallCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reported int64 // last delivery time a stall was reported for
+	for {
+		select {
+		case <-ticker.C:
+			last := atomic.LoadInt64(&w.last)
+			silence := time.Duration(time.Now().UnixNano() - last)
+			if silence < w.threshold || last == reported {
+				continue
+			}
+			reported = last
+			w.amqp.metrics.push(w.amqp.metrics.ConsumerStalled, 1, w.tags)
+			w.amqp.events.emit("stall", map[string]interface{}{
+				"queue": w.tags["queue"], "consumer": w.tags["consumer"], "silence_ms": float64(silence) / float64(time.Millisecond),
+			})
+		case <-w.done:
+			return
+		case <-w.amqp.vu.Context().Done():
+			return
+		}
+	}
+}
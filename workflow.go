@@ -0,0 +1,188 @@
+package amqp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+)
+
+// WorkflowStep defines a single publish (and optional reply wait) of a multi-step workflow.
+type WorkflowStep struct {
+	Name        string
+	Exchange    string
+	RoutingKey  string
+	Body        string
+	ContentType string
+	Headers     amqpDriver.Table
+	AwaitReply  bool // wait for a reply with the workflow correlation id, sent to the reply_to queue of the message
+	TimeoutMs   int  // how long to wait for the reply, 5000 by default
+}
+
+// WorkflowOptions defines a named multi-step workflow (saga) measured as a single flow.
+type WorkflowOptions struct {
+	Name          string
+	CorrelationId string // shared by every step, generated if empty
+	Steps         []WorkflowStep
+}
+
+// WorkflowStepResult holds the outcome of a single workflow step.
+type WorkflowStepResult struct {
+	Name     string
+	Duration float64 // milliseconds
+	Reply    string
+}
+
+// WorkflowResult holds the outcome of a workflow run.
+type WorkflowResult struct {
+	Name          string
+	CorrelationId string
+	Duration      float64 // milliseconds
+	Steps         []WorkflowStepResult
+}
+
+const defaultWorkflowStepTimeout = 5 * time.Second
+
+// RunWorkflow executes the workflow steps in order, emitting per-step and whole-flow duration metrics.
+func (amqp *AMQP) RunWorkflow(options WorkflowOptions) (result WorkflowResult, err error) {
+	defer func() {
+		err = structuredError(err)
+	}()
+
+	result = WorkflowResult{
+		Name:          options.Name,
+		CorrelationId: options.CorrelationId,
+	}
+	if result.CorrelationId == "" {
+		result.CorrelationId = newUUID()
+	}
+
+	awaitsReply := false
+	for _, step := range options.Steps {
+		awaitsReply = awaitsReply || step.AwaitReply
+	}
+	var replies *workflowReplies
+	if awaitsReply {
+		var err error
+		if replies, err = amqp.workflowReplies(); err != nil {
+			return result, fmt.Errorf("workflow %q: %w", options.Name, err)
+		}
+		defer replies.close()
+	}
+
+	start := time.Now()
+	for i, step := range options.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step%d", i+1)
+		}
+
+		stepResult, err := amqp.runWorkflowStep(step, result.CorrelationId, replies)
+		result.Steps = append(result.Steps, stepResult)
+		if err != nil {
+			return result, fmt.Errorf("workflow %q step %q: %w", options.Name, step.Name, err)
+		}
+		amqp.metrics.push(amqp.metrics.WorkflowStepDuration, stepResult.Duration, map[string]string{
+			"flow": options.Name,
+			"step": step.Name,
+		})
+	}
+
+	result.Duration = sinceMs(start)
+	amqp.metrics.push(amqp.metrics.WorkflowDuration, result.Duration, map[string]string{"flow": options.Name})
+	return result, nil
+}
+
+// workflowReplies is the private queue a flow gets its replies on, so that the VUs running the same flow do
+// not get the replies of each other.
+type workflowReplies struct {
+	ch         *amqpDriver.Channel
+	queue      string
+	deliveries <-chan amqpDriver.Delivery
+}
+
+// workflowReplies declares a server named exclusive queue and consumes it.
+func (amqp *AMQP) workflowReplies() (*workflowReplies, error) {
+	ch, err := amqp.channel()
+	if err != nil {
+		return nil, err
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	var deliveries <-chan amqpDriver.Delivery
+	if err == nil {
+		deliveries, err = amqp.metrics.consume(ch, amqp.options.Timeouts, q.Name, "", true, true, false, false, nil)
+	}
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	return &workflowReplies{ch: ch, queue: q.Name, deliveries: deliveries}, nil
+}
+
+func (replies *workflowReplies) close() {
+	_ = replies.ch.Close()
+}
+
+func (amqp *AMQP) runWorkflowStep(step WorkflowStep, correlationID string, replies *workflowReplies) (WorkflowStepResult, error) {
+	result := WorkflowStepResult{Name: step.Name}
+
+	publish := PublishOptions{
+		Exchange:      step.Exchange,
+		RoutingKey:    step.RoutingKey,
+		Body:          step.Body,
+		ContentType:   step.ContentType,
+		Headers:       step.Headers,
+		CorrelationId: correlationID,
+	}
+	if step.AwaitReply {
+		publish.ReplyTo = replies.queue
+	}
+	start := time.Now()
+	_, err := amqp.Publish(publish)
+	if err != nil || !step.AwaitReply {
+		result.Duration = sinceMs(start)
+		return result, err
+	}
+
+	timeout := defaultWorkflowStepTimeout
+	if step.TimeoutMs > 0 {
+		timeout = time.Duration(step.TimeoutMs) * time.Millisecond
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case d, ok := <-replies.deliveries:
+			if !ok {
+				return result, amqpDriver.ErrClosed
+			}
+			if d.CorrelationId != correlationID {
+				// Only the flow gets replies on its queue, this one answers nothing it is waiting for.
+				continue
+			}
+			result.Duration = sinceMs(start)
+			result.Reply = string(d.Body)
+			return result, nil
+		case <-amqp.vu.Context().Done():
+			return result, amqp.vu.Context().Err()
+		case <-timer.C:
+			result.Duration = sinceMs(start)
+			return result, fmt.Errorf("no reply received within %s", timeout)
+		}
+	}
+}
+
+// sinceMs returns the time elapsed since start in milliseconds.
+func sinceMs(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+// newUUID returns a random (version 4) UUID string.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
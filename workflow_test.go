@@ -0,0 +1,99 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	amqpDriver "github.com/rabbitmq/amqp091-go"
+	"go.k6.io/k6/js/modulestest"
+)
+
+// respond answers the requests of a queue on their reply_to queue with their correlation id until ctx is done.
+func respond(ctx context.Context, t *testing.T, url string, queue string) {
+	t.Helper()
+
+	conn, err := amqpDriver.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ch.QueueDeclare(queue, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	requests, err := ch.Consume(queue, "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer func() {
+			_ = conn.Close()
+		}()
+		for {
+			select {
+			case d := <-requests:
+				_ = ch.PublishWithContext(ctx, "", d.ReplyTo, false, false, amqpDriver.Publishing{
+					CorrelationId: d.CorrelationId,
+					Body:          append([]byte("re: "), d.Body...),
+				})
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func TestWorkflowRepliesArePrivateToTheFlow(t *testing.T) {
+	t.Parallel()
+
+	root := &RootModule{}
+	url, err := root.loopback.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	respond(ctx, t, url, "workflow-service")
+
+	// The VUs run the same flow concurrently, each must only get its own replies.
+	const vus = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, vus)
+	for i := 0; i < vus; i++ {
+		amqp := root.instance(modulestest.NewRuntime(t).VU)
+		if err = amqp.Start(Options{Loopback: true}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(vu int) {
+			defer wg.Done()
+			for iteration := 0; iteration < 10; iteration++ {
+				body := fmt.Sprintf("vu%d-%d", vu, iteration)
+				result, err := amqp.RunWorkflow(WorkflowOptions{
+					Name: "saga",
+					Steps: []WorkflowStep{
+						{Name: "reserve", RoutingKey: "workflow-service", Body: body, AwaitReply: true, TimeoutMs: 2000},
+						{Name: "notify", RoutingKey: "workflow-notifications", Body: body},
+					},
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if reply := result.Steps[0].Reply; reply != "re: "+body {
+					errs <- fmt.Errorf("vu %d got reply %q, expected %q", vu, reply, "re: "+body)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}